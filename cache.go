@@ -1,5 +1,7 @@
 package cache
 
+import "time"
+
 // Cache is the contract for all of the cache backends that are supported by
 // this package
 type Cache interface {
@@ -13,3 +15,89 @@ type Cache interface {
 	// Delete deletes single item from backend
 	Delete(key string) error
 }
+
+// NegativeCache is implemented by the cache backends which can cache the
+// absence of a key, so the source of truth is not queried for it again
+type NegativeCache interface {
+	// SetNotFound marks the key as not found for the given duration, Get
+	// returns ErrCachedNotFound for the key until it expires or is set
+	SetNotFound(key string, ttl time.Duration) error
+}
+
+// CostSetter is implemented by the cache backends which can expire keys
+// early, before their actual expiry, for preventing stampedes on the source
+// of truth. Keys which are expensive to recompute expire earlier
+type CostSetter interface {
+	// SetWithCost sets a single item to the backend with the duration it
+	// took to compute the value
+	SetWithCost(key string, value interface{}, cost time.Duration) error
+}
+
+// EvictionNotifier is implemented by the fixed size cache backends which evict
+// items on their own, so the caches wrapping them can drop their state of the
+// evicted keys
+type EvictionNotifier interface {
+	// OnEvict sets the function which is called with the key of every
+	// evicted item
+	OnEvict(f func(key string))
+}
+
+// ExpiringCache is implemented by the cache backends which can set a key
+// with its own ttl
+type ExpiringCache interface {
+	// SetEx sets a single item to the backend, it expires after the given
+	// duration
+	SetEx(key string, duration time.Duration, value interface{}) error
+}
+
+// TTLReader is implemented by the cache backends which can report the
+// remaining time to live of a key
+type TTLReader interface {
+	// TTL returns the remaining time to live of the key, zero if the key
+	// does not expire. It returns ErrNotFound if the key does not exist
+	TTL(key string) (time.Duration, error)
+}
+
+// KeyLister is implemented by the cache backends which can list their keys
+type KeyLister interface {
+	// Keys returns the keys of the items in the backend, in no particular
+	// order
+	Keys() []string
+}
+
+// keyListProber is implemented by the wrappers which can list their keys only
+// when their underlying cache is a KeyLister
+type keyListProber interface {
+	canListKeys() bool
+}
+
+// listKeys returns the KeyLister of the cache, ok is false if the cache can
+// not list its keys
+func listKeys(c Cache) (lister KeyLister, ok bool) {
+	if lister, ok = c.(KeyLister); !ok {
+		return nil, false
+	}
+
+	if p, isProber := c.(keyListProber); isProber && !p.canListKeys() {
+		return nil, false
+	}
+
+	return lister, true
+}
+
+// Expirer is implemented by the cache backends which can change the ttl of a
+// key without setting its value again
+type Expirer interface {
+	// Expire sets the ttl of the key, zero ttl never expires. It returns
+	// ErrNotFound if the key does not exist
+	Expire(key string, ttl time.Duration) error
+}
+
+// Counter is implemented by the cache backends which can increment integer
+// values atomically
+type Counter interface {
+	// Incr adds delta to the integer value of the key and returns the new
+	// value, missing keys are set to delta. It returns ErrNotInteger if the
+	// value is not an integer
+	Incr(key string, delta int64) (int64, error)
+}
@@ -0,0 +1,434 @@
+// Package cachetest provides a conformance test suite for the implementations
+// of cache.Cache and cache.ShardedCache.
+//
+// usage:
+//
+//	func TestMyCache(t *testing.T) {
+//		cachetest.TestCache(t, func() cache.Cache { return NewMyCache() })
+//	}
+package cachetest
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/koding/cache"
+	"github.com/koding/cache/clock"
+	"github.com/koding/cache/clock/clocktest"
+)
+
+// concurrency is the number of goroutines used in concurrency tests
+const concurrency = 8
+
+// iterations is the number of operations done by every goroutine in
+// concurrency tests
+const iterations = 200
+
+// TestCache runs the basic CRUD conformance tests against the caches created
+// by newCache. Every test is run with a new cache
+func TestCache(t *testing.T, newCache func() cache.Cache) {
+	t.Run("GetSet", func(t *testing.T) { testGetSet(t, newCache()) })
+	t.Run("GetNotFound", func(t *testing.T) { testGetNotFound(t, newCache()) })
+	t.Run("Overwrite", func(t *testing.T) { testOverwrite(t, newCache()) })
+	t.Run("Delete", func(t *testing.T) { testDelete(t, newCache()) })
+	t.Run("NilValue", func(t *testing.T) { testNilValue(t, newCache()) })
+}
+
+// TestCacheConcurrency runs Get, Set and Delete operations from multiple
+// goroutines against the cache created by newCache, it is meant to be run
+// with the race detector for thread safe caches
+func TestCacheConcurrency(t *testing.T, newCache func() cache.Cache) {
+	c := newCache()
+
+	run(t, func(g, i int) error {
+		key := fmt.Sprintf("test_key%d", i%10)
+
+		switch i % 3 {
+		case 0:
+			return c.Set(key, g)
+		case 1:
+			_, err := c.Get(key)
+			if err == cache.ErrNotFound {
+				return nil
+			}
+
+			return err
+		default:
+			return c.Delete(key)
+		}
+	})
+}
+
+// TestCacheTTL runs the expiration tests against the caches created by
+// newCache. The cache must use the given clock for expiring its keys
+func TestCacheTTL(t *testing.T, newCache func(ttl time.Duration, c clock.Clock) cache.Cache) {
+	ttl := time.Minute
+
+	t.Run("Expire", func(t *testing.T) {
+		clock := clocktest.NewFake(time.Now())
+		c := newCache(ttl, clock)
+		mustSet(t, c, "test_key", "test_data")
+
+		clock.Advance(ttl / 2)
+		mustGet(t, c, "test_key", "test_data")
+
+		clock.Advance(ttl)
+		mustNotFound(t, c, "test_key")
+	})
+
+	t.Run("OverwriteRefreshes", func(t *testing.T) {
+		clock := clocktest.NewFake(time.Now())
+		c := newCache(ttl, clock)
+		mustSet(t, c, "test_key", "test_data")
+
+		clock.Advance(ttl / 2)
+		mustSet(t, c, "test_key", "test_data2")
+
+		clock.Advance(ttl / 2)
+		mustGet(t, c, "test_key", "test_data2")
+
+		clock.Advance(ttl)
+		mustNotFound(t, c, "test_key")
+	})
+}
+
+// TestCacheEviction runs the capacity tests against the caches created by
+// newCache. The cache must not hold more than size items
+func TestCacheEviction(t *testing.T, newCache func(size int) cache.Cache) {
+	size := 4
+	c := newCache(size)
+
+	for i := 0; i < size*2; i++ {
+		mustSet(t, c, fmt.Sprintf("test_key%d", i), i)
+	}
+
+	found := 0
+	for i := 0; i < size*2; i++ {
+		_, err := c.Get(fmt.Sprintf("test_key%d", i))
+		switch err {
+		case nil:
+			found++
+		case cache.ErrNotFound:
+		default:
+			t.Fatalf("should not give err while getting item: %q", err)
+		}
+	}
+
+	if found > size {
+		t.Fatalf("cache should hold at most %d items, got: %d", size, found)
+	}
+
+	// the last item is never the one to be evicted
+	mustGet(t, c, fmt.Sprintf("test_key%d", size*2-1), size*2-1)
+}
+
+// TestShardedCache runs the basic CRUD conformance tests against the sharded
+// caches created by newCache. Every test is run with a new cache
+func TestShardedCache(t *testing.T, newCache func() cache.ShardedCache) {
+	t.Run("GetSet", func(t *testing.T) { testShardedGetSet(t, newCache()) })
+	t.Run("GetNotFound", func(t *testing.T) { testShardedGetNotFound(t, newCache()) })
+	t.Run("Delete", func(t *testing.T) { testShardedDelete(t, newCache()) })
+	t.Run("DeleteShard", func(t *testing.T) { testDeleteShard(t, newCache()) })
+	t.Run("NilValue", func(t *testing.T) { testShardedNilValue(t, newCache()) })
+}
+
+// TestShardedCacheEviction runs the capacity tests against the sharded caches
+// created by newCache. Every shard must not hold more than size items, and a
+// full shard must not evict the items of the other shards
+func TestShardedCacheEviction(t *testing.T, newCache func(size int) cache.ShardedCache) {
+	size := 4
+	c := newCache(size)
+
+	shardedSet(t, c, "user2", "test_key", "test_data")
+
+	for i := 0; i < size*2; i++ {
+		shardedSet(t, c, "user1", fmt.Sprintf("test_key%d", i), i)
+	}
+
+	found := 0
+	for i := 0; i < size*2; i++ {
+		_, err := c.Get("user1", fmt.Sprintf("test_key%d", i))
+		switch err {
+		case nil:
+			found++
+		case cache.ErrNotFound:
+		default:
+			t.Fatalf("should not give err while getting item: %q", err)
+		}
+	}
+
+	if found > size {
+		t.Fatalf("shard should hold at most %d items, got: %d", size, found)
+	}
+
+	// the last item is never the one to be evicted
+	shardedGet(t, c, "user1", fmt.Sprintf("test_key%d", size*2-1), size*2-1)
+	shardedGet(t, c, "user2", "test_key", "test_data")
+}
+
+// TestShardedCacheConcurrency runs Get, Set, Delete and DeleteShard
+// operations from multiple goroutines against the sharded cache created by
+// newCache, it is meant to be run with the race detector for thread safe
+// caches
+func TestShardedCacheConcurrency(t *testing.T, newCache func() cache.ShardedCache) {
+	c := newCache()
+
+	run(t, func(g, i int) error {
+		shardID := fmt.Sprintf("user%d", i%3)
+		key := fmt.Sprintf("test_key%d", i%10)
+
+		switch i % 4 {
+		case 0:
+			return c.Set(shardID, key, g)
+		case 1:
+			_, err := c.Get(shardID, key)
+			if err == cache.ErrNotFound {
+				return nil
+			}
+
+			return err
+		case 2:
+			return c.Delete(shardID, key)
+		default:
+			return c.DeleteShard(shardID)
+		}
+	})
+}
+
+// TestShardedCacheTTL runs the expiration tests against the sharded caches
+// created by newCache. The cache must use the given clock for expiring its
+// keys
+func TestShardedCacheTTL(t *testing.T, newCache func(ttl time.Duration, c clock.Clock) cache.ShardedCache) {
+	ttl := time.Minute
+	clock := clocktest.NewFake(time.Now())
+	c := newCache(ttl, clock)
+
+	if err := c.Set("user1", "test_key", "test_data"); err != nil {
+		t.Fatalf("should not give err while setting item: %q", err)
+	}
+
+	clock.Advance(ttl / 2)
+	if data, err := c.Get("user1", "test_key"); err != nil || data != "test_data" {
+		t.Fatalf("test_key should be in the cache, got: %v, %v", data, err)
+	}
+
+	clock.Advance(ttl)
+	if _, err := c.Get("user1", "test_key"); err != cache.ErrNotFound {
+		t.Fatalf("error should equal to %q but got: %v", cache.ErrNotFound, err)
+	}
+}
+
+func testGetSet(t *testing.T, c cache.Cache) {
+	mustSet(t, c, "test_key", "test_data")
+	mustSet(t, c, "test_key2", "test_data2")
+	mustGet(t, c, "test_key", "test_data")
+	mustGet(t, c, "test_key2", "test_data2")
+}
+
+func testGetNotFound(t *testing.T, c cache.Cache) {
+	data, err := c.Get("test_key")
+	if err != cache.ErrNotFound {
+		t.Fatalf("error should equal to %q but got: %v", cache.ErrNotFound, err)
+	}
+
+	if data != nil {
+		t.Fatal("data should be nil")
+	}
+}
+
+func testOverwrite(t *testing.T, c cache.Cache) {
+	mustSet(t, c, "test_key", "test_data")
+	mustSet(t, c, "test_key", "test_data2")
+	mustGet(t, c, "test_key", "test_data2")
+}
+
+func testDelete(t *testing.T, c cache.Cache) {
+	mustSet(t, c, "test_key", "test_data")
+	mustSet(t, c, "test_key2", "test_data2")
+
+	if err := c.Delete("test_key3"); err != nil {
+		t.Fatalf("non-existing item should not give error: %q", err)
+	}
+
+	if err := c.Delete("test_key"); err != nil {
+		t.Fatalf("existing item should not give error: %q", err)
+	}
+
+	mustNotFound(t, c, "test_key")
+	mustGet(t, c, "test_key2", "test_data2")
+}
+
+func testNilValue(t *testing.T, c cache.Cache) {
+	mustSet(t, c, "test_key", nil)
+	mustGet(t, c, "test_key", nil)
+
+	if err := c.Delete("test_key"); err != nil {
+		t.Fatalf("should not give err while deleting item: %q", err)
+	}
+
+	mustNotFound(t, c, "test_key")
+}
+
+func testShardedGetSet(t *testing.T, c cache.ShardedCache) {
+	shardedSet(t, c, "user1", "test_key", "test_data")
+	shardedSet(t, c, "user1", "test_key2", "test_data2")
+	shardedSet(t, c, "user2", "test_key", "test_data3")
+
+	shardedGet(t, c, "user1", "test_key", "test_data")
+	shardedGet(t, c, "user1", "test_key2", "test_data2")
+	shardedGet(t, c, "user2", "test_key", "test_data3")
+}
+
+func testShardedGetNotFound(t *testing.T, c cache.ShardedCache) {
+	shardedSet(t, c, "user1", "test_key", "test_data")
+
+	shardedNotFound(t, c, "user1", "test_key2")
+	shardedNotFound(t, c, "user2", "test_key")
+}
+
+func testShardedDelete(t *testing.T, c cache.ShardedCache) {
+	shardedSet(t, c, "user1", "test_key", "test_data")
+	shardedSet(t, c, "user1", "test_key2", "test_data2")
+
+	if err := c.Delete("user1", "test_key3"); err != nil {
+		t.Fatalf("non-existing item should not give error: %q", err)
+	}
+
+	if err := c.Delete("user2", "test_key"); err != nil {
+		t.Fatalf("non-existing shard should not give error: %q", err)
+	}
+
+	if err := c.Delete("user1", "test_key"); err != nil {
+		t.Fatalf("existing item should not give error: %q", err)
+	}
+
+	shardedNotFound(t, c, "user1", "test_key")
+	shardedGet(t, c, "user1", "test_key2", "test_data2")
+}
+
+func testDeleteShard(t *testing.T, c cache.ShardedCache) {
+	shardedSet(t, c, "user1", "test_key", "test_data")
+	shardedSet(t, c, "user1", "test_key2", "test_data2")
+	shardedSet(t, c, "user2", "test_key", "test_data")
+
+	if err := c.DeleteShard("user1"); err != nil {
+		t.Fatalf("existing shard should not give error: %q", err)
+	}
+
+	if err := c.DeleteShard("user3"); err != nil {
+		t.Fatalf("non-existing shard should not give error: %q", err)
+	}
+
+	shardedNotFound(t, c, "user1", "test_key")
+	shardedNotFound(t, c, "user1", "test_key2")
+	shardedGet(t, c, "user2", "test_key", "test_data")
+}
+
+func testShardedNilValue(t *testing.T, c cache.ShardedCache) {
+	shardedSet(t, c, "user1", "test_key", nil)
+	shardedGet(t, c, "user1", "test_key", nil)
+
+	if err := c.Delete("user1", "test_key"); err != nil {
+		t.Fatalf("should not give err while deleting item: %q", err)
+	}
+
+	shardedNotFound(t, c, "user1", "test_key")
+}
+
+// run calls f from concurrent goroutines, g is the goroutine's index and i is
+// the iteration count
+func run(t *testing.T, f func(g, i int) error) {
+	var wg sync.WaitGroup
+	errs := make(chan error, concurrency)
+
+	for g := 0; g < concurrency; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+
+			for i := 0; i < iterations; i++ {
+				if err := f(g, i); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(g)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatalf("should not give err while running concurrently: %q", err)
+	}
+}
+
+func mustSet(t *testing.T, c cache.Cache, key string, value interface{}) {
+	t.Helper()
+
+	if err := c.Set(key, value); err != nil {
+		t.Fatalf("should not give err while setting %s: %q", key, err)
+	}
+}
+
+func mustGet(t *testing.T, c cache.Cache, key string, value interface{}) {
+	t.Helper()
+
+	data, err := c.Get(key)
+	if err != nil {
+		t.Fatalf("%s should be in the cache: %q", key, err)
+	}
+
+	if data != value {
+		t.Fatalf("data should equal: %v, but got: %v", value, data)
+	}
+}
+
+func mustNotFound(t *testing.T, c cache.Cache, key string) {
+	t.Helper()
+
+	data, err := c.Get(key)
+	if err != cache.ErrNotFound {
+		t.Fatalf("error should equal to %q but got: %v", cache.ErrNotFound, err)
+	}
+
+	if data != nil {
+		t.Fatal("data should be nil")
+	}
+}
+
+func shardedSet(t *testing.T, c cache.ShardedCache, shardID, key string, value interface{}) {
+	t.Helper()
+
+	if err := c.Set(shardID, key, value); err != nil {
+		t.Fatalf("should not give err while setting %s/%s: %q", shardID, key, err)
+	}
+}
+
+func shardedGet(t *testing.T, c cache.ShardedCache, shardID, key string, value interface{}) {
+	t.Helper()
+
+	data, err := c.Get(shardID, key)
+	if err != nil {
+		t.Fatalf("%s/%s should be in the cache: %q", shardID, key, err)
+	}
+
+	if data != value {
+		t.Fatalf("data should equal: %v, but got: %v", value, data)
+	}
+}
+
+func shardedNotFound(t *testing.T, c cache.ShardedCache, shardID, key string) {
+	t.Helper()
+
+	data, err := c.Get(shardID, key)
+	if err != cache.ErrNotFound {
+		t.Fatalf("error should equal to %q but got: %v", cache.ErrNotFound, err)
+	}
+
+	if data != nil {
+		t.Fatal("data should be nil")
+	}
+}
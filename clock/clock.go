@@ -0,0 +1,54 @@
+// Package clock provides the time source used by the expiring caches, so the
+// passing of time can be controlled in tests.
+package clock
+
+import "time"
+
+// Clock is the contract for the time sources used by the cache backends
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+
+	// NewTicker returns a new Ticker which ticks with the given interval
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker is the contract for the tickers returned by a Clock
+type Ticker interface {
+	// C returns the channel on which the ticks are delivered
+	C() <-chan time.Time
+
+	// Stop turns off the ticker, no more ticks will be sent after Stop
+	Stop()
+}
+
+// New returns a Clock backed by the time package
+func New() Clock {
+	return realClock{}
+}
+
+type realClock struct{}
+
+// Now returns time.Now()
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// NewTicker returns a ticker backed by time.NewTicker
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return &realTicker{ticker: time.NewTicker(d)}
+}
+
+type realTicker struct {
+	ticker *time.Ticker
+}
+
+// C returns the channel of the underlying time.Ticker
+func (t *realTicker) C() <-chan time.Time {
+	return t.ticker.C
+}
+
+// Stop stops the underlying time.Ticker
+func (t *realTicker) Stop() {
+	t.ticker.Stop()
+}
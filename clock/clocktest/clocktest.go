@@ -0,0 +1,125 @@
+// Package clocktest provides a fake clock for testing the expiring caches
+// without sleeping.
+package clocktest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/koding/cache/clock"
+)
+
+// Fake is a manually driven clock.Clock. Time only moves forward when Advance
+// is called
+type Fake struct {
+	// Mutex is used for handling the concurrent
+	// read/write requests for the clock
+	sync.Mutex
+
+	// now holds the current time of the clock
+	now time.Time
+
+	// tickers holds the tickers which are not stopped yet
+	tickers map[*ticker]struct{}
+}
+
+// NewFake creates a fake clock which starts at the given time
+func NewFake(now time.Time) *Fake {
+	return &Fake{
+		now:     now,
+		tickers: make(map[*ticker]struct{}),
+	}
+}
+
+// Now returns the current time of the clock
+func (f *Fake) Now() time.Time {
+	f.Lock()
+	defer f.Unlock()
+
+	return f.now
+}
+
+// NewTicker creates a ticker which ticks every time the clock is advanced
+// past its next tick time
+func (f *Fake) NewTicker(d time.Duration) clock.Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	t := &ticker{
+		clock:    f,
+		c:        make(chan time.Time),
+		done:     make(chan struct{}),
+		interval: d,
+		next:     f.now.Add(d),
+	}
+	f.tickers[t] = struct{}{}
+
+	return t
+}
+
+// Advance moves the clock forward by the given duration and fires the tickers
+// which are due. Advance blocks until every fired tick is received, so when a
+// second Advance call returns, the work triggered by the previous tick has
+// already been completed by a receiver which handles its ticks in a loop
+func (f *Fake) Advance(d time.Duration) {
+	f.Lock()
+	f.now = f.now.Add(d)
+	now := f.now
+
+	var due []*ticker
+	for t := range f.tickers {
+		if !t.next.After(now) {
+			due = append(due, t)
+		}
+	}
+	f.Unlock()
+
+	for _, t := range due {
+		t.fire(now)
+	}
+}
+
+// ticker is the clock.Ticker implementation of Fake
+type ticker struct {
+	clock    *Fake
+	c        chan time.Time
+	done     chan struct{}
+	once     sync.Once
+	interval time.Duration
+
+	// next holds the time of the next tick, guarded by the clock's mutex
+	next time.Time
+}
+
+// C returns the channel on which the ticks are delivered
+func (t *ticker) C() <-chan time.Time {
+	return t.c
+}
+
+// Stop turns off the ticker
+func (t *ticker) Stop() {
+	t.clock.Lock()
+	delete(t.clock.tickers, t)
+	t.clock.Unlock()
+
+	t.once.Do(func() { close(t.done) })
+}
+
+// fire delivers a single tick for all the missed intervals, as time.Ticker
+// drops ticks for slow receivers
+func (t *ticker) fire(now time.Time) {
+	t.clock.Lock()
+	for !t.next.After(now) {
+		t.next = t.next.Add(t.interval)
+	}
+	t.clock.Unlock()
+
+	select {
+	case t.c <- now:
+	case <-t.done:
+	}
+}
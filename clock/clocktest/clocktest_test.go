@@ -0,0 +1,50 @@
+package clocktest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeNow(t *testing.T) {
+	now := time.Now()
+	clock := NewFake(now)
+
+	if !clock.Now().Equal(now) {
+		t.Fatal("clock should start at the given time")
+	}
+
+	clock.Advance(time.Minute)
+	if !clock.Now().Equal(now.Add(time.Minute)) {
+		t.Fatal("clock should be advanced by a minute")
+	}
+}
+
+func TestFakeTicker(t *testing.T) {
+	clock := NewFake(time.Now())
+	ticker := clock.NewTicker(time.Second)
+	ticks := make(chan time.Time, 10)
+	go func() {
+		for tick := range ticker.C() {
+			ticks <- tick
+		}
+	}()
+
+	clock.Advance(500 * time.Millisecond)
+	if len(ticks) != 0 {
+		t.Fatal("ticker should not tick before its interval")
+	}
+
+	// missed ticks are dropped, as time.Ticker does
+	clock.Advance(3 * time.Second)
+	clock.Advance(time.Second)
+	if tick := <-ticks; !tick.Equal(clock.Now().Add(-time.Second)) {
+		t.Fatal("first tick should be at the time of the first advance")
+	}
+	<-ticks
+
+	ticker.Stop()
+	clock.Advance(time.Hour)
+	if len(ticks) != 0 {
+		t.Fatal("stopped ticker should not tick")
+	}
+}
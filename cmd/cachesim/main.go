@@ -0,0 +1,154 @@
+// Command cachesim replays access traces against the caching policies of the
+// cache package and reports their hit ratios.
+//
+// usage:
+//
+//	cachesim -format arc -policies lru,lfu -sizes 1000,10000 trace.arc
+//	cachesim -policies lru-ttl,lfu-ttl -ttl 5000 trace.txt
+//
+// Trace is read from the standard input if no file is given. It is streamed
+// through all the simulations, so traces larger than the memory can be
+// replayed. Keys of the ttl policies expire after the given number of accesses.
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "cachesim:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("cachesim", flag.ContinueOnError)
+	format := flags.String("format", "key", "trace format: key, lirs, arc or rec")
+	policyList := flags.String("policies", strings.Join(policyNames(), ","), "comma separated caching policies")
+	sizeList := flags.String("sizes", "100,1000,10000", "comma separated cache capacities")
+	ttl := flags.Int("ttl", 0, "accesses after which the keys of the ttl policies expire, 0 never expires")
+	asCSV := flags.Bool("csv", false, "print results as CSV")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	names := strings.Split(*policyList, ",")
+	for _, name := range names {
+		if _, ok := policies[name]; !ok {
+			return fmt.Errorf("unknown policy %q", name)
+		}
+	}
+
+	if *ttl < 0 {
+		return fmt.Errorf("invalid ttl %d", *ttl)
+	}
+
+	var sizes []int
+	for _, s := range strings.Split(*sizeList, ",") {
+		size, err := strconv.Atoi(s)
+		if err != nil || size < 1 {
+			return fmt.Errorf("invalid size %q", s)
+		}
+
+		sizes = append(sizes, size)
+	}
+
+	r := stdin
+	if flags.NArg() > 0 {
+		f, err := os.Open(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		r = f
+	}
+
+	// the trace is read once, every chunk of it is replayed against all the
+	// simulations
+	var sims []*simulation
+	for _, name := range names {
+		for _, size := range sizes {
+			sims = append(sims, newSimulation(name, size, *ttl))
+		}
+	}
+
+	err := readTrace(r, *format, func(keys []string) error {
+		for _, sim := range sims {
+			if err := sim.replay(keys); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	results := make([]*result, len(sims))
+	for i, sim := range sims {
+		results[i] = sim.res
+	}
+
+	if *asCSV {
+		return writeCSV(stdout, results)
+	}
+
+	return writeTable(stdout, results)
+}
+
+var header = []string{"policy", "size", "accesses", "hits", "hit_ratio", "evictions", "ops_per_sec"}
+
+func row(r *result) []string {
+	return []string{
+		r.policy,
+		strconv.Itoa(r.size),
+		strconv.Itoa(r.accesses),
+		strconv.Itoa(r.hits),
+		strconv.FormatFloat(r.hitRatio(), 'f', 4, 64),
+		strconv.Itoa(r.evictions),
+		strconv.FormatFloat(r.throughput(), 'f', 0, 64),
+	}
+}
+
+func writeCSV(w io.Writer, results []*result) error {
+	cw := csv.NewWriter(w)
+	cw.Write(header)
+	for _, r := range results {
+		cw.Write(row(r))
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeTable(w io.Writer, results []*result) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.ToUpper(strings.Join(header, "\t")))
+	for _, r := range results {
+		fmt.Fprintln(tw, strings.Join(row(r), "\t"))
+	}
+
+	return tw.Flush()
+}
+
+func policyNames() []string {
+	var names []string
+	for name := range policies {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
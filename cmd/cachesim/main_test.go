@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestReadTrace(t *testing.T) {
+	tests := []struct {
+		format string
+		trace  string
+		keys   []string
+	}{
+		{"key", "a\nb\n\na\n", []string{"a", "b", "a"}},
+		{"lirs", "1\n2\n1\n*\n", []string{"1", "2", "1"}},
+		{"arc", "10 3 0 1\n5 1 0 2\n", []string{"10", "11", "12", "5"}},
+		{"rec", "1 get ab miss 0\n2 set ab - 0\n3 get ab hit 0\n", []string{"ab", "ab"}},
+	}
+
+	for _, test := range tests {
+		var keys []string
+		err := readTrace(strings.NewReader(test.trace), test.format, func(chunk []string) error {
+			keys = append(keys, chunk...)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: should not give err: %q", test.format, err)
+		}
+
+		if strings.Join(keys, ",") != strings.Join(test.keys, ",") {
+			t.Fatalf("%s: keys should equal %v, got: %v", test.format, test.keys, keys)
+		}
+	}
+
+	if err := readTrace(strings.NewReader("x\n"), "lirs", discardKeys); err == nil {
+		t.Fatal("invalid block number should give error")
+	}
+
+	if err := readTrace(strings.NewReader("a\n"), "unknown", discardKeys); err == nil {
+		t.Fatal("unknown format should give error")
+	}
+}
+
+func TestReadTraceChunks(t *testing.T) {
+	// a single arc line expands into more than two chunks
+	var chunks, keys int
+	err := readTrace(strings.NewReader("0 10000 0 1\n"), "arc", func(chunk []string) error {
+		if len(chunk) > traceChunk {
+			t.Fatalf("chunk should have at most %d keys, got: %d", traceChunk, len(chunk))
+		}
+
+		chunks++
+		keys += len(chunk)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if chunks != 3 || keys != 10000 {
+		t.Fatalf("should replay 10000 keys in 3 chunks, got: %d keys in %d chunks", keys, chunks)
+	}
+}
+
+func discardKeys([]string) error { return nil }
+
+func TestSimulate(t *testing.T) {
+	keys := strings.Split("a b a c a d", " ")
+
+	sim := newSimulation("lru", 2, 0)
+	if err := sim.replay(keys); err != nil {
+		t.Fatal(err)
+	}
+
+	res := sim.res
+
+	// a hits twice, c evicts b and d evicts c
+	if res.hits != 2 {
+		t.Fatalf("hits should be 2, got: %d", res.hits)
+	}
+
+	if res.evictions != 2 {
+		t.Fatalf("evictions should be 2, got: %d", res.evictions)
+	}
+}
+
+func TestSimulateTTL(t *testing.T) {
+	keys := strings.Split("a b a c a d", " ")
+
+	// keys expire on the third access after they are set
+	sim := newSimulation("memory-ttl", 10, 3)
+	if err := sim.replay(keys); err != nil {
+		t.Fatal(err)
+	}
+
+	// second a hits, third a is expired
+	if sim.res.hits != 1 {
+		t.Fatalf("hits should be 1, got: %d", sim.res.hits)
+	}
+
+	// a expires before c is set and b before the third a
+	if sim.res.evictions != 2 {
+		t.Fatalf("evictions should be 2, got: %d", sim.res.evictions)
+	}
+}
+
+func TestRunAllPolicies(t *testing.T) {
+	var out bytes.Buffer
+	err := run([]string{"-csv", "-ttl", "2", "-sizes", "1"}, strings.NewReader("a\nb\na\n"), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != len(policies)+1 {
+		t.Fatalf("output should have a header and a row for every policy, got: %q", out.String())
+	}
+}
+
+func TestRunCSV(t *testing.T) {
+	var out bytes.Buffer
+	err := run([]string{"-csv", "-policies", "lru,lfu", "-sizes", "1,2"}, strings.NewReader("a\nb\na\n"), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("output should have a header and 4 rows, got: %q", out.String())
+	}
+
+	if !strings.HasPrefix(lines[1], "lru,1,3,0,") {
+		t.Fatalf("unexpected row: %q", lines[1])
+	}
+}
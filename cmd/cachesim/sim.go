@@ -0,0 +1,150 @@
+package main
+
+import (
+	"time"
+
+	"github.com/koding/cache"
+	"github.com/koding/cache/clock"
+	"github.com/koding/cache/clock/clocktest"
+)
+
+// accessTick is the time passing between two accesses on the clock of a
+// simulation, ttl of the expiring policies is given in accesses
+const accessTick = time.Second
+
+// policy creates a simulated cache of the given size whose keys expire after
+// ttl on the given clock, if the policy expires keys. It returns the item
+// count of the cache too, for counting the evictions
+type policy func(size int, ttl time.Duration, c clock.Clock) (cache.Cache, lener)
+
+// policies holds the simulated caches, non thread safe versions are used
+// where there is one since the simulation is single threaded. The memory
+// policies are unbounded, they give the hit ratio limit of a trace
+var policies = map[string]policy{
+	"memory":     bounded(func(int) cache.Cache { return cache.NewMemNoTSCache() }),
+	"lru":        bounded(cache.NewLRUNoTS),
+	"lfu":        bounded(cache.NewLFUNoTS),
+	"memory-ttl": expiring(func(int) cache.Cache { return cache.NewMemNoTSCache() }),
+	"lru-ttl":    expiring(cache.NewLRUNoTS),
+	"lfu-ttl":    expiring(cache.NewLFUNoTS),
+}
+
+// bounded returns a policy of the caches which never expire their keys
+func bounded(f func(size int) cache.Cache) policy {
+	return func(size int, _ time.Duration, _ clock.Clock) (cache.Cache, lener) {
+		c := f(size)
+		return c, c.(lener)
+	}
+}
+
+// expiring returns a policy of the caches wrapped by MemoryTTL
+func expiring(f func(size int) cache.Cache) policy {
+	return func(size int, ttl time.Duration, c clock.Clock) (cache.Cache, lener) {
+		var inner cache.Cache
+		ttlCache := cache.NewCacheWithTTL(ttl, func() cache.Cache {
+			inner = f(size)
+			return inner
+		}, cache.WithClock(c))
+
+		return ttlCache, inner.(lener)
+	}
+}
+
+// result holds the outcome of a single simulation
+type result struct {
+	policy   string
+	size     int
+	accesses int
+	hits     int
+
+	// evictions counts the keys removed for making room, the expired keys
+	// of the ttl policies included
+	evictions int
+	elapsed   time.Duration
+}
+
+// hitRatio returns the ratio of hits to all accesses
+func (r *result) hitRatio() float64 {
+	if r.accesses == 0 {
+		return 0
+	}
+
+	return float64(r.hits) / float64(r.accesses)
+}
+
+// throughput returns the accesses per second
+func (r *result) throughput() float64 {
+	if r.elapsed <= 0 {
+		return 0
+	}
+
+	return float64(r.accesses) / r.elapsed.Seconds()
+}
+
+// lener is implemented by the caches which can report their item count
+type lener interface {
+	Len() int
+}
+
+// simulation replays the accesses of a trace against a single cache
+type simulation struct {
+	res   *result
+	cache cache.Cache
+	items lener
+
+	// clock is advanced by accessTick with every access if ttl is set
+	clock *clocktest.Fake
+	ttl   int
+}
+
+// newSimulation creates a simulation of the policy with the given size, keys
+// of the expiring policies expire after ttl accesses, zero ttl never expires
+func newSimulation(name string, size, ttl int) *simulation {
+	clock := clocktest.NewFake(time.Unix(0, 0))
+	c, items := policies[name](size, time.Duration(ttl)*accessTick, clock)
+
+	return &simulation{
+		res: &result{
+			policy: name,
+			size:   size,
+		},
+		cache: c,
+		items: items,
+		clock: clock,
+		ttl:   ttl,
+	}
+}
+
+// replay replays the keys against the cache, every miss is followed by
+// setting the key, as a read-through cache does
+func (s *simulation) replay(keys []string) error {
+	start := time.Now()
+	defer func() { s.res.elapsed += time.Since(start) }()
+
+	for _, key := range keys {
+		if s.ttl > 0 {
+			s.clock.Advance(accessTick)
+		}
+
+		s.res.accesses++
+
+		_, err := s.cache.Get(key)
+		if err == nil {
+			s.res.hits++
+			continue
+		}
+
+		if err != cache.ErrNotFound {
+			return err
+		}
+
+		before := s.items.Len()
+		if err := s.cache.Set(key, struct{}{}); err != nil {
+			return err
+		}
+
+		s.res.evictions += before + 1 - s.items.Len()
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// traceChunk is the number of accesses passed to the replay function at once,
+// traces are streamed in chunks instead of being read into the memory
+const traceChunk = 4096
+
+// readTrace reads the keys of the accesses from the given trace and passes
+// them to replay in chunks of at most traceChunk keys, the chunk is reused
+// after replay returns. Format is one of the supported trace formats:
+//
+//	key  : one key per line
+//	lirs : one block number per line, as used by the LIRS traces
+//	arc  : "start count ignored requestID" per line, as used by the ARC
+//	       traces, every line accesses count blocks starting from start
+//	rec  : lines written by cache.Recorder, only get operations are
+//	       replayed since misses are followed by a set in the simulation
+func readTrace(r io.Reader, format string, replay func(keys []string) error) error {
+	parse, ok := parsers[format]
+	if !ok {
+		return fmt.Errorf("unknown trace format %q", format)
+	}
+
+	keys := make([]string, 0, traceChunk)
+	access := func(key string) error {
+		keys = append(keys, key)
+		if len(keys) < traceChunk {
+			return nil
+		}
+
+		err := replay(keys)
+		keys = keys[:0]
+		return err
+	}
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		if err := parse(text, access); err != nil {
+			return fmt.Errorf("line %d: %s", line, err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	return replay(keys)
+}
+
+// parsers holds the line parsers of the trace formats, every parser calls
+// access with the keys accessed in the line
+var parsers = map[string]func(line string, access func(key string) error) error{
+	"key":  parseKey,
+	"lirs": parseLIRS,
+	"arc":  parseARC,
+	"rec":  parseRecorder,
+}
+
+func parseKey(line string, access func(key string) error) error {
+	return access(line)
+}
+
+func parseLIRS(line string, access func(key string) error) error {
+	// LIRS traces may end with a "*" line
+	if line == "*" {
+		return nil
+	}
+
+	if _, err := strconv.ParseUint(line, 10, 64); err != nil {
+		return fmt.Errorf("invalid block number %q", line)
+	}
+
+	return access(line)
+}
+
+func parseARC(line string, access func(key string) error) error {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return fmt.Errorf("expected at least 2 fields, got %d", len(fields))
+	}
+
+	start, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid start block %q", fields[0])
+	}
+
+	count, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid block count %q", fields[1])
+	}
+
+	for i := uint64(0); i < count; i++ {
+		if err := access(strconv.FormatUint(start+i, 10)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func parseRecorder(line string, access func(key string) error) error {
+	fields := strings.Fields(line)
+	if len(fields) != 5 {
+		return fmt.Errorf("expected 5 fields, got %d", len(fields))
+	}
+
+	if fields[1] != "get" {
+		return nil
+	}
+
+	return access(fields[2])
+}
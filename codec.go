@@ -0,0 +1,209 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	msgpack "gopkg.in/vmihailenco/msgpack.v2"
+)
+
+// ErrTypedDestination is returned by the codecs which can not decode into an
+// empty interface, GetInto should be used with a typed destination instead
+var ErrTypedDestination = errors.New("codec requires a typed destination")
+
+// Codec is the contract for encoding the values stored in remote backends, so
+// a value set by one service can be read with its own type by another one
+type Codec interface {
+	// Name returns the name of the codec, it is stored along with the encoded
+	// values as their type tag
+	Name() string
+
+	// Marshal encodes the given value
+	Marshal(v interface{}) ([]byte, error)
+
+	// Unmarshal decodes data into the value pointed to by v
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	// JSONCodec encodes values with encoding/json
+	JSONCodec Codec = jsonCodec{}
+
+	// GobCodec encodes values with encoding/gob. Values of the basic types and
+	// of the types registered with gob.Register can be decoded into an empty
+	// interface, others can only be decoded into typed destinations
+	GobCodec Codec = gobCodec{}
+
+	// MsgpackCodec encodes values with MessagePack
+	MsgpackCodec Codec = msgpackCodec{}
+)
+
+// codecs holds the built-in codecs, indexed by their names
+var codecs = map[string]Codec{
+	JSONCodec.Name():    JSONCodec,
+	GobCodec.Name():     GobCodec,
+	MsgpackCodec.Name(): MsgpackCodec,
+}
+
+// codecByName returns the codec of the given type tag, preferred codec is
+// returned if its name matches, built-in ones are looked up otherwise
+func codecByName(name string, preferred Codec) (Codec, error) {
+	if preferred != nil && preferred.Name() == name {
+		return preferred, nil
+	}
+
+	c, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown codec %q", name)
+	}
+
+	return c, nil
+}
+
+// kinds of the values stored by the byte oriented backends, they tell how a
+// value is encoded
+const (
+	kindBytes uint8 = iota
+	kindString
+	kindEncoded
+)
+
+// encodeValue returns the bytes of the value with its kind, []byte and string
+// values are stored as they are, others are encoded with the codec
+func encodeValue(c Codec, value interface{}) ([]byte, uint8, error) {
+	switch v := value.(type) {
+	case []byte:
+		return v, kindBytes, nil
+	case string:
+		return []byte(v), kindString, nil
+	}
+
+	data, err := c.Marshal(value)
+	return data, kindEncoded, err
+}
+
+// decodeValue decodes the bytes of a value of the given kind
+func decodeValue(c Codec, data []byte, kind uint8) (interface{}, error) {
+	switch kind {
+	case kindBytes:
+		return data, nil
+	case kindString:
+		return string(data), nil
+	}
+
+	var value interface{}
+	if err := c.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+// decodeValueInto decodes the bytes of a value of the given kind into dst,
+// which must be a pointer
+func decodeValueInto(c Codec, data []byte, kind uint8, dst interface{}) error {
+	switch kind {
+	case kindBytes:
+		if b, ok := dst.(*[]byte); ok {
+			*b = data
+			return nil
+		}
+	case kindString:
+		if s, ok := dst.(*string); ok {
+			*s = string(data)
+			return nil
+		}
+	}
+
+	return c.Unmarshal(data, dst)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "json" }
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string { return "gob" }
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	// values are encoded as interfaces, so they are decoded without knowing
+	// their types, it fails for the types which are not registered and they
+	// are encoded with their concrete types instead
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&v); err == nil {
+		return buf.Bytes(), nil
+	}
+
+	buf.Reset()
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	if _, ok := v.(*interface{}); ok {
+		// values encoded with their concrete types can not be decoded into
+		// an interface
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(v); err != nil {
+			return ErrTypedDestination
+		}
+
+		return nil
+	}
+
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+	if err == nil {
+		return nil
+	}
+
+	// value may be encoded as an interface, it is decoded and assigned to
+	// the destination if their types match
+	var value interface{}
+	if gob.NewDecoder(bytes.NewReader(data)).Decode(&value) != nil || value == nil {
+		return err
+	}
+
+	dst := reflect.ValueOf(v)
+	if dst.Kind() != reflect.Ptr || dst.IsNil() {
+		return err
+	}
+
+	src := reflect.ValueOf(value)
+	if src.Kind() == reflect.Ptr && !src.Type().AssignableTo(dst.Elem().Type()) {
+		src = src.Elem()
+	}
+
+	if !src.Type().AssignableTo(dst.Elem().Type()) {
+		return err
+	}
+
+	dst.Elem().Set(src)
+	return nil
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return "msgpack" }
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"encoding/gob"
+	"reflect"
+	"testing"
+)
+
+type codecTestValue struct {
+	Name  string
+	Count int
+	Tags  []string
+}
+
+func TestCodecs(t *testing.T) {
+	value := codecTestValue{Name: "test_data", Count: 42, Tags: []string{"a", "b"}}
+
+	for _, codec := range []Codec{JSONCodec, GobCodec, MsgpackCodec} {
+		data, err := codec.Marshal(value)
+		if err != nil {
+			t.Fatalf("%s: should not give err while encoding: %q", codec.Name(), err)
+		}
+
+		var decoded codecTestValue
+		if err := codec.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("%s: should not give err while decoding: %q", codec.Name(), err)
+		}
+
+		if !reflect.DeepEqual(decoded, value) {
+			t.Fatalf("%s: decoded value should equal %v, got: %v", codec.Name(), value, decoded)
+		}
+
+		c, err := codecByName(codec.Name(), nil)
+		if err != nil || c != codec {
+			t.Fatalf("%s: codec should be found by its name", codec.Name())
+		}
+	}
+}
+
+type gobRegisteredValue struct {
+	Name string
+}
+
+func init() {
+	gob.Register(gobRegisteredValue{})
+}
+
+func TestGobCodecInterface(t *testing.T) {
+	for _, value := range []interface{}{"test_data", gobRegisteredValue{Name: "test_data"}} {
+		data, err := GobCodec.Marshal(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var v interface{}
+		if err := GobCodec.Unmarshal(data, &v); err != nil {
+			t.Fatalf("%T: should not give err while decoding: %q", value, err)
+		}
+
+		if !reflect.DeepEqual(v, value) {
+			t.Fatalf("decoded value should equal %#v, got: %#v", value, v)
+		}
+
+		// registered values can be decoded into their own types too
+		dst := reflect.New(reflect.TypeOf(value))
+		if err := GobCodec.Unmarshal(data, dst.Interface()); err != nil {
+			t.Fatalf("%T: should not give err while decoding: %q", value, err)
+		}
+
+		if !reflect.DeepEqual(dst.Elem().Interface(), value) {
+			t.Fatalf("decoded value should equal %#v, got: %#v", value, dst.Elem().Interface())
+		}
+	}
+
+	// types which are not registered require a typed destination
+	data, err := GobCodec.Marshal(codecTestValue{Name: "test_data"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v interface{}
+	if err := GobCodec.Unmarshal(data, &v); err != ErrTypedDestination {
+		t.Fatalf("error should equal to %q, got: %v", ErrTypedDestination, err)
+	}
+}
+
+func TestCodecByNameUnknown(t *testing.T) {
+	if _, err := codecByName("unknown", nil); err == nil {
+		t.Fatal("unknown codec should give error")
+	}
+}
@@ -0,0 +1,227 @@
+package cache
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// ErrNotBytes is returned by CompressedCache for the values which are not
+// []byte
+var ErrNotBytes = errors.New("value is not []byte")
+
+// Compression is the compression algorithm of a value, it is stored as the
+// first byte of the compressed values
+type Compression byte
+
+// Supported compression algorithms
+const (
+	// CompressionNone is used for the values which are smaller than the
+	// threshold
+	CompressionNone Compression = iota
+	CompressionGzip
+	CompressionFlate
+	CompressionSnappy
+	CompressionZstd
+)
+
+// String returns the name of the algorithm
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionGzip:
+		return "gzip"
+	case CompressionFlate:
+		return "flate"
+	case CompressionSnappy:
+		return "snappy"
+	case CompressionZstd:
+		return "zstd"
+	default:
+		return fmt.Sprintf("Compression(%d)", byte(c))
+	}
+}
+
+// CompressedCache compresses the []byte values which are larger than its
+// threshold before storing them in the underlying cache. Algorithm of a value
+// is recorded in its first byte, so values compressed with different
+// algorithms can be read by any CompressedCache
+type CompressedCache struct {
+	// cache holds the underlying cache
+	cache Cache
+
+	// algorithm is used for compressing the values
+	algorithm Compression
+
+	// threshold is the minimum size of the values to be compressed
+	threshold int
+}
+
+// NewCompressedCache creates a CompressedCache which compresses the values of
+// at least threshold bytes with the given algorithm
+func NewCompressedCache(c Cache, algorithm Compression, threshold int) *CompressedCache {
+	if algorithm > CompressionZstd {
+		panic("invalid compression algorithm")
+	}
+
+	return &CompressedCache{
+		cache:     c,
+		algorithm: algorithm,
+		threshold: threshold,
+	}
+}
+
+// Get returns the decompressed value of a given key
+func (c *CompressedCache) Get(key string) (interface{}, error) {
+	value, err := c.cache.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, ok := value.([]byte)
+	if !ok || len(data) == 0 {
+		return nil, fmt.Errorf("value of %q is not compressed", key)
+	}
+
+	return decompress(Compression(data[0]), data[1:])
+}
+
+// Set compresses the value if it is larger than the threshold and sets it to
+// the underlying cache, value must be []byte
+func (c *CompressedCache) Set(key string, value interface{}) error {
+	data, ok := value.([]byte)
+	if !ok {
+		return ErrNotBytes
+	}
+
+	algorithm := c.algorithm
+	if len(data) < c.threshold {
+		algorithm = CompressionNone
+	}
+
+	compressed, err := compress(algorithm, data)
+	if err != nil {
+		return err
+	}
+
+	return c.cache.Set(key, compressed)
+}
+
+// Delete deletes the given key from the underlying cache
+func (c *CompressedCache) Delete(key string) error {
+	return c.cache.Delete(key)
+}
+
+var (
+	// zstd encoder and decoder are safe for concurrent use with EncodeAll
+	// and DecodeAll, so they are shared
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+
+	// zstdErr holds the error of creating the encoder or the decoder, it is
+	// returned by every zstd compression
+	zstdErr error
+)
+
+// initZstd creates the shared zstd encoder and decoder once
+func initZstd() error {
+	zstdOnce.Do(func() {
+		if zstdEncoder, zstdErr = zstd.NewWriter(nil); zstdErr != nil {
+			return
+		}
+
+		zstdDecoder, zstdErr = zstd.NewReader(nil)
+	})
+
+	return zstdErr
+}
+
+// compress returns the data compressed with the algorithm, prefixed with the
+// algorithm's header byte
+func compress(algorithm Compression, data []byte) ([]byte, error) {
+	dst := []byte{byte(algorithm)}
+
+	switch algorithm {
+	case CompressionNone:
+		return append(dst, data...), nil
+	case CompressionSnappy:
+		return append(dst, snappy.Encode(nil, data)...), nil
+	case CompressionZstd:
+		if err := initZstd(); err != nil {
+			return nil, err
+		}
+
+		return zstdEncoder.EncodeAll(data, dst), nil
+	}
+
+	buf := bytes.NewBuffer(dst)
+
+	var w interface {
+		Write([]byte) (int, error)
+		Close() error
+	}
+
+	switch algorithm {
+	case CompressionGzip:
+		w = gzip.NewWriter(buf)
+	case CompressionFlate:
+		fw, err := flate.NewWriter(buf, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+
+		w = fw
+	default:
+		return nil, fmt.Errorf("unknown compression %s", algorithm)
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// decompress returns the data decompressed with the given algorithm
+func decompress(algorithm Compression, data []byte) ([]byte, error) {
+	switch algorithm {
+	case CompressionNone:
+		return data, nil
+	case CompressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+
+		return ioutil.ReadAll(r)
+	case CompressionFlate:
+		r := flate.NewReader(bytes.NewReader(data))
+		defer r.Close()
+
+		return ioutil.ReadAll(r)
+	case CompressionSnappy:
+		return snappy.Decode(nil, data)
+	case CompressionZstd:
+		if err := initZstd(); err != nil {
+			return nil, err
+		}
+
+		return zstdDecoder.DecodeAll(data, nil)
+	default:
+		return nil, fmt.Errorf("unknown compression %s", algorithm)
+	}
+}
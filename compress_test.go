@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCompressedCache(t *testing.T) {
+	large := bytes.Repeat([]byte("test_data"), 100)
+	small := []byte("test_data")
+
+	for _, algorithm := range []Compression{CompressionNone, CompressionGzip, CompressionFlate, CompressionSnappy, CompressionZstd} {
+		memory := NewMemory()
+		cache := NewCompressedCache(memory, algorithm, 64)
+
+		for _, value := range [][]byte{small, large} {
+			if err := cache.Set("test_key", value); err != nil {
+				t.Fatalf("%s: should not give err while setting item: %q", algorithm, err)
+			}
+
+			data, err := cache.Get("test_key")
+			if err != nil {
+				t.Fatalf("%s: should not give err while getting item: %q", algorithm, err)
+			}
+
+			if !bytes.Equal(data.([]byte), value) {
+				t.Fatalf("%s: data should equal to the set value", algorithm)
+			}
+		}
+
+		raw, _ := memory.Get("test_key")
+		if Compression(raw.([]byte)[0]) != algorithm {
+			t.Fatalf("%s: header should be the algorithm, got: %d", algorithm, raw.([]byte)[0])
+		}
+
+		if algorithm != CompressionNone && len(raw.([]byte)) >= len(large) {
+			t.Fatalf("%s: value should be compressed", algorithm)
+		}
+
+		cache.Set("test_key", small)
+		raw, _ = memory.Get("test_key")
+		if Compression(raw.([]byte)[0]) != CompressionNone {
+			t.Fatalf("%s: small value should not be compressed", algorithm)
+		}
+	}
+}
+
+func TestCompressedCacheMixed(t *testing.T) {
+	memory := NewMemory()
+	value := bytes.Repeat([]byte("test_data"), 100)
+
+	NewCompressedCache(memory, CompressionGzip, 0).Set("test_key", value)
+
+	data, err := NewCompressedCache(memory, CompressionZstd, 0).Get("test_key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data.([]byte), value) {
+		t.Fatal("value compressed with gzip should be read by any algorithm")
+	}
+}
+
+func TestCompressedCacheNotBytes(t *testing.T) {
+	memory := NewMemory()
+	cache := NewCompressedCache(memory, CompressionGzip, 0)
+
+	if err := cache.Set("test_key", "test_data"); err != ErrNotBytes {
+		t.Fatalf("error should equal to %q, got: %v", ErrNotBytes, err)
+	}
+
+	memory.Set("test_key", "test_data")
+	if _, err := cache.Get("test_key"); err == nil {
+		t.Fatal("uncompressed value should give error")
+	}
+}
@@ -0,0 +1,95 @@
+package cache_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/koding/cache"
+	"github.com/koding/cache/cachetest"
+	"github.com/koding/cache/clock"
+)
+
+func TestConformanceMemoryNoTS(t *testing.T) {
+	cachetest.TestCache(t, cache.NewMemNoTSCache)
+}
+
+func TestConformanceMemory(t *testing.T) {
+	cachetest.TestCache(t, cache.NewMemory)
+	cachetest.TestCacheConcurrency(t, cache.NewMemory)
+}
+
+func TestConformanceLRUNoTS(t *testing.T) {
+	cachetest.TestCache(t, func() cache.Cache { return cache.NewLRUNoTS(4) })
+	cachetest.TestCacheEviction(t, cache.NewLRUNoTS)
+}
+
+func TestConformanceLRU(t *testing.T) {
+	cachetest.TestCache(t, func() cache.Cache { return cache.NewLRU(4) })
+	cachetest.TestCacheConcurrency(t, func() cache.Cache { return cache.NewLRU(4) })
+	cachetest.TestCacheEviction(t, cache.NewLRU)
+}
+
+func TestConformanceLFUNoTS(t *testing.T) {
+	cachetest.TestCache(t, func() cache.Cache { return cache.NewLFUNoTS(4) })
+	cachetest.TestCacheEviction(t, cache.NewLFUNoTS)
+}
+
+func TestConformanceLFU(t *testing.T) {
+	cachetest.TestCache(t, func() cache.Cache { return cache.NewLFU(4) })
+	cachetest.TestCacheConcurrency(t, func() cache.Cache { return cache.NewLFU(4) })
+	cachetest.TestCacheEviction(t, cache.NewLFU)
+}
+
+func TestConformanceMemoryTTL(t *testing.T) {
+	newCache := func() cache.Cache { return cache.NewMemoryWithTTL(time.Minute) }
+	cachetest.TestCache(t, newCache)
+	cachetest.TestCacheConcurrency(t, newCache)
+	cachetest.TestCacheTTL(t, func(ttl time.Duration, c clock.Clock) cache.Cache {
+		return cache.NewMemoryWithTTL(ttl, cache.WithClock(c))
+	})
+}
+
+func TestConformanceLRUWithTTL(t *testing.T) {
+	newCache := func() cache.Cache { return cache.NewLRUWithTTL(4, time.Minute) }
+	cachetest.TestCache(t, newCache)
+	cachetest.TestCacheConcurrency(t, newCache)
+	cachetest.TestCacheTTL(t, func(ttl time.Duration, c clock.Clock) cache.Cache {
+		return cache.NewLRUWithTTL(4, ttl, cache.WithClock(c))
+	})
+	cachetest.TestCacheEviction(t, func(size int) cache.Cache {
+		return cache.NewLRUWithTTL(size, time.Minute)
+	})
+}
+
+func TestConformanceLFUWithTTL(t *testing.T) {
+	newCache := func() cache.Cache { return cache.NewLFUWithTTL(4, time.Minute) }
+	cachetest.TestCache(t, newCache)
+	cachetest.TestCacheConcurrency(t, newCache)
+	cachetest.TestCacheTTL(t, func(ttl time.Duration, c clock.Clock) cache.Cache {
+		return cache.NewLFUWithTTL(4, ttl, cache.WithClock(c))
+	})
+	cachetest.TestCacheEviction(t, func(size int) cache.Cache {
+		return cache.NewLFUWithTTL(size, time.Minute)
+	})
+}
+
+func TestConformanceShardedNoTS(t *testing.T) {
+	cachetest.TestShardedCache(t, func() cache.ShardedCache {
+		return cache.NewShardedNoTS(cache.NewMemNoTSCache)
+	})
+	cachetest.TestShardedCacheEviction(t, func(size int) cache.ShardedCache {
+		return cache.NewShardedNoTS(func() cache.Cache { return cache.NewLRUNoTS(size) })
+	})
+}
+
+func TestConformanceShardedTTL(t *testing.T) {
+	newCache := func() cache.ShardedCache { return cache.NewShardedWithTTL(time.Minute) }
+	cachetest.TestShardedCache(t, newCache)
+	cachetest.TestShardedCacheConcurrency(t, newCache)
+	cachetest.TestShardedCacheTTL(t, func(ttl time.Duration, c clock.Clock) cache.ShardedCache {
+		return cache.NewShardedWithTTL(ttl, cache.WithClock(c))
+	})
+	cachetest.TestShardedCacheEviction(t, func(size int) cache.ShardedCache {
+		return cache.NewShardedCacheWithTTL(time.Minute, func() cache.Cache { return cache.NewLFUNoTS(size) })
+	})
+}
@@ -0,0 +1,44 @@
+package cache
+
+import (
+	"errors"
+	"math"
+	"strconv"
+)
+
+// ErrNotInteger is returned by Counter when the value of a key is not an
+// integer or the result overflows int64
+var ErrNotInteger = errors.New("value is not an integer or out of range")
+
+// incrValue adds delta to the integer value, integers stored as strings or
+// []byte are accepted as well, as they are written by the text protocols
+func incrValue(value interface{}, delta int64) (int64, error) {
+	var n int64
+
+	switch v := value.(type) {
+	case int64:
+		n = v
+	case int:
+		n = int64(v)
+	case string:
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, ErrNotInteger
+		}
+		n = i
+	case []byte:
+		i, err := strconv.ParseInt(string(v), 10, 64)
+		if err != nil {
+			return 0, ErrNotInteger
+		}
+		n = i
+	default:
+		return 0, ErrNotInteger
+	}
+
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return 0, ErrNotInteger
+	}
+
+	return n + delta, nil
+}
@@ -0,0 +1,361 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/koding/cache/clock"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// valuesBucket holds the values, indexed by key
+	valuesBucket = []byte("values")
+
+	// expiryBucket holds the keys ordered by their expiration times, its
+	// keys are the 8 byte expiration time followed by the key
+	expiryBucket = []byte("expiry")
+
+	// errMalformedRecord is returned for the records which are not written
+	// by DiskCache
+	errMalformedRecord = errors.New("disk: malformed record")
+
+	// ErrEmptyKey is returned by DiskCache for setting the empty key, bbolt
+	// can not store it
+	ErrEmptyKey = errors.New("disk: empty key")
+)
+
+// diskHeaderSize is the size of the record header; a byte for the value kind
+// and 8 bytes for the expiration time
+const diskHeaderSize = 9
+
+// DiskCache is a persistent cache stored in a single bbolt file, so it
+// survives restarts. []byte and string values are stored as they are, other
+// values are encoded with the codec. Expired keys are never returned, they are
+// deleted by the garbage collector
+type DiskCache struct {
+	// db holds the bbolt database
+	db *bolt.DB
+
+	// ttl is the duration for a key set with Set to expire, keys do not
+	// expire if it is 0
+	ttl time.Duration
+
+	// codec encodes the values which are not []byte or string
+	codec Codec
+
+	// clock is used for expiration of keys and gc intervals
+	clock clock.Clock
+
+	// Mutex guards gcTicker and done
+	sync.Mutex
+
+	// gcTicker controls gc intervals
+	gcTicker clock.Ticker
+
+	// done controls sweeping goroutine lifetime
+	done chan struct{}
+}
+
+// DiskOption sets the options specified for DiskCache.
+type DiskOption func(*DiskCache)
+
+// WithDiskTTL sets the duration for the keys set with Set to expire, keys do
+// not expire by default
+func WithDiskTTL(ttl time.Duration) DiskOption {
+	return func(d *DiskCache) {
+		d.ttl = ttl
+	}
+}
+
+// WithDiskCodec sets the codec for encoding the values which are not []byte
+// or string, default is JSONCodec
+func WithDiskCodec(c Codec) DiskOption {
+	return func(d *DiskCache) {
+		d.codec = c
+	}
+}
+
+// WithDiskClock sets the clock which is used for expiring keys and ticking
+// the garbage collector
+func WithDiskClock(c clock.Clock) DiskOption {
+	return func(d *DiskCache) {
+		d.clock = c
+	}
+}
+
+// NewDiskCache opens the cache stored in the given file, the file is created
+// if it does not exist. The file is locked while it is open, so it can not be
+// shared by multiple processes
+// usage:
+// NewDiskCache("/var/cache/agent.db", WithDiskTTL(time.Hour))
+func NewDiskCache(path string, opts ...DiskOption) (*DiskCache, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(valuesBucket); err != nil {
+			return err
+		}
+
+		_, err := tx.CreateBucketIfNotExists(expiryBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	d := &DiskCache{
+		db:    db,
+		codec: JSONCodec,
+		clock: clock.New(),
+	}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	return d, nil
+}
+
+// Get returns a value of a given key if it exists and is not expired
+func (d *DiskCache) Get(key string) (interface{}, error) {
+	data, kind, err := d.get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeValue(d.codec, data, kind)
+}
+
+// GetInto decodes the value of a given key into dst, which must be a pointer
+func (d *DiskCache) GetInto(key string, dst interface{}) error {
+	data, kind, err := d.get(key)
+	if err != nil {
+		return err
+	}
+
+	return decodeValueInto(d.codec, data, kind, dst)
+}
+
+// Set will persist a value to the cache or override existing one with the new
+// one, it expires with the ttl of the cache
+func (d *DiskCache) Set(key string, value interface{}) error {
+	return d.SetEx(key, d.ttl, value)
+}
+
+// SetEx will persist a value to the cache or override existing one with the
+// new one with ttl duration, zero duration never expires
+func (d *DiskCache) SetEx(key string, duration time.Duration, value interface{}) error {
+	if key == "" {
+		return ErrEmptyKey
+	}
+
+	data, kind, err := encodeValue(d.codec, value)
+	if err != nil {
+		return err
+	}
+
+	var expireAt int64
+	if duration > 0 {
+		expireAt = d.clock.Now().Add(duration).UnixNano()
+	}
+
+	record := make([]byte, diskHeaderSize+len(data))
+	record[0] = kind
+	binary.BigEndian.PutUint64(record[1:diskHeaderSize], uint64(expireAt))
+	copy(record[diskHeaderSize:], data)
+
+	return d.db.Update(func(tx *bolt.Tx) error {
+		if err := d.delete(tx, key); err != nil {
+			return err
+		}
+
+		if err := tx.Bucket(valuesBucket).Put([]byte(key), record); err != nil {
+			return err
+		}
+
+		if expireAt == 0 {
+			return nil
+		}
+
+		return tx.Bucket(expiryBucket).Put(expiryKey(expireAt, key), nil)
+	})
+}
+
+// Delete deletes a given key if exists
+func (d *DiskCache) Delete(key string) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		return d.delete(tx, key)
+	})
+}
+
+// DeletePrefix deletes all the keys starting with the given prefix, it
+// implements PrefixDeleter
+func (d *DiskCache) DeletePrefix(prefix string) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		var keys [][]byte
+
+		c := tx.Bucket(valuesBucket).Cursor()
+		for k, _ := c.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, _ = c.Next() {
+			keys = append(keys, append([]byte(nil), k...))
+		}
+
+		for _, key := range keys {
+			if err := d.delete(tx, string(key)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// StartGC starts the garbage collection process in a go routine, calling
+// StartGC again restarts the process with the new interval
+func (d *DiskCache) StartGC(gcInterval time.Duration) {
+	if gcInterval <= 0 {
+		return
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	d.stopGC()
+
+	ticker := d.clock.NewTicker(gcInterval)
+	done := make(chan struct{})
+
+	d.gcTicker = ticker
+	d.done = done
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C():
+				d.deleteExpiredKeys()
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// StopGC stops sweeping goroutine, it is safe to call StopGC multiple times
+func (d *DiskCache) StopGC() {
+	d.Lock()
+	defer d.Unlock()
+
+	d.stopGC()
+}
+
+// Close stops sweeping goroutine and closes the file, it implements io.Closer
+func (d *DiskCache) Close() error {
+	d.StopGC()
+	return d.db.Close()
+}
+
+func (d *DiskCache) stopGC() {
+	if d.gcTicker == nil {
+		return
+	}
+
+	d.gcTicker.Stop()
+	d.gcTicker = nil
+	close(d.done)
+	d.done = nil
+}
+
+// get returns the value bytes of the key with its kind
+func (d *DiskCache) get(key string) ([]byte, uint8, error) {
+	var (
+		data []byte
+		kind uint8
+	)
+
+	err := d.db.View(func(tx *bolt.Tx) error {
+		record := tx.Bucket(valuesBucket).Get([]byte(key))
+		if record == nil {
+			return ErrNotFound
+		}
+
+		if len(record) < diskHeaderSize {
+			return errMalformedRecord
+		}
+
+		expireAt := int64(binary.BigEndian.Uint64(record[1:diskHeaderSize]))
+		if expireAt != 0 && expireAt <= d.clock.Now().UnixNano() {
+			return ErrNotFound
+		}
+
+		// record is only valid during the transaction
+		kind = record[0]
+		data = append([]byte(nil), record[diskHeaderSize:]...)
+		return nil
+	})
+
+	return data, kind, err
+}
+
+// delete deletes the key with its expiry entry
+func (d *DiskCache) delete(tx *bolt.Tx, key string) error {
+	values := tx.Bucket(valuesBucket)
+
+	record := values.Get([]byte(key))
+	if record == nil {
+		return nil
+	}
+
+	if len(record) >= diskHeaderSize {
+		expireAt := int64(binary.BigEndian.Uint64(record[1:diskHeaderSize]))
+		if expireAt != 0 {
+			if err := tx.Bucket(expiryBucket).Delete(expiryKey(expireAt, key)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return values.Delete([]byte(key))
+}
+
+// deleteExpiredKeys deletes the expired keys, expiry entries are ordered by
+// their expiration times so only the expired ones are visited
+func (d *DiskCache) deleteExpiredKeys() error {
+	now := d.clock.Now().UnixNano()
+
+	return d.db.Update(func(tx *bolt.Tx) error {
+		var keys []string
+
+		c := tx.Bucket(expiryBucket).Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			if int64(binary.BigEndian.Uint64(k[:8])) > now {
+				break
+			}
+
+			keys = append(keys, string(k[8:]))
+		}
+
+		for _, key := range keys {
+			if err := d.delete(tx, key); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// expiryKey returns the key of the expiry entry, big endian time keeps the
+// entries ordered by their expiration times
+func expiryKey(expireAt int64, key string) []byte {
+	k := make([]byte, 8+len(key))
+	binary.BigEndian.PutUint64(k, uint64(expireAt))
+	copy(k[8:], key)
+	return k
+}
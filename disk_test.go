@@ -0,0 +1,189 @@
+package cache
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/koding/cache/clock/clocktest"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestDiskCacheGetSet(t *testing.T) {
+	cache, err := NewDiskCache(filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	testCacheGetSet(t, cache)
+}
+
+func TestDiskCacheNilValue(t *testing.T) {
+	cache, err := NewDiskCache(filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	testCacheNilValue(t, cache)
+}
+
+func TestDiskCacheDelete(t *testing.T) {
+	cache, err := NewDiskCache(filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	testCacheDelete(t, cache)
+}
+
+func TestDiskCacheEmptyKey(t *testing.T) {
+	cache, err := NewDiskCache(filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	if err := cache.Set("", "test_data"); err != ErrEmptyKey {
+		t.Fatalf("error should equal to %q, got: %v", ErrEmptyKey, err)
+	}
+
+	if _, err := cache.Get(""); err != ErrNotFound {
+		t.Fatalf("error should equal to %q, got: %v", ErrNotFound, err)
+	}
+}
+
+func TestDiskCacheReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+
+	cache, err := NewDiskCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("test_key", "test_data")
+	cache.Set("test_key2", []byte("test_data2"))
+	cache.Close()
+
+	cache, err = NewDiskCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	if data, err := cache.Get("test_key"); err != nil || data != "test_data" {
+		t.Fatalf("data should equal to test_data, got: %v, %v", data, err)
+	}
+
+	var data []byte
+	if err := cache.GetInto("test_key2", &data); err != nil || string(data) != "test_data2" {
+		t.Fatalf("data should equal to test_data2, got: %s, %v", data, err)
+	}
+}
+
+func TestDiskCacheTTL(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	cache, err := NewDiskCache(filepath.Join(t.TempDir(), "cache.db"), WithDiskTTL(time.Minute), WithDiskClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	cache.Set("test_key", "test_data")
+	cache.SetEx("test_key2", time.Hour, "test_data2")
+
+	clock.Advance(time.Minute)
+
+	if _, err := cache.Get("test_key"); err != ErrNotFound {
+		t.Fatalf("error should equal to %q, got: %v", ErrNotFound, err)
+	}
+
+	if _, err := cache.Get("test_key2"); err != nil {
+		t.Fatalf("test_key2 should be in the cache, got: %v", err)
+	}
+}
+
+func TestDiskCacheGC(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	cache, err := NewDiskCache(filepath.Join(t.TempDir(), "cache.db"), WithDiskTTL(time.Minute), WithDiskClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	cache.StartGC(time.Minute)
+
+	cache.Set("test_key", "test_data")
+	cache.Set("test_key", "test_data")
+	cache.SetEx("test_key2", time.Hour, "test_data2")
+	cache.SetEx("test_key3", 0, "test_data3")
+
+	// second tick is received after the first sweep is done
+	clock.Advance(time.Minute)
+	clock.Advance(time.Minute)
+	cache.StopGC()
+
+	err = cache.db.View(func(tx *bolt.Tx) error {
+		if n := tx.Bucket(valuesBucket).Stats().KeyN; n != 2 {
+			t.Errorf("value count should be 2, got: %d", n)
+		}
+
+		if n := tx.Bucket(expiryBucket).Stats().KeyN; n != 1 {
+			t.Errorf("expiry entry count should be 1, got: %d", n)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiskCacheDeletePrefix(t *testing.T) {
+	cache, err := NewDiskCache(filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	cache.Set("user:1", "test_data")
+	cache.SetEx("user:2", time.Hour, "test_data")
+	cache.Set("users", "test_data")
+
+	if err := cache.DeletePrefix("user:"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"user:1", "user:2"} {
+		if _, err := cache.Get(key); err != ErrNotFound {
+			t.Fatalf("%s should be deleted, got: %v", key, err)
+		}
+	}
+
+	if _, err := cache.Get("users"); err != nil {
+		t.Fatalf("users should be in the cache, got: %v", err)
+	}
+}
+
+func TestDiskCacheCodec(t *testing.T) {
+	type user struct {
+		Name string
+	}
+
+	cache, err := NewDiskCache(filepath.Join(t.TempDir(), "cache.db"), WithDiskCodec(GobCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	if err := cache.Set("test_key", user{Name: "koding"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var u user
+	if err := cache.GetInto("test_key", &u); err != nil || u.Name != "koding" {
+		t.Fatalf("user should be decoded, got: %+v, %v", u, err)
+	}
+}
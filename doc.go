@@ -6,9 +6,14 @@
 //     LRUNoTS     : provides a non-thread safe, fixed size in-memory caching system, built on top of MemoryNoTS cache
 //     LRU         : provides a thread safe, fixed size in-memory caching system, built on top of LRUNoTS cache
 //     MemoryTTL   : provides a thread safe, expiring in-memory caching system,  built on top of MemoryNoTS cache
+//     LRUWithTTL  : provides a thread safe, fixed size, expiring in-memory caching system, built on top of MemoryTTL over LRUNoTS
+//     LFUWithTTL  : provides a thread safe, fixed size, expiring in-memory caching system, built on top of MemoryTTL over LFUNoTS
 //     ShardedNoTS : provides a non-thread safe sharded cache system, built on top of a cache interface
 //     ShardedTTL  : provides a thread safe, expiring in-memory sharded cache system, built on top of ShardedNoTS over MemoryNoTS
 //     LFUNoTS     : provides a non-thread safe, fixed size in-memory caching system, built on top of MemoryNoTS cache
 //     LFU         : provides a thread safe, fixed size in-memory caching system, built on top of LFUNoTS cache
 //
+// Backends which run a garbage collector in the background (MemoryTTL,
+// ShardedTTL and MongoCache) implement io.Closer, Close stops the garbage
+// collector and is safe to call multiple times.
 package cache
@@ -0,0 +1,221 @@
+package cache
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ErrKeyNotFound is returned by the keyrings when the requested key does not
+// exist
+var ErrKeyNotFound = errors.New("encryption key not found")
+
+// Keyring is the contract for the encryption key providers, like a secret
+// store. Keys are identified by their IDs, which are stored along with the
+// encrypted values, so the values encrypted with old keys can still be
+// decrypted after the current key is rotated
+type Keyring interface {
+	// Current returns the ID and the key for encrypting new values
+	Current() (id string, key []byte, err error)
+
+	// Key returns the key with the given ID for decrypting values, it
+	// returns ErrKeyNotFound if there is no such key
+	Key(id string) ([]byte, error)
+}
+
+// StaticKeyring is an in-memory Keyring
+type StaticKeyring struct {
+	// Mutex is used for handling the concurrent
+	// read/write requests for keys
+	sync.RWMutex
+
+	// current holds the ID of the current key
+	current string
+
+	// keys holds the keys, indexed by their IDs
+	keys map[string][]byte
+}
+
+// NewStaticKeyring creates a keyring with the given key as its current key
+func NewStaticKeyring(id string, key []byte) *StaticKeyring {
+	return &StaticKeyring{
+		current: id,
+		keys:    map[string][]byte{id: key},
+	}
+}
+
+// Rotate adds the given key and makes it the current key, old keys are kept
+// for decrypting the existing values
+func (k *StaticKeyring) Rotate(id string, key []byte) {
+	k.Lock()
+	defer k.Unlock()
+
+	k.current = id
+	k.keys[id] = key
+}
+
+// Current returns the ID and the key for encrypting new values
+func (k *StaticKeyring) Current() (string, []byte, error) {
+	k.RLock()
+	defer k.RUnlock()
+
+	return k.current, k.keys[k.current], nil
+}
+
+// Key returns the key with the given ID
+func (k *StaticKeyring) Key(id string) ([]byte, error) {
+	k.RLock()
+	defer k.RUnlock()
+
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+
+	return key, nil
+}
+
+// EncryptedCache encrypts the []byte values with AES-GCM before storing them
+// in the underlying cache. Encrypted values are in the form of;
+//
+//	<key ID length><key ID><nonce><ciphertext>
+//
+// The cache key is authenticated along with the value, so an encrypted value
+// can not be moved to another key
+type EncryptedCache struct {
+	// cache holds the underlying cache
+	cache Cache
+
+	// keyring provides the encryption keys
+	keyring Keyring
+
+	// hashSecret is used for hashing the keys with HMAC, keys are stored as
+	// they are if it is nil
+	hashSecret []byte
+}
+
+// EncryptionOption sets the options specified for EncryptedCache.
+type EncryptionOption func(*EncryptedCache)
+
+// WithKeyHashing stores the keys as hex encoded HMAC-SHA256 hashes with the
+// given secret, so the raw keys are not stored either
+func WithKeyHashing(secret []byte) EncryptionOption {
+	return func(e *EncryptedCache) {
+		e.hashSecret = secret
+	}
+}
+
+// NewEncryptedCache creates an EncryptedCache over the given cache with the
+// keys of the keyring, keys must be 16, 24 or 32 bytes long
+func NewEncryptedCache(c Cache, keyring Keyring, opts ...EncryptionOption) *EncryptedCache {
+	e := &EncryptedCache{
+		cache:   c,
+		keyring: keyring,
+	}
+
+	for _, opt := range opts {
+		opt(e)
+	}
+
+	return e
+}
+
+// Get returns the decrypted value of a given key
+func (e *EncryptedCache) Get(key string) (interface{}, error) {
+	key = e.hashKey(key)
+
+	value, err := e.cache.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, ok := value.([]byte)
+	if !ok || len(data) == 0 || len(data) < 1+int(data[0]) {
+		return nil, fmt.Errorf("value of %q is not encrypted", key)
+	}
+
+	id, data := string(data[1:1+data[0]]), data[1+data[0]:]
+
+	k, err := e.keyring.Key(id)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newGCM(k)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("value of %q is not encrypted", key)
+	}
+
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(key))
+}
+
+// Set encrypts the value with the current key of the keyring and sets it to
+// the underlying cache, value must be []byte
+func (e *EncryptedCache) Set(key string, value interface{}) error {
+	data, ok := value.([]byte)
+	if !ok {
+		return ErrNotBytes
+	}
+
+	key = e.hashKey(key)
+
+	id, k, err := e.keyring.Current()
+	if err != nil {
+		return err
+	}
+
+	if len(id) > 255 {
+		return fmt.Errorf("key ID %q is too long", id)
+	}
+
+	aead, err := newGCM(k)
+	if err != nil {
+		return err
+	}
+
+	dst := make([]byte, 1+len(id)+aead.NonceSize(), 1+len(id)+aead.NonceSize()+len(data)+aead.Overhead())
+	dst[0] = byte(len(id))
+	copy(dst[1:], id)
+
+	nonce := dst[1+len(id):]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+
+	return e.cache.Set(key, aead.Seal(dst, nonce, data, []byte(key)))
+}
+
+// Delete deletes the given key from the underlying cache
+func (e *EncryptedCache) Delete(key string) error {
+	return e.cache.Delete(e.hashKey(key))
+}
+
+func (e *EncryptedCache) hashKey(key string) string {
+	if e.hashSecret == nil {
+		return key
+	}
+
+	mac := hmac.New(sha256.New, e.hashSecret)
+	mac.Write([]byte(key))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"bytes"
+	"testing"
+)
+
+var (
+	testEncryptionKey  = bytes.Repeat([]byte("k"), 32)
+	testEncryptionKey2 = bytes.Repeat([]byte("r"), 16)
+)
+
+func TestEncryptedCache(t *testing.T) {
+	memory := NewMemory()
+	cache := NewEncryptedCache(memory, NewStaticKeyring("k1", testEncryptionKey))
+	value := []byte("test_data")
+
+	if err := cache.Set("test_key", value); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := cache.Get("test_key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data.([]byte), value) {
+		t.Fatalf("data should equal %q, got: %q", value, data)
+	}
+
+	raw, _ := memory.Get("test_key")
+	if bytes.Contains(raw.([]byte), value) {
+		t.Fatal("value should not be stored in plaintext")
+	}
+
+	if err := cache.Delete("test_key"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cache.Get("test_key"); err != ErrNotFound {
+		t.Fatal("test_key should not be in the cache")
+	}
+
+	if err := cache.Set("test_key", "test_data"); err != ErrNotBytes {
+		t.Fatalf("error should equal to %q, got: %v", ErrNotBytes, err)
+	}
+}
+
+func TestEncryptedCacheRotation(t *testing.T) {
+	keyring := NewStaticKeyring("k1", testEncryptionKey)
+	cache := NewEncryptedCache(NewMemory(), keyring)
+
+	cache.Set("test_key", []byte("test_data"))
+	keyring.Rotate("k2", testEncryptionKey2)
+	cache.Set("test_key2", []byte("test_data2"))
+
+	for key, value := range map[string]string{"test_key": "test_data", "test_key2": "test_data2"} {
+		data, err := cache.Get(key)
+		if err != nil {
+			t.Fatalf("%s should be decrypted: %q", key, err)
+		}
+
+		if string(data.([]byte)) != value {
+			t.Fatalf("data should equal %q, got: %q", value, data)
+		}
+	}
+
+	// values encrypted with an unknown key can not be read
+	other := NewEncryptedCache(cache.cache, NewStaticKeyring("k3", testEncryptionKey))
+	if _, err := other.Get("test_key"); err != ErrKeyNotFound {
+		t.Fatalf("error should equal to %q, got: %v", ErrKeyNotFound, err)
+	}
+}
+
+func TestEncryptedCacheKeyHashing(t *testing.T) {
+	memory := NewMemoryNoTS()
+	cache := NewEncryptedCache(memory, NewStaticKeyring("k1", testEncryptionKey), WithKeyHashing([]byte("secret")))
+
+	cache.Set("test_key", []byte("test_data"))
+
+	if _, err := memory.Get("test_key"); err != ErrNotFound {
+		t.Fatal("raw key should not be stored")
+	}
+
+	if len(memory.items) != 1 {
+		t.Fatalf("hashed key should be stored, got: %v", memory.items)
+	}
+
+	data, err := cache.Get("test_key")
+	if err != nil || string(data.([]byte)) != "test_data" {
+		t.Fatalf("data should equal test_data, got: %q, %v", data, err)
+	}
+}
+
+func TestEncryptedCacheMovedValue(t *testing.T) {
+	memory := NewMemory()
+	cache := NewEncryptedCache(memory, NewStaticKeyring("k1", testEncryptionKey))
+
+	cache.Set("test_key", []byte("test_data"))
+	raw, _ := memory.Get("test_key")
+	memory.Set("test_key2", raw)
+
+	if _, err := cache.Get("test_key2"); err == nil {
+		t.Fatal("value moved to another key should not be decrypted")
+	}
+}
@@ -5,4 +5,20 @@ import "errors"
 var (
 	// ErrNotFound holds exported `not found error` for not found items
 	ErrNotFound = errors.New("not found")
+
+	// ErrCachedNotFound is returned for the keys which are cached as not
+	// found with SetNotFound
+	ErrCachedNotFound = errors.New("cached not found")
+
+	// ErrClosed is returned for the writes to a closed WriteBehindCache
+	ErrClosed = errors.New("cache: closed")
+
+	// errTTLNotSupported is returned when a ttl is requested from a cache
+	// which can not set one
+	errTTLNotSupported = errors.New("cache does not support ttl")
 )
+
+// IsNotFound reports whether the error is ErrNotFound or ErrCachedNotFound
+func IsNotFound(err error) bool {
+	return err == ErrNotFound || err == ErrCachedNotFound
+}
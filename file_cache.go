@@ -0,0 +1,367 @@
+package cache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fileTempPrefix is the name prefix of the files which are being written
+const fileTempPrefix = ".tmp-"
+
+// ErrTooLarge is returned when a value is larger than the byte budget of the
+// FileCache
+var ErrTooLarge = errors.New("value is larger than the cache budget")
+
+// FileCache stores every value as a file under a root directory, so it is
+// suitable for the values which are too large for memory, like build
+// artifacts or thumbnails. Files are named with the SHA-256 of their keys and
+// fanned out into two levels of subdirectories. Values are written to
+// temporary files and renamed, so a value is either completely written or not
+// visible at all.
+//
+// Total size of the values is kept under the byte budget by evicting the
+// least recently accessed ones. Access times are kept as the modification
+// times of the files, so the order survives restarts. Only []byte values are
+// accepted, GetReader and SetWriter can be used for streaming the large ones
+type FileCache struct {
+	// Mutex guards the index
+	sync.Mutex
+
+	// root is the directory of the files
+	root string
+
+	// maxBytes is the byte budget, 0 means unlimited
+	maxBytes int64
+
+	// totalBytes is the total size of the files
+	totalBytes int64
+
+	// files holds the list elements of the files, indexed by file name
+	files map[string]*list.Element
+
+	// lru holds the files ordered by their access times, most recently
+	// accessed one is at the front of the list
+	lru *list.List
+}
+
+// fileEntry is the value of the lru list elements
+type fileEntry struct {
+	name string
+	size int64
+}
+
+// NewFileCache creates a cache under the given root directory, the directory
+// is created if it does not exist. Existing files are loaded, so the values
+// survive restarts. maxBytes is the byte budget, 0 means unlimited
+// usage:
+// NewFileCache("/var/cache/thumbnails", 10<<30)
+func NewFileCache(root string, maxBytes int64) (*FileCache, error) {
+	if maxBytes < 0 {
+		panic("invalid byte budget")
+	}
+
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+
+	f := &FileCache{
+		root:     root,
+		maxBytes: maxBytes,
+		files:    make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	if err := f.evict(""); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Get returns the value of a given key as []byte if it exists
+func (f *FileCache) Get(key string) (interface{}, error) {
+	r, err := f.GetReader(key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+// GetReader returns a reader of the value of a given key if it exists, it
+// must be closed after reading. The value can be read completely even if it
+// is replaced or evicted meanwhile
+func (f *FileCache) GetReader(key string) (io.ReadCloser, error) {
+	name := fileName(key)
+
+	file, err := os.Open(f.path(name))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	f.touch(name)
+	return file, nil
+}
+
+// Set will persist a value to the cache or override existing one with the new
+// one, value must be []byte
+func (f *FileCache) Set(key string, value interface{}) error {
+	b, ok := value.([]byte)
+	if !ok {
+		return ErrNotBytes
+	}
+
+	w, err := f.writer(key)
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(b); err != nil {
+		w.abort()
+		return err
+	}
+
+	return w.Close()
+}
+
+// SetWriter returns a writer for the value of a given key, the value is
+// persisted when the writer is closed. Existing value of the key is served
+// until then. ErrTooLarge is returned by Close if the value is larger than the
+// byte budget
+func (f *FileCache) SetWriter(key string) (io.WriteCloser, error) {
+	return f.writer(key)
+}
+
+// writer creates the temporary file for the value of the key
+func (f *FileCache) writer(key string) (*fileWriter, error) {
+	name := fileName(key)
+	dir := filepath.Dir(f.path(name))
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp(dir, fileTempPrefix+"*")
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileWriter{cache: f, name: name, tmp: tmp}, nil
+}
+
+// Delete deletes a given key if exists
+func (f *FileCache) Delete(key string) error {
+	f.Lock()
+	defer f.Unlock()
+
+	return f.remove(fileName(key))
+}
+
+// Size returns the total size of the values in bytes
+func (f *FileCache) Size() int64 {
+	f.Lock()
+	defer f.Unlock()
+
+	return f.totalBytes
+}
+
+// fileWriter writes a value to a temporary file, which is renamed to the file
+// of the key on Close
+type fileWriter struct {
+	cache *FileCache
+	name  string
+	tmp   *os.File
+	size  int64
+}
+
+// Write writes to the temporary file
+func (w *fileWriter) Write(p []byte) (int, error) {
+	n, err := w.tmp.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Close persists the written value
+func (w *fileWriter) Close() error {
+	if err := w.tmp.Close(); err != nil {
+		os.Remove(w.tmp.Name())
+		return err
+	}
+
+	return w.cache.commit(w.name, w.tmp.Name(), w.size)
+}
+
+// abort drops the written value
+func (w *fileWriter) abort() {
+	w.tmp.Close()
+	os.Remove(w.tmp.Name())
+}
+
+// commit renames the temporary file to the file of the key and evicts the
+// least recently accessed files if the budget is exceeded
+func (f *FileCache) commit(name, tmp string, size int64) error {
+	if f.maxBytes > 0 && size > f.maxBytes {
+		os.Remove(tmp)
+		return ErrTooLarge
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	if err := os.Rename(tmp, f.path(name)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if elem, ok := f.files[name]; ok {
+		f.totalBytes -= elem.Value.(*fileEntry).size
+		f.lru.Remove(elem)
+	}
+
+	f.files[name] = f.lru.PushFront(&fileEntry{name: name, size: size})
+	f.totalBytes += size
+
+	return f.evict(name)
+}
+
+// touch marks the file as the most recently accessed one
+func (f *FileCache) touch(name string) {
+	f.Lock()
+	defer f.Unlock()
+
+	elem, ok := f.files[name]
+	if !ok {
+		return
+	}
+
+	f.lru.MoveToFront(elem)
+
+	// access times are not reliable, e.g. noatime mounts, so the access is
+	// recorded as the modification time
+	now := time.Now()
+	os.Chtimes(f.path(name), now, now)
+}
+
+// evict removes the least recently accessed files until the total size is
+// under the budget, the given file is never evicted. It must be called with
+// the lock held
+func (f *FileCache) evict(keep string) error {
+	if f.maxBytes == 0 {
+		return nil
+	}
+
+	for elem := f.lru.Back(); elem != nil && f.totalBytes > f.maxBytes; {
+		name := elem.Value.(*fileEntry).name
+		elem = elem.Prev()
+
+		if name == keep {
+			continue
+		}
+
+		if err := f.remove(name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// remove deletes the file and its index entry. It must be called with the
+// lock held
+func (f *FileCache) remove(name string) error {
+	if err := os.Remove(f.path(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if elem, ok := f.files[name]; ok {
+		f.totalBytes -= elem.Value.(*fileEntry).size
+		f.lru.Remove(elem)
+		delete(f.files, name)
+	}
+
+	return nil
+}
+
+// load indexes the existing files in the order of their modification times,
+// temporary files left by a crash are removed
+func (f *FileCache) load() error {
+	type file struct {
+		name    string
+		size    int64
+		modTime time.Time
+	}
+
+	var files []file
+
+	err := filepath.WalkDir(f.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		if strings.HasPrefix(d.Name(), fileTempPrefix) {
+			return os.Remove(path)
+		}
+
+		if path != f.path(d.Name()) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		files = append(files, file{name: d.Name(), size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.After(files[j].modTime) })
+
+	for _, file := range files {
+		f.files[file.name] = f.lru.PushBack(&fileEntry{name: file.name, size: file.size})
+		f.totalBytes += file.size
+	}
+
+	return nil
+}
+
+// path returns the path of the file, files are fanned out into two levels of
+// subdirectories by the first bytes of their names
+func (f *FileCache) path(name string) string {
+	if len(name) < 4 {
+		return filepath.Join(f.root, name)
+	}
+
+	return filepath.Join(f.root, name[:2], name[2:4], name)
+}
+
+// fileName returns the file name of the key
+func fileName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
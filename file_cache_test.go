@@ -0,0 +1,174 @@
+package cache
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileCacheGetSet(t *testing.T) {
+	cache, err := NewFileCache(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.Set("test_key", []byte("test_data")); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := cache.Get("test_key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data.([]byte)) != "test_data" {
+		t.Fatalf("data should equal to test_data, got: %s", data)
+	}
+
+	if err := cache.Set("test_key", "test_data"); err != ErrNotBytes {
+		t.Fatalf("error should equal to %q, got: %v", ErrNotBytes, err)
+	}
+
+	if _, err := cache.Get("test_key2"); err != ErrNotFound {
+		t.Fatalf("error should equal to %q, got: %v", ErrNotFound, err)
+	}
+}
+
+func TestFileCacheDelete(t *testing.T) {
+	cache, err := NewFileCache(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("test_key", []byte("test_data"))
+
+	if err := cache.Delete("test_key"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.Delete("test_key2"); err != nil {
+		t.Fatal("non-existing item should not give error")
+	}
+
+	if _, err := cache.Get("test_key"); err != ErrNotFound {
+		t.Fatalf("error should equal to %q, got: %v", ErrNotFound, err)
+	}
+
+	if size := cache.Size(); size != 0 {
+		t.Fatalf("size should be 0, got: %d", size)
+	}
+}
+
+func TestFileCacheStream(t *testing.T) {
+	cache, err := NewFileCache(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := strings.Repeat("test_data", 1<<16)
+
+	w, err := cache.SetWriter("test_key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := io.Copy(w, strings.NewReader(value)); err != nil {
+		t.Fatal(err)
+	}
+
+	// value is not visible until the writer is closed
+	if _, err := cache.Get("test_key"); err != ErrNotFound {
+		t.Fatalf("error should equal to %q, got: %v", ErrNotFound, err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := cache.GetReader("test_key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if buf.String() != value {
+		t.Fatal("streamed value should equal to the written one")
+	}
+}
+
+func TestFileCacheEviction(t *testing.T) {
+	cache, err := NewFileCache(t.TempDir(), 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("test_key1", []byte("0123456789"))
+	cache.Set("test_key2", []byte("0123456789"))
+
+	// test_key1 becomes the most recently accessed one
+	cache.Get("test_key1")
+	cache.Set("test_key3", []byte("0123456789"))
+
+	if _, err := cache.Get("test_key2"); err != ErrNotFound {
+		t.Fatalf("least recently accessed key should be evicted, got: %v", err)
+	}
+
+	for _, key := range []string{"test_key1", "test_key3"} {
+		if _, err := cache.Get(key); err != nil {
+			t.Fatalf("%s should be in the cache, got: %v", key, err)
+		}
+	}
+
+	if size := cache.Size(); size != 20 {
+		t.Fatalf("size should be 20, got: %d", size)
+	}
+
+	if err := cache.Set("test_key4", make([]byte, 21)); err != ErrTooLarge {
+		t.Fatalf("error should equal to %q, got: %v", ErrTooLarge, err)
+	}
+}
+
+func TestFileCacheReopen(t *testing.T) {
+	root := t.TempDir()
+	cache, err := NewFileCache(root, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("test_key1", []byte("0123456789"))
+	cache.Set("test_key2", []byte("0123456789"))
+
+	// access order is kept as modification times
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(cache.path(fileName("test_key2")), old, old)
+
+	// files left by an interrupted write are removed
+	tmp := filepath.Join(filepath.Dir(cache.path(fileName("test_key1"))), fileTempPrefix+"1")
+	if err := os.WriteFile(tmp, []byte("test_data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err = NewFileCache(root, 15)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Fatal("temporary file should be removed")
+	}
+
+	if _, err := cache.Get("test_key2"); err != ErrNotFound {
+		t.Fatalf("least recently accessed key should be evicted, got: %v", err)
+	}
+
+	if data, err := cache.Get("test_key1"); err != nil || string(data.([]byte)) != "0123456789" {
+		t.Fatalf("data should equal to 0123456789, got: %v, %v", data, err)
+	}
+}
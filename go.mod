@@ -0,0 +1,14 @@
+module github.com/koding/cache
+
+go 1.22
+
+require (
+	github.com/golang/snappy v0.0.4
+	github.com/klauspost/compress v1.18.0
+	github.com/mattn/go-sqlite3 v1.14.22
+	go.etcd.io/bbolt v1.3.11
+	gopkg.in/mgo.v2 v2.0.0-20190816093944-a6b53ec6cb22
+	gopkg.in/vmihailenco/msgpack.v2 v2.9.1
+)
+
+require golang.org/x/sys v0.9.0 // indirect
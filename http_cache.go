@@ -0,0 +1,274 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultHTTPCacheIdleConns = 16
+
+// HTTPCache is a Cache served by an HTTPHandler of another process. Values are
+// sent as JSON, so they are returned as the types encoding/json decodes into
+// interface{}; GetInto can be used for decoding them into their own types.
+// Connections to the server are kept alive and reused
+type HTTPCache struct {
+	// base is the URL the handler is served at
+	base string
+
+	// client sends the requests to the server
+	client *http.Client
+
+	// maxIdleConns is the number of idle connections kept for reuse
+	maxIdleConns int
+}
+
+// HTTPCacheOption sets the options specified for HTTPCache.
+type HTTPCacheOption func(*HTTPCache)
+
+// WithHTTPCacheClient sets the client for the requests to the server, the
+// idle connection count is not applied to it
+func WithHTTPCacheClient(c *http.Client) HTTPCacheOption {
+	return func(h *HTTPCache) {
+		h.client = c
+	}
+}
+
+// WithMaxIdleConns sets the number of idle connections kept alive for reuse,
+// default is 16
+func WithMaxIdleConns(n int) HTTPCacheOption {
+	if n <= 0 {
+		panic("invalid idle connection count")
+	}
+
+	return func(h *HTTPCache) {
+		h.maxIdleConns = n
+	}
+}
+
+// NewHTTPCache creates a client of the HTTPHandler served at the given base URL
+// usage:
+// NewHTTPCache("http://cache.internal:8080/cache", WithMaxIdleConns(64))
+func NewHTTPCache(baseURL string, opts ...HTTPCacheOption) *HTTPCache {
+	h := &HTTPCache{
+		base:         strings.TrimSuffix(baseURL, "/"),
+		maxIdleConns: defaultHTTPCacheIdleConns,
+	}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	if h.client == nil {
+		h.client = newHTTPClient(h.maxIdleConns)
+	}
+
+	return h
+}
+
+// Get returns the value of a given key if it exists
+func (h *HTTPCache) Get(key string) (interface{}, error) {
+	var value interface{}
+	if err := h.get(h.keyURL(key), &value); err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+// GetInto decodes the value of a given key into dst, which must be a pointer
+func (h *HTTPCache) GetInto(key string, dst interface{}) error {
+	return h.get(h.keyURL(key), dst)
+}
+
+// Set will persist a value to the cache or override existing one with the new
+// one, it expires with the ttl of the server cache
+func (h *HTTPCache) Set(key string, value interface{}) error {
+	return h.put(h.keyURL(key), 0, value)
+}
+
+// SetEx will persist a value to the cache or override existing one with the
+// new one with ttl duration, server cache must support ttl
+func (h *HTTPCache) SetEx(key string, duration time.Duration, value interface{}) error {
+	if duration <= 0 {
+		return fmt.Errorf("invalid ttl %s", duration)
+	}
+
+	return h.put(h.keyURL(key), duration, value)
+}
+
+// Delete deletes a given key if exists
+func (h *HTTPCache) Delete(key string) error {
+	return h.delete(h.keyURL(key))
+}
+
+// Sharded returns a ShardedCache served by the same handler
+func (h *HTTPCache) Sharded() *ShardedHTTPCache {
+	return &ShardedHTTPCache{cache: h}
+}
+
+// Close closes the idle connections, it implements io.Closer
+func (h *HTTPCache) Close() error {
+	h.client.CloseIdleConnections()
+	return nil
+}
+
+func (h *HTTPCache) keyURL(key string) string {
+	return h.base + "/keys/" + url.PathEscape(key)
+}
+
+func (h *HTTPCache) shardURL(shardID string) string {
+	return h.base + "/shards/" + url.PathEscape(shardID)
+}
+
+func (h *HTTPCache) get(u string, dst interface{}) error {
+	resp, err := h.client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer closeHTTPBody(resp.Body)
+
+	if err := checkHTTPResponse(resp); err != nil {
+		return err
+	}
+
+	return json.NewDecoder(resp.Body).Decode(dst)
+}
+
+func (h *HTTPCache) put(u string, duration time.Duration, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, u, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if duration > 0 {
+		req.Header.Set(ttlHeader, strconv.FormatFloat(duration.Seconds(), 'f', -1, 64))
+	}
+
+	return h.do(req)
+}
+
+func (h *HTTPCache) delete(u string) error {
+	req, err := http.NewRequest(http.MethodDelete, u, nil)
+	if err != nil {
+		return err
+	}
+
+	return h.do(req)
+}
+
+func (h *HTTPCache) do(req *http.Request) error {
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer closeHTTPBody(resp.Body)
+
+	return checkHTTPResponse(resp)
+}
+
+// ShardedHTTPCache is a ShardedCache served by an HTTPHandler, it is created
+// with HTTPCache.Sharded
+type ShardedHTTPCache struct {
+	cache *HTTPCache
+}
+
+// Get returns the value of a given key in the shard if it exists
+func (s *ShardedHTTPCache) Get(shardID, key string) (interface{}, error) {
+	var value interface{}
+	if err := s.cache.get(s.keyURL(shardID, key), &value); err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+// GetInto decodes the value of a given key in the shard into dst, which must
+// be a pointer
+func (s *ShardedHTTPCache) GetInto(shardID, key string, dst interface{}) error {
+	return s.cache.get(s.keyURL(shardID, key), dst)
+}
+
+// Set will persist a value to the shard or override existing one with the new
+// one, it expires with the ttl of the server cache
+func (s *ShardedHTTPCache) Set(shardID, key string, value interface{}) error {
+	return s.cache.put(s.keyURL(shardID, key), 0, value)
+}
+
+// SetEx will persist a value to the shard or override existing one with the
+// new one with ttl duration, server cache must support ttl
+func (s *ShardedHTTPCache) SetEx(shardID, key string, duration time.Duration, value interface{}) error {
+	if duration <= 0 {
+		return fmt.Errorf("invalid ttl %s", duration)
+	}
+
+	return s.cache.put(s.keyURL(shardID, key), duration, value)
+}
+
+// Delete deletes a given key in the shard if exists
+func (s *ShardedHTTPCache) Delete(shardID, key string) error {
+	return s.cache.delete(s.keyURL(shardID, key))
+}
+
+// DeleteShard deletes all the keys of the shard
+func (s *ShardedHTTPCache) DeleteShard(shardID string) error {
+	return s.cache.delete(s.cache.shardURL(shardID))
+}
+
+func (s *ShardedHTTPCache) keyURL(shardID, key string) string {
+	return s.cache.shardURL(shardID) + "/keys/" + url.PathEscape(key)
+}
+
+// HTTPError is returned by HTTPCache and PeerCache when the server responds
+// with an error
+type HTTPError struct {
+	// URL is the URL of the request
+	URL string
+
+	// StatusCode is the status code of the response
+	StatusCode int
+
+	// Message is the body of the response
+	Message string
+}
+
+// Error implements the error interface
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("cache server %s: %d %s", e.URL, e.StatusCode, e.Message)
+}
+
+// checkHTTPResponse returns the error of the response, 404 of a missing key is
+// ErrNotFound, 404 of an unknown route is an *HTTPError
+func checkHTTPResponse(resp *http.Response) error {
+	switch {
+	case resp.StatusCode == http.StatusOK, resp.StatusCode == http.StatusNoContent:
+		return nil
+	case resp.StatusCode == http.StatusNotFound && resp.Header.Get(missHeader) != "":
+		return ErrNotFound
+	}
+
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return &HTTPError{
+		URL:        resp.Request.URL.String(),
+		StatusCode: resp.StatusCode,
+		Message:    strings.TrimSpace(string(msg)),
+	}
+}
+
+// closeHTTPBody drains and closes the body, so its connection can be reused
+func closeHTTPBody(body io.ReadCloser) {
+	io.Copy(io.Discard, io.LimitReader(body, 64<<10))
+	body.Close()
+}
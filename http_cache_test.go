@@ -0,0 +1,166 @@
+package cache
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHTTPCacheGetSet(t *testing.T) {
+	srv := httptest.NewServer(NewHTTPHandler(NewMemory(), nil))
+	defer srv.Close()
+
+	c := NewHTTPCache(srv.URL)
+	defer c.Close()
+
+	testCacheGetSet(t, c)
+}
+
+func TestHTTPCacheDelete(t *testing.T) {
+	srv := httptest.NewServer(NewHTTPHandler(NewMemory(), nil))
+	defer srv.Close()
+
+	c := NewHTTPCache(srv.URL)
+	defer c.Close()
+
+	testCacheDelete(t, c)
+}
+
+func TestHTTPCacheNilValue(t *testing.T) {
+	srv := httptest.NewServer(NewHTTPHandler(NewMemory(), nil))
+	defer srv.Close()
+
+	c := NewHTTPCache(srv.URL)
+	defer c.Close()
+
+	testCacheNilValue(t, c)
+}
+
+func TestHTTPCacheGetInto(t *testing.T) {
+	type user struct {
+		Name string
+		Age  int
+	}
+
+	srv := httptest.NewServer(NewHTTPHandler(NewMemory(), nil))
+	defer srv.Close()
+
+	c := NewHTTPCache(srv.URL)
+	defer c.Close()
+
+	if err := c.Set("user/1", user{Name: "koding", Age: 7}); err != nil {
+		t.Fatalf("should not give err while setting item: %s", err)
+	}
+
+	var u user
+	if err := c.GetInto("user/1", &u); err != nil {
+		t.Fatalf("user/1 should be in the cache: %s", err)
+	}
+
+	if u.Name != "koding" || u.Age != 7 {
+		t.Fatalf("value should be decoded into the struct, got %+v", u)
+	}
+
+	if err := c.GetInto("user/2", &u); err != ErrNotFound {
+		t.Fatalf("missing key should give ErrNotFound, got %v", err)
+	}
+}
+
+func TestHTTPCacheSetEx(t *testing.T) {
+	backend := &ttlCache{Cache: NewMemory(), ttls: make(map[string]time.Duration)}
+	srv := httptest.NewServer(NewHTTPHandler(backend, nil))
+	defer srv.Close()
+
+	c := NewHTTPCache(srv.URL)
+	defer c.Close()
+
+	if err := c.SetEx("test_key", 1500*time.Millisecond, "test_data"); err != nil {
+		t.Fatalf("should not give err while setting item: %s", err)
+	}
+
+	if backend.ttls["test_key"] != 1500*time.Millisecond {
+		t.Fatalf("ttl should be 1.5s, got %s", backend.ttls["test_key"])
+	}
+
+	srv2 := httptest.NewServer(NewHTTPHandler(NewMemory(), nil))
+	defer srv2.Close()
+
+	c2 := NewHTTPCache(srv2.URL)
+	defer c2.Close()
+
+	err := c2.SetEx("test_key", time.Second, "test_data")
+	if e, ok := err.(*HTTPError); !ok || e.StatusCode != 501 {
+		t.Fatalf("ttl without SetEx should give HTTPError 501, got %v", err)
+	}
+}
+
+func TestShardedHTTPCacheGetSet(t *testing.T) {
+	srv := httptest.NewServer(NewHTTPHandler(nil, NewShardedNoTS(NewMemNoTSCache)))
+	defer srv.Close()
+
+	c := NewHTTPCache(srv.URL)
+	defer c.Close()
+
+	testShardedCacheGetSet(t, c.Sharded())
+}
+
+func TestShardedHTTPCacheDelete(t *testing.T) {
+	srv := httptest.NewServer(NewHTTPHandler(nil, NewShardedNoTS(NewMemNoTSCache)))
+	defer srv.Close()
+
+	c := NewHTTPCache(srv.URL)
+	defer c.Close()
+
+	testShardedCacheDelete(t, c.Sharded())
+}
+
+func TestShardedHTTPCacheNilValue(t *testing.T) {
+	srv := httptest.NewServer(NewHTTPHandler(nil, NewShardedNoTS(NewMemNoTSCache)))
+	defer srv.Close()
+
+	c := NewHTTPCache(srv.URL)
+	defer c.Close()
+
+	testShardedCacheNilValue(t, c.Sharded())
+}
+
+func TestShardedHTTPCacheDeleteShard(t *testing.T) {
+	srv := httptest.NewServer(NewHTTPHandler(nil, NewShardedNoTS(NewMemNoTSCache)))
+	defer srv.Close()
+
+	c := NewHTTPCache(srv.URL)
+	defer c.Close()
+
+	testDeleteShard(t, c.Sharded())
+}
+
+func TestHTTPCacheUnknownRoute(t *testing.T) {
+	// the handler has no cache for the /keys routes
+	srv := httptest.NewServer(NewHTTPHandler(nil, NewShardedNoTS(NewMemNoTSCache)))
+	defer srv.Close()
+
+	c := NewHTTPCache(srv.URL)
+	defer c.Close()
+
+	_, err := c.Get("test_key")
+	if e, ok := err.(*HTTPError); !ok || e.StatusCode != 404 {
+		t.Fatalf("unknown route should give HTTPError 404, got %v", err)
+	}
+}
+
+func TestHTTPCacheMaxValueSize(t *testing.T) {
+	srv := httptest.NewServer(NewHTTPHandler(NewMemory(), nil, WithHTTPMaxValueSize(8)))
+	defer srv.Close()
+
+	c := NewHTTPCache(srv.URL)
+	defer c.Close()
+
+	if err := c.Set("small", "value"); err != nil {
+		t.Fatalf("should not give err while setting small item: %s", err)
+	}
+
+	err := c.Set("large", "a value larger than the limit")
+	if e, ok := err.(*HTTPError); !ok || e.StatusCode != 413 {
+		t.Fatalf("large value should give HTTPError 413, got %v", err)
+	}
+}
@@ -0,0 +1,283 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// ttlHeader carries the ttl of the value of a PUT request, in seconds or
+	// as a Go duration like "1m30s"
+	ttlHeader = "X-Cache-TTL"
+
+	// missHeader marks the 404 responses of the missing keys, so they are
+	// told apart from the unknown routes
+	missHeader = "X-Cache-Miss"
+
+	defaultHTTPMaxValueSize = 32 << 20
+)
+
+// HTTPHandler exposes a Cache and a ShardedCache over HTTP with JSON values,
+// so they can be used by the services which are not written in Go:
+//
+//	GET    /keys/{key}                  returns the value as JSON
+//	PUT    /keys/{key}                  sets the value to the JSON body
+//	DELETE /keys/{key}                  deletes the key
+//	GET    /shards/{id}/keys/{key}      returns the value in the shard
+//	PUT    /shards/{id}/keys/{key}      sets the value in the shard
+//	DELETE /shards/{id}/keys/{key}      deletes the key from the shard
+//	DELETE /shards/{id}                 deletes all the keys of the shard
+//
+// Keys and shard ids are path escaped. The ttl of a PUT is given with the
+// X-Cache-TTL header, in seconds or as a Go duration, the cache must implement
+// ExpiringCache or ExpiringShardedCache for it. Missing keys are responded with
+// 404 Not Found and the X-Cache-Miss header, which is not set for the unknown
+// routes. Values larger than the maximum value size are responded with 413
+// Request Entity Too Large.
+//
+// []byte values are returned as base64 strings, as encoding/json does
+type HTTPHandler struct {
+	cache   Cache
+	sharded ShardedCache
+
+	// maxValueSize is the maximum size of the request bodies
+	maxValueSize int64
+}
+
+// HTTPHandlerOption sets the options specified for HTTPHandler.
+type HTTPHandlerOption func(*HTTPHandler)
+
+// WithHTTPMaxValueSize sets the maximum size of the JSON values of the PUT
+// requests, default is 32MB
+func WithHTTPMaxValueSize(n int64) HTTPHandlerOption {
+	if n <= 0 {
+		panic("invalid max value size")
+	}
+
+	return func(h *HTTPHandler) {
+		h.maxValueSize = n
+	}
+}
+
+// NewHTTPHandler creates a handler for the given caches, either of them can be
+// nil, then its requests are responded with 404 Not Found
+// usage:
+// http.Handle("/cache/", http.StripPrefix("/cache", NewHTTPHandler(c, nil)))
+func NewHTTPHandler(c Cache, sc ShardedCache, opts ...HTTPHandlerOption) *HTTPHandler {
+	h := &HTTPHandler{
+		cache:        c,
+		sharded:      sc,
+		maxValueSize: defaultHTTPMaxValueSize,
+	}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	return h
+}
+
+// ServeHTTP serves the cache requests, it implements http.Handler
+func (h *HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/")
+	for i, part := range parts {
+		p, err := url.PathUnescape(part)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		parts[i] = p
+	}
+
+	switch {
+	case len(parts) == 2 && parts[0] == "keys" && h.cache != nil:
+		h.serveKey(w, r, parts[1])
+	case len(parts) == 2 && parts[0] == "shards" && h.sharded != nil:
+		if r.Method != http.MethodDelete {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err := h.sharded.DeleteShard(parts[1]); err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 4 && parts[0] == "shards" && parts[2] == "keys" && h.sharded != nil:
+		h.serveShardKey(w, r, parts[1], parts[3])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveKey serves the requests of a key of the cache
+func (h *HTTPHandler) serveKey(w http.ResponseWriter, r *http.Request, key string) {
+	switch r.Method {
+	case http.MethodGet:
+		value, err := h.cache.Get(key)
+		writeHTTPValue(w, value, err)
+	case http.MethodPut:
+		value, ttl, err := readHTTPValue(w, r, h.maxValueSize)
+		if err != nil {
+			writeHTTPRequestError(w, err)
+			return
+		}
+
+		if ttl == 0 {
+			err = h.cache.Set(key, value)
+		} else if c, ok := h.cache.(ExpiringCache); ok {
+			err = c.SetEx(key, ttl, value)
+		} else {
+			err = errTTLNotSupported
+		}
+
+		writeHTTPResult(w, err)
+	case http.MethodDelete:
+		writeHTTPResult(w, h.cache.Delete(key))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// serveShardKey serves the requests of a key of the sharded cache
+func (h *HTTPHandler) serveShardKey(w http.ResponseWriter, r *http.Request, shardID, key string) {
+	switch r.Method {
+	case http.MethodGet:
+		value, err := h.sharded.Get(shardID, key)
+		writeHTTPValue(w, value, err)
+	case http.MethodPut:
+		value, ttl, err := readHTTPValue(w, r, h.maxValueSize)
+		if err != nil {
+			writeHTTPRequestError(w, err)
+			return
+		}
+
+		if ttl == 0 {
+			err = h.sharded.Set(shardID, key, value)
+		} else if c, ok := h.sharded.(ExpiringShardedCache); ok {
+			err = c.SetEx(shardID, key, ttl, value)
+		} else {
+			err = errTTLNotSupported
+		}
+
+		writeHTTPResult(w, err)
+	case http.MethodDelete:
+		writeHTTPResult(w, h.sharded.Delete(shardID, key))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// readHTTPValue decodes the JSON value and the ttl of a PUT request, bodies
+// larger than maxSize bytes give *http.MaxBytesError
+func readHTTPValue(w http.ResponseWriter, r *http.Request, maxSize int64) (interface{}, time.Duration, error) {
+	ttl, err := parseTTL(r.Header.Get(ttlHeader))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var value interface{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSize)).Decode(&value); err != nil {
+		if _, ok := err.(*http.MaxBytesError); ok {
+			return nil, 0, err
+		}
+
+		return nil, 0, fmt.Errorf("invalid value: %v", err)
+	}
+
+	return value, ttl, nil
+}
+
+// writeHTTPRequestError responds with the status of an invalid request
+func writeHTTPRequestError(w http.ResponseWriter, err error) {
+	if _, ok := err.(*http.MaxBytesError); ok {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+// parseTTL parses the ttl header, it is either seconds or a Go duration. Zero
+// is no ttl, so the positive ttls shorter than a nanosecond are rejected
+// instead of being truncated to it
+func parseTTL(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	invalid := fmt.Errorf("invalid %s header: %q", ttlHeader, s)
+
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		// out of range seconds can not be converted to a duration
+		if !(secs >= 0 && secs <= math.MaxInt64/float64(time.Second)) {
+			return 0, invalid
+		}
+
+		ttl := time.Duration(secs * float64(time.Second))
+		if ttl == 0 && secs > 0 {
+			return 0, invalid
+		}
+
+		return ttl, nil
+	}
+
+	ttl, err := time.ParseDuration(s)
+	if err != nil || ttl < 0 {
+		return 0, invalid
+	}
+
+	if ttl == 0 && strings.ContainsAny(s, "123456789") {
+		return 0, invalid
+	}
+
+	return ttl, nil
+}
+
+// writeHTTPValue responds with the JSON value or the error of a Get
+func writeHTTPValue(w http.ResponseWriter, value interface{}, err error) {
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// writeHTTPResult responds with the result of a Set or a Delete
+func writeHTTPResult(w http.ResponseWriter, err error) {
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeHTTPError responds with the status of the error
+func writeHTTPError(w http.ResponseWriter, err error) {
+	switch {
+	case IsNotFound(err):
+		w.Header().Set(missHeader, "1")
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case err == errTTLNotSupported:
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,161 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ttlCache is a Cache which records the ttl of the keys set with SetEx
+type ttlCache struct {
+	Cache
+	ttls map[string]time.Duration
+}
+
+func (c *ttlCache) SetEx(key string, duration time.Duration, value interface{}) error {
+	c.ttls[key] = duration
+	return c.Set(key, value)
+}
+
+func serveHTTPHandler(h http.Handler, method, path, body string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	for k, v := range header {
+		r.Header.Set(k, v[0])
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestHTTPHandlerKeys(t *testing.T) {
+	h := NewHTTPHandler(NewMemory(), nil)
+
+	w := serveHTTPHandler(h, "PUT", "/keys/user%2F1", `{"name":"koding"}`, nil)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("put status should be 204, got %d", w.Code)
+	}
+
+	w = serveHTTPHandler(h, "GET", "/keys/user%2F1", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("get status should be 200, got %d", w.Code)
+	}
+
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("content type should be application/json, got %q", ct)
+	}
+
+	if body := w.Body.String(); body != `{"name":"koding"}` {
+		t.Fatalf("body should be the value, got %s", body)
+	}
+
+	w = serveHTTPHandler(h, "DELETE", "/keys/user%2F1", "", nil)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("delete status should be 204, got %d", w.Code)
+	}
+
+	w = serveHTTPHandler(h, "GET", "/keys/user%2F1", "", nil)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("deleted key status should be 404, got %d", w.Code)
+	}
+
+	if w.Header().Get(missHeader) == "" {
+		t.Fatalf("deleted key should be marked as a miss")
+	}
+}
+
+func TestHTTPHandlerTTL(t *testing.T) {
+	c := &ttlCache{Cache: NewMemory(), ttls: make(map[string]time.Duration)}
+	h := NewHTTPHandler(c, nil)
+
+	tests := map[string]time.Duration{
+		"90":    90 * time.Second,
+		"0.5":   500 * time.Millisecond,
+		"1m30s": 90 * time.Second,
+	}
+
+	for header, ttl := range tests {
+		w := serveHTTPHandler(h, "PUT", "/keys/"+header, `1`, http.Header{ttlHeader: {header}})
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("put with ttl %q status should be 204, got %d", header, w.Code)
+		}
+
+		if c.ttls[header] != ttl {
+			t.Fatalf("ttl %q should be %s, got %s", header, ttl, c.ttls[header])
+		}
+	}
+
+	for _, header := range []string{"soon", "-1", "1e-10", "1e300", "NaN", "0.1ns"} {
+		w := serveHTTPHandler(h, "PUT", "/keys/invalid", `1`, http.Header{ttlHeader: {header}})
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("invalid ttl %q status should be 400, got %d", header, w.Code)
+		}
+	}
+
+	w := serveHTTPHandler(h, "PUT", "/keys/zero", `1`, http.Header{ttlHeader: {"0s"}})
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("zero ttl status should be 204, got %d", w.Code)
+	}
+
+	w = serveHTTPHandler(NewHTTPHandler(NewMemory(), nil), "PUT", "/keys/k", `1`, http.Header{ttlHeader: {"10"}})
+	if w.Code != http.StatusNotImplemented {
+		t.Fatalf("ttl without SetEx status should be 501, got %d", w.Code)
+	}
+}
+
+func TestHTTPHandlerShards(t *testing.T) {
+	h := NewHTTPHandler(nil, NewShardedNoTS(NewMemNoTSCache))
+
+	w := serveHTTPHandler(h, "PUT", "/shards/tenant/keys/k", `"v"`, nil)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("put status should be 204, got %d", w.Code)
+	}
+
+	w = serveHTTPHandler(h, "GET", "/shards/tenant/keys/k", "", nil)
+	if w.Code != http.StatusOK || w.Body.String() != `"v"` {
+		t.Fatalf("get should return the value, got %d %s", w.Code, w.Body)
+	}
+
+	w = serveHTTPHandler(h, "DELETE", "/shards/tenant", "", nil)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("delete shard status should be 204, got %d", w.Code)
+	}
+
+	w = serveHTTPHandler(h, "GET", "/shards/tenant/keys/k", "", nil)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("key of deleted shard status should be 404, got %d", w.Code)
+	}
+
+	w = serveHTTPHandler(h, "GET", "/keys/k", "", nil)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("keys without a cache status should be 404, got %d", w.Code)
+	}
+}
+
+func TestHTTPHandlerBadRequests(t *testing.T) {
+	h := NewHTTPHandler(NewMemory(), NewShardedNoTS(NewMemNoTSCache))
+
+	tests := []struct {
+		method, path, body string
+		code               int
+	}{
+		{"PUT", "/keys/k", `{`, http.StatusBadRequest},
+		{"POST", "/keys/k", `1`, http.StatusMethodNotAllowed},
+		{"GET", "/shards/tenant", "", http.StatusMethodNotAllowed},
+		{"GET", "/keys/k/extra", "", http.StatusNotFound},
+		{"GET", "/values/k", "", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		w := serveHTTPHandler(h, test.method, test.path, test.body, nil)
+		if w.Code != test.code {
+			t.Fatalf("%s %s status should be %d, got %d", test.method, test.path, test.code, w.Code)
+		}
+
+		if w.Header().Get(missHeader) != "" {
+			t.Fatalf("%s %s should not be marked as a miss", test.method, test.path)
+		}
+	}
+}
@@ -47,3 +47,11 @@ func (l *LFU) Delete(key string) error {
 
 	return l.cache.Delete(key)
 }
+
+// Keys returns the keys of the items in the cache, it implements KeyLister
+func (l *LFU) Keys() []string {
+	l.Lock()
+	defer l.Unlock()
+
+	return l.cache.(KeyLister).Keys()
+}
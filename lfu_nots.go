@@ -16,6 +16,9 @@ type LFUNoTS struct {
 	// currentSize holds the current item size in the list
 	// after each adding of item, currentSize will be increased
 	currentSize int
+
+	// onEvict is called with the keys of the evicted items
+	onEvict func(key string)
 }
 
 type cacheItem struct {
@@ -95,6 +98,22 @@ func (l *LFUNoTS) Delete(key string) error {
 	return l.cache.Delete(key)
 }
 
+// Len returns the number of items in the cache
+func (l *LFUNoTS) Len() int {
+	return l.currentSize
+}
+
+// OnEvict sets the function which is called with the key of every evicted
+// item, it implements EvictionNotifier
+func (l *LFUNoTS) OnEvict(f func(key string)) {
+	l.onEvict = f
+}
+
+// Keys returns the keys of the items in the cache, it implements KeyLister
+func (l *LFUNoTS) Keys() []string {
+	return l.cache.(KeyLister).Keys()
+}
+
 // set sets a new key-value pair
 func (l *LFUNoTS) set(key string, value interface{}) error {
 	res, err := l.cache.Get(key)
@@ -202,6 +221,10 @@ func (l *LFUNoTS) evict(e *list.Element) error {
 		l.cache.Delete(entry.k)
 		l.remove(entry, e)
 		l.currentSize--
+
+		if l.onEvict != nil {
+			l.onEvict(entry.k)
+		}
 		break
 	}
 
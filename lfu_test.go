@@ -1,6 +1,9 @@
 package cache
 
-import "testing"
+import (
+	"sort"
+	"testing"
+)
 
 func TestLFUNGetSet(t *testing.T) {
 	cache := NewLFU(2)
@@ -75,3 +78,16 @@ func BenchmarkLFUSetGet1000(b *testing.B) {
 		cache.Get("keyBench")
 	}
 }
+
+func TestLFUKeys(t *testing.T) {
+	cache := NewLFU(2)
+	cache.Set("test_key1", "test_data1")
+	cache.Set("test_key2", "test_data2")
+	cache.Set("test_key3", "test_data3")
+
+	keys := cache.(KeyLister).Keys()
+	sort.Strings(keys)
+	if len(keys) != 2 || keys[1] != "test_key3" {
+		t.Fatalf("only the last 2 keys should be listed, got %v", keys)
+	}
+}
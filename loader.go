@@ -0,0 +1,202 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// Loader loads the value of a key from the source of truth, it returns
+// ErrNotFound if the key does not exist
+type Loader func(key string) (interface{}, error)
+
+// load calls the loader, its panics are returned as *PanicError
+func (f Loader) load(key string) (value interface{}, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			value, err = nil, &PanicError{Op: "load", Key: key, Value: v}
+		}
+	}()
+
+	return f(key)
+}
+
+// LoadingCache is a read-through cache, missing keys are loaded with its
+// loader and set to the underlying cache. Concurrent loads of the same key are
+// deduplicated, so only one of them calls the loader.
+//
+// If the underlying cache is a NegativeCache, keys which are not found by the
+// loader are cached as not found for the negative ttl. If it is a CostSetter,
+// values are set with their load durations, so the expensive keys are
+// recomputed earlier. Loaded values are not cached if the key is set or
+// deleted while it is being loaded, so they do not override the newer values.
+// Panics of the loader are returned as *PanicError
+type LoadingCache struct {
+	// cache holds the underlying cache
+	cache Cache
+
+	// loader loads the missing keys
+	loader Loader
+
+	// negativeTTL is the duration for caching the keys not found by loader,
+	// they are not cached if it is 0
+	negativeTTL time.Duration
+
+	// Mutex guards calls
+	sync.Mutex
+
+	// calls holds the in-flight loads, indexed by key
+	calls map[string]*loadCall
+}
+
+// loadCall is an in-flight or completed load
+type loadCall struct {
+	wg    sync.WaitGroup
+	value interface{}
+	err   error
+
+	// Mutex guards stale, it is held while the result of the load or a
+	// concurrent Set or Delete is written to the cache
+	sync.Mutex
+
+	// stale is set when the key is set or deleted during the load
+	stale bool
+}
+
+// NewLoadingCache creates a read-through cache over the given cache
+func NewLoadingCache(c Cache, loader Loader, negativeTTL time.Duration) *LoadingCache {
+	return &LoadingCache{
+		cache:       c,
+		loader:      loader,
+		negativeTTL: negativeTTL,
+		calls:       make(map[string]*loadCall),
+	}
+}
+
+// Get returns the value of a given key, it is loaded if it is not in the
+// cache. ErrCachedNotFound is returned if the key is cached as not found,
+// ErrNotFound is returned if the loader does not find the key
+func (l *LoadingCache) Get(key string) (interface{}, error) {
+	value, err := l.cache.Get(key)
+	if err != ErrNotFound {
+		return value, err
+	}
+
+	return l.Load(key)
+}
+
+// Load loads the value of a given key with the loader and sets it to the
+// cache, regardless of it is in the cache or not
+func (l *LoadingCache) Load(key string) (interface{}, error) {
+	return l.do(key, func(c *loadCall) (interface{}, error) {
+		start := time.Now()
+		value, err := l.loader.load(key)
+		if err != nil && err != ErrNotFound {
+			return nil, err
+		}
+
+		c.Lock()
+		defer c.Unlock()
+
+		// key is set or deleted during the load, loaded value may be older
+		// than the one in the cache
+		if c.stale {
+			return value, err
+		}
+
+		if err == ErrNotFound {
+			l.setNotFound(key)
+			return nil, ErrNotFound
+		}
+
+		if err := l.set(key, value, time.Since(start)); err != nil {
+			return nil, err
+		}
+
+		return value, nil
+	})
+}
+
+// Set sets the value to the underlying cache
+func (l *LoadingCache) Set(key string, value interface{}) error {
+	if c := l.invalidate(key); c != nil {
+		defer c.Unlock()
+	}
+
+	return l.cache.Set(key, value)
+}
+
+// Delete deletes the given key from the underlying cache
+func (l *LoadingCache) Delete(key string) error {
+	if c := l.invalidate(key); c != nil {
+		defer c.Unlock()
+	}
+
+	return l.cache.Delete(key)
+}
+
+// invalidate marks the in-flight load of the key as stale, so its result is
+// not cached. The load is returned locked and it must be unlocked after the
+// key is written, it is nil if the key is not being loaded
+func (l *LoadingCache) invalidate(key string) *loadCall {
+	l.Lock()
+	c, ok := l.calls[key]
+	l.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	c.Lock()
+	c.stale = true
+	return c
+}
+
+// set sets the value to the cache with its load duration, if the cache
+// supports early expiration
+func (l *LoadingCache) set(key string, value interface{}, cost time.Duration) error {
+	return setWithCost(l.cache, key, value, cost)
+}
+
+func (l *LoadingCache) setNotFound(key string) {
+	if l.negativeTTL <= 0 {
+		return
+	}
+
+	if nc, ok := l.cache.(NegativeCache); ok {
+		nc.SetNotFound(key, l.negativeTTL)
+	}
+}
+
+// do calls f for the key, if there is an in-flight call for the key, its
+// result is waited and returned instead. Panics of f are returned as errors
+func (l *LoadingCache) do(key string, f func(c *loadCall) (interface{}, error)) (value interface{}, err error) {
+	l.Lock()
+	if c, ok := l.calls[key]; ok {
+		l.Unlock()
+		c.wg.Wait()
+		return c.value, c.err
+	}
+
+	c := new(loadCall)
+	c.wg.Add(1)
+	l.calls[key] = c
+	l.Unlock()
+
+	defer func() {
+		c.wg.Done()
+
+		l.Lock()
+		delete(l.calls, key)
+		l.Unlock()
+	}()
+
+	defer func() {
+		if v := recover(); v != nil {
+			value, err = nil, &PanicError{Op: "load", Key: key, Value: v}
+		}
+
+		c.value, c.err = value, err
+	}()
+
+	return f(c)
+}
@@ -0,0 +1,159 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/koding/cache/clock/clocktest"
+)
+
+func TestLoadingCacheGet(t *testing.T) {
+	var loads int32
+	cache := NewLoadingCache(NewMemory(), func(key string) (interface{}, error) {
+		atomic.AddInt32(&loads, 1)
+		return key + "_data", nil
+	}, 0)
+
+	for i := 0; i < 2; i++ {
+		data, err := cache.Get("test_key")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if data != "test_key_data" {
+			t.Fatalf("data should equal test_key_data, got: %v", data)
+		}
+	}
+
+	if loads != 1 {
+		t.Fatalf("key should be loaded once, got: %d", loads)
+	}
+}
+
+func TestLoadingCacheError(t *testing.T) {
+	errLoad := errors.New("load failed")
+	cache := NewLoadingCache(NewMemory(), func(key string) (interface{}, error) {
+		return nil, errLoad
+	}, time.Minute)
+
+	if _, err := cache.Get("test_key"); err != errLoad {
+		t.Fatalf("error should equal to %q, got: %v", errLoad, err)
+	}
+}
+
+func TestLoadingCacheNegative(t *testing.T) {
+	var loads int32
+	clock := clocktest.NewFake(time.Now())
+	cache := NewLoadingCache(NewMemoryWithTTL(time.Hour, WithClock(clock)), func(key string) (interface{}, error) {
+		atomic.AddInt32(&loads, 1)
+		return nil, ErrNotFound
+	}, time.Minute)
+
+	if _, err := cache.Get("test_key"); err != ErrNotFound {
+		t.Fatalf("error should equal to %q, got: %v", ErrNotFound, err)
+	}
+
+	if _, err := cache.Get("test_key"); err != ErrCachedNotFound {
+		t.Fatalf("error should equal to %q, got: %v", ErrCachedNotFound, err)
+	}
+
+	if loads != 1 {
+		t.Fatalf("key should be loaded once, got: %d", loads)
+	}
+
+	// negative entry expires with its own ttl
+	clock.Advance(2 * time.Minute)
+	if _, err := cache.Get("test_key"); err != ErrNotFound {
+		t.Fatalf("error should equal to %q, got: %v", ErrNotFound, err)
+	}
+
+	if loads != 2 {
+		t.Fatalf("key should be loaded again, got: %d", loads)
+	}
+}
+
+func TestLoadingCacheDeduplicate(t *testing.T) {
+	var loads int32
+	release := make(chan struct{})
+	cache := NewLoadingCache(NewMemory(), func(key string) (interface{}, error) {
+		atomic.AddInt32(&loads, 1)
+		<-release
+		return "test_data", nil
+	}, 0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if data, err := cache.Get("test_key"); err != nil || data != "test_data" {
+				t.Errorf("data should equal test_data, got: %v, %v", data, err)
+			}
+		}()
+	}
+
+	// wait for the first load to start, others either wait for it or find
+	// the key in the cache
+	for atomic.LoadInt32(&loads) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if loads != 1 {
+		t.Fatalf("key should be loaded once, got: %d", loads)
+	}
+}
+
+func TestLoadingCachePanic(t *testing.T) {
+	var loads int32
+	cache := NewLoadingCache(NewMemory(), func(key string) (interface{}, error) {
+		if atomic.AddInt32(&loads, 1) == 1 {
+			panic("source is down")
+		}
+
+		return "test_data", nil
+	}, 0)
+
+	_, err := cache.Get("test_key")
+	if perr, ok := err.(*PanicError); !ok || perr.Value != "source is down" {
+		t.Fatalf("error should be *PanicError, got: %v", err)
+	}
+
+	// panicked load is not left in-flight
+	if data, err := cache.Get("test_key"); err != nil || data != "test_data" {
+		t.Fatalf("data should equal test_data, got: %v, %v", data, err)
+	}
+}
+
+func TestLoadingCacheSetDuringLoad(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	cache := NewLoadingCache(NewMemory(), func(key string) (interface{}, error) {
+		close(started)
+		<-release
+		return "stale_data", nil
+	}, 0)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if data, err := cache.Get("test_key"); err != nil || data != "stale_data" {
+			t.Errorf("loaded data should be returned, got: %v, %v", data, err)
+		}
+	}()
+
+	<-started
+	if err := cache.Set("test_key", "test_data"); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	<-done
+
+	if data, err := cache.Get("test_key"); err != nil || data != "test_data" {
+		t.Fatalf("data set during the load should be kept, got: %v, %v", data, err)
+	}
+}
@@ -49,3 +49,11 @@ func (l *LRU) Delete(key string) error {
 
 	return l.cache.Delete(key)
 }
+
+// Keys returns the keys of the items in the cache, it implements KeyLister
+func (l *LRU) Keys() []string {
+	l.Lock()
+	defer l.Unlock()
+
+	return l.cache.(KeyLister).Keys()
+}
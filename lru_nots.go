@@ -15,6 +15,9 @@ type LRUNoTS struct {
 
 	// size holds the limit of the LRU cache
 	size int
+
+	// onEvict is called with the keys of the evicted items
+	onEvict func(key string)
 }
 
 // kv is an helper struct for keeping track of the key for the list item. Only
@@ -90,8 +93,16 @@ func (l *LRUNoTS) Set(key string, val interface{}) error {
 
 	// if the cache is full, evict last entry
 	if l.list.Len() > l.size {
+		last := l.list.Back()
+
 		// remove last element from cache
-		return l.removeElem(l.list.Back())
+		if err := l.removeElem(last); err != nil {
+			return err
+		}
+
+		if l.onEvict != nil {
+			l.onEvict(last.Value.(*kv).k)
+		}
 	}
 
 	return nil
@@ -116,6 +127,22 @@ func (l *LRUNoTS) Delete(key string) error {
 	return l.removeElem(elem)
 }
 
+// Len returns the number of items in the cache
+func (l *LRUNoTS) Len() int {
+	return l.list.Len()
+}
+
+// OnEvict sets the function which is called with the key of every evicted
+// item, it implements EvictionNotifier
+func (l *LRUNoTS) OnEvict(f func(key string)) {
+	l.onEvict = f
+}
+
+// Keys returns the keys of the items in the cache, it implements KeyLister
+func (l *LRUNoTS) Keys() []string {
+	return l.cache.(KeyLister).Keys()
+}
+
 func (l *LRUNoTS) removeElem(e *list.Element) error {
 	l.list.Remove(e)
 	return l.cache.Delete(e.Value.(*kv).k)
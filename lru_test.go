@@ -1,6 +1,9 @@
 package cache
 
-import "testing"
+import (
+	"sort"
+	"testing"
+)
 
 func TestLRUGetSet(t *testing.T) {
 	cache := NewLRU(2)
@@ -31,3 +34,16 @@ func TestLRUNilValue(t *testing.T) {
 	cache := NewLRU(2)
 	testCacheNilValue(t, cache)
 }
+
+func TestLRUKeys(t *testing.T) {
+	cache := NewLRU(2)
+	cache.Set("test_key1", "test_data1")
+	cache.Set("test_key2", "test_data2")
+	cache.Set("test_key3", "test_data3")
+
+	keys := cache.(KeyLister).Keys()
+	sort.Strings(keys)
+	if len(keys) != 2 || keys[1] != "test_key3" {
+		t.Fatalf("only the last 2 keys should be listed, got %v", keys)
+	}
+}
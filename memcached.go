@@ -0,0 +1,530 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMemcachedPoolSize     = 10
+	defaultMemcachedTimeout      = time.Second
+	defaultMemcachedMaxValueSize = 32 << 20
+
+	// memcachedFlags marks the values stored by MemcachedCache, their kinds
+	// are in the low byte of the flags
+	memcachedFlags = 0x4b430000
+
+	// memcachedMaxRelativeExpiry is the longest expiration time memcached
+	// accepts as relative seconds, longer ones must be unix timestamps
+	memcachedMaxRelativeExpiry = 30 * 24 * time.Hour
+)
+
+var (
+	// ErrNotStored is returned by Add and Replace when the condition of the
+	// command is not met
+	ErrNotStored = errors.New("memcached: item not stored")
+
+	// ErrCASConflict is returned by CAS when the item is modified since it is
+	// read
+	ErrCASConflict = errors.New("memcached: compare-and-swap conflict")
+
+	// ErrMalformedKey is returned for the keys which are longer than 250
+	// bytes or contain spaces or control characters
+	ErrMalformedKey = errors.New("memcached: malformed key")
+
+	// ErrPoolClosed is returned for the commands run after Close
+	ErrPoolClosed = errors.New("memcached: pool is closed")
+)
+
+// MemcachedCache is a Cache backed by a memcached server, it speaks the
+// memcached text protocol over a pool of connections. []byte and string
+// values are stored as they are, other values are encoded with the codec. The
+// kinds of the values are stored in their flags, values set by other clients
+// are returned as []byte
+type MemcachedCache struct {
+	// addr is the address of the memcached server
+	addr string
+
+	// codec encodes the values which are not []byte or string
+	codec Codec
+
+	// timeout is the deadline of dialing and of every command
+	timeout time.Duration
+
+	// sem limits the number of open connections
+	sem chan struct{}
+
+	// maxValueSize is the maximum size of the values read from the server
+	maxValueSize int
+
+	// Mutex guards idle and closed
+	sync.Mutex
+
+	// idle holds the connections which are ready for reuse
+	idle []*memcachedConn
+
+	// closed is true after Close
+	closed bool
+}
+
+// memcachedConn is a connection to the memcached server
+type memcachedConn struct {
+	nc net.Conn
+	rw *bufio.ReadWriter
+}
+
+// MemcachedOption sets the options specified for MemcachedCache.
+type MemcachedOption func(*MemcachedCache)
+
+// WithPoolSize sets the maximum number of open connections to the server,
+// commands wait for a free connection when it is reached. Default is 10
+func WithPoolSize(n int) MemcachedOption {
+	if n <= 0 {
+		panic("invalid pool size")
+	}
+
+	return func(m *MemcachedCache) {
+		m.sem = make(chan struct{}, n)
+	}
+}
+
+// WithTimeout sets the deadline of dialing and of every command, default is
+// a second
+func WithTimeout(d time.Duration) MemcachedOption {
+	return func(m *MemcachedCache) {
+		m.timeout = d
+	}
+}
+
+// WithMemcachedMaxValueSize sets the maximum size of the values read from the
+// server, larger values fail before they are read. Default is 32MB
+func WithMemcachedMaxValueSize(n int) MemcachedOption {
+	if n <= 0 {
+		panic("invalid max value size")
+	}
+
+	return func(m *MemcachedCache) {
+		m.maxValueSize = n
+	}
+}
+
+// WithMemcachedCodec sets the codec for encoding the values which are not
+// []byte or string, default is JSONCodec
+func WithMemcachedCodec(c Codec) MemcachedOption {
+	return func(m *MemcachedCache) {
+		m.codec = c
+	}
+}
+
+// NewMemcachedCache creates a cache backed by the memcached server at the
+// given address, connections are dialed lazily
+// usage:
+// NewMemcachedCache("localhost:11211", WithPoolSize(20))
+func NewMemcachedCache(addr string, opts ...MemcachedOption) *MemcachedCache {
+	m := &MemcachedCache{
+		addr:    addr,
+		codec:   JSONCodec,
+		timeout: defaultMemcachedTimeout,
+		sem:     make(chan struct{}, defaultMemcachedPoolSize),
+
+		maxValueSize: defaultMemcachedMaxValueSize,
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m
+}
+
+// Get returns the value of a given key if it exists
+func (m *MemcachedCache) Get(key string) (interface{}, error) {
+	value, _, err := m.Gets(key)
+	return value, err
+}
+
+// Gets returns the value of a given key with its CAS token, which can be
+// passed to CAS for updating the value only if it is not modified meanwhile
+func (m *MemcachedCache) Gets(key string) (interface{}, uint64, error) {
+	data, flags, cas, err := m.get(key)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	value, err := decodeValue(m.codec, data, memcachedKind(flags))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return value, cas, nil
+}
+
+// GetInto decodes the value of a given key into dst, which must be a pointer
+func (m *MemcachedCache) GetInto(key string, dst interface{}) error {
+	data, flags, _, err := m.get(key)
+	if err != nil {
+		return err
+	}
+
+	return decodeValueInto(m.codec, data, memcachedKind(flags), dst)
+}
+
+// Set will persist a value to the cache or override existing one with the new
+// one, it never expires
+func (m *MemcachedCache) Set(key string, value interface{}) error {
+	return m.store("set", key, 0, value, 0)
+}
+
+// SetEx will persist a value to the cache or override existing one with the
+// new one with ttl duration
+func (m *MemcachedCache) SetEx(key string, duration time.Duration, value interface{}) error {
+	return m.store("set", key, duration, value, 0)
+}
+
+// Add persists a value only if the key does not exist, ErrNotStored is
+// returned otherwise. Zero duration never expires
+func (m *MemcachedCache) Add(key string, duration time.Duration, value interface{}) error {
+	return m.store("add", key, duration, value, 0)
+}
+
+// Replace persists a value only if the key exists, ErrNotStored is returned
+// otherwise. Zero duration never expires
+func (m *MemcachedCache) Replace(key string, duration time.Duration, value interface{}) error {
+	return m.store("replace", key, duration, value, 0)
+}
+
+// CAS persists a value only if it is not modified since it is read with
+// Gets, ErrCASConflict is returned if it is modified and ErrNotFound if it is
+// deleted. Zero duration never expires
+func (m *MemcachedCache) CAS(key string, duration time.Duration, value interface{}, cas uint64) error {
+	return m.store("cas", key, duration, value, cas)
+}
+
+// Incr increments the value of the key by delta and returns the new value,
+// the value must be a decimal number. ErrNotFound is returned if the key does
+// not exist
+func (m *MemcachedCache) Incr(key string, delta uint64) (uint64, error) {
+	if !validMemcachedKey(key) {
+		return 0, ErrMalformedKey
+	}
+
+	var n uint64
+	err := m.do(func(c *memcachedConn) error {
+		line, err := c.command("incr %s %d\r\n", key, delta)
+		if err != nil {
+			return err
+		}
+
+		if line == "NOT_FOUND" {
+			return ErrNotFound
+		}
+
+		if n, err = strconv.ParseUint(line, 10, 64); err != nil {
+			return memcachedError(line)
+		}
+
+		return nil
+	})
+
+	return n, err
+}
+
+// Delete deletes a given key if exists
+func (m *MemcachedCache) Delete(key string) error {
+	if !validMemcachedKey(key) {
+		return ErrMalformedKey
+	}
+
+	return m.do(func(c *memcachedConn) error {
+		line, err := c.command("delete %s\r\n", key)
+		if err != nil {
+			return err
+		}
+
+		if line == "DELETED" || line == "NOT_FOUND" {
+			return nil
+		}
+
+		return memcachedError(line)
+	})
+}
+
+// Close closes the idle connections, connections in use are closed when they
+// are released. It implements io.Closer
+func (m *MemcachedCache) Close() error {
+	m.Lock()
+	defer m.Unlock()
+
+	m.closed = true
+	for _, c := range m.idle {
+		c.nc.Close()
+	}
+	m.idle = nil
+
+	return nil
+}
+
+// get fetches the raw value of the key with its flags and CAS token
+func (m *MemcachedCache) get(key string) ([]byte, uint32, uint64, error) {
+	if !validMemcachedKey(key) {
+		return nil, 0, 0, ErrMalformedKey
+	}
+
+	var (
+		data  []byte
+		flags uint32
+		cas   uint64
+	)
+
+	err := m.do(func(c *memcachedConn) error {
+		line, err := c.command("gets %s\r\n", key)
+		if err != nil {
+			return err
+		}
+
+		if line == "END" {
+			return ErrNotFound
+		}
+
+		// VALUE <key> <flags> <bytes> <cas unique>
+		var k string
+		var size int
+		if _, err := fmt.Sscanf(line, "VALUE %s %d %d %d", &k, &flags, &size, &cas); err != nil {
+			return memcachedError(line)
+		}
+
+		// size is checked before it is allocated, the connection is not
+		// reused since the value is not read
+		if size < 0 || size > m.maxValueSize {
+			return memcachedError(fmt.Sprintf("value size %d is out of range", size))
+		}
+
+		data = make([]byte, size+2)
+		if _, err := io.ReadFull(c.rw, data); err != nil {
+			return err
+		}
+
+		if !bytes.HasSuffix(data, crlf) {
+			return memcachedError("malformed value")
+		}
+		data = data[:size]
+
+		if line, err = c.readLine(); err != nil {
+			return err
+		}
+
+		if line != "END" {
+			return memcachedError(line)
+		}
+
+		return nil
+	})
+
+	return data, flags, cas, err
+}
+
+// memcachedKind returns the value kind stored in the flags, values without
+// the mark of memcachedFlags are set by other clients and they are returned as
+// raw bytes
+func memcachedKind(flags uint32) uint8 {
+	if flags&^0xff != memcachedFlags {
+		return kindBytes
+	}
+
+	return uint8(flags)
+}
+
+// store runs a storage command, cas is only sent with the cas command
+func (m *MemcachedCache) store(cmd, key string, duration time.Duration, value interface{}, cas uint64) error {
+	if !validMemcachedKey(key) {
+		return ErrMalformedKey
+	}
+
+	data, kind, err := encodeValue(m.codec, value)
+	if err != nil {
+		return err
+	}
+
+	exptime := memcachedExpiry(duration)
+
+	return m.do(func(c *memcachedConn) error {
+		header := fmt.Sprintf("%s %s %d %d %d", cmd, key, memcachedFlags|uint32(kind), exptime, len(data))
+		if cmd == "cas" {
+			header += " " + strconv.FormatUint(cas, 10)
+		}
+
+		fmt.Fprintf(c.rw, "%s\r\n", header)
+		c.rw.Write(data)
+
+		line, err := c.command("\r\n")
+		if err != nil {
+			return err
+		}
+
+		switch line {
+		case "STORED":
+			return nil
+		case "NOT_STORED":
+			return ErrNotStored
+		case "EXISTS":
+			return ErrCASConflict
+		case "NOT_FOUND":
+			return ErrNotFound
+		}
+
+		return memcachedError(line)
+	})
+}
+
+// do runs f with a pooled connection, the connection is closed instead of
+// being reused if f fails with an error other than the protocol responses
+func (m *MemcachedCache) do(f func(c *memcachedConn) error) error {
+	c, err := m.conn()
+	if err != nil {
+		return err
+	}
+
+	if m.timeout > 0 {
+		c.nc.SetDeadline(time.Now().Add(m.timeout))
+	}
+
+	err = f(c)
+	m.release(c, isMemcachedResponse(err))
+
+	return err
+}
+
+// conn returns an idle connection or dials a new one, it blocks while the
+// pool is full
+func (m *MemcachedCache) conn() (*memcachedConn, error) {
+	m.sem <- struct{}{}
+
+	m.Lock()
+	if m.closed {
+		m.Unlock()
+		<-m.sem
+		return nil, ErrPoolClosed
+	}
+
+	if n := len(m.idle); n > 0 {
+		c := m.idle[n-1]
+		m.idle = m.idle[:n-1]
+		m.Unlock()
+		return c, nil
+	}
+	m.Unlock()
+
+	nc, err := net.DialTimeout("tcp", m.addr, m.timeout)
+	if err != nil {
+		<-m.sem
+		return nil, err
+	}
+
+	return &memcachedConn{
+		nc: nc,
+		rw: bufio.NewReadWriter(bufio.NewReader(nc), bufio.NewWriter(nc)),
+	}, nil
+}
+
+// release puts the connection back to the pool if it is reusable
+func (m *MemcachedCache) release(c *memcachedConn, reuse bool) {
+	defer func() { <-m.sem }()
+
+	m.Lock()
+	defer m.Unlock()
+
+	if !reuse || m.closed {
+		c.nc.Close()
+		return
+	}
+
+	m.idle = append(m.idle, c)
+}
+
+var crlf = []byte("\r\n")
+
+// command writes the command, flushes it and reads the response line
+func (c *memcachedConn) command(format string, args ...interface{}) (string, error) {
+	if _, err := fmt.Fprintf(c.rw, format, args...); err != nil {
+		return "", err
+	}
+
+	if err := c.rw.Flush(); err != nil {
+		return "", err
+	}
+
+	return c.readLine()
+}
+
+// readLine reads a response line without its trailing CRLF
+func (c *memcachedConn) readLine() (string, error) {
+	line, err := c.rw.ReadSlice('\n')
+	if err != nil {
+		return "", err
+	}
+
+	if !bytes.HasSuffix(line, crlf) {
+		return "", memcachedError("malformed response line")
+	}
+
+	return string(line[:len(line)-2]), nil
+}
+
+// memcachedError is an error response of the server, or an unexpected one
+type memcachedError string
+
+// Error implements the error interface
+func (e memcachedError) Error() string {
+	return "memcached: " + string(e)
+}
+
+// isMemcachedResponse reports whether the error is a complete response of the
+// server, so the connection is still in a known state
+func isMemcachedResponse(err error) bool {
+	switch err {
+	case nil, ErrNotFound, ErrNotStored, ErrCASConflict:
+		return true
+	}
+
+	// generic ERROR responses are complete, malformed ones are not
+	e, ok := err.(memcachedError)
+	return ok && (e == "ERROR" || strings.HasPrefix(string(e), "CLIENT_ERROR") || strings.HasPrefix(string(e), "SERVER_ERROR"))
+}
+
+// memcachedExpiry converts the duration to the exptime of memcached, long
+// durations are sent as unix timestamps
+func memcachedExpiry(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+
+	if d > memcachedMaxRelativeExpiry {
+		return time.Now().Add(d).Unix()
+	}
+
+	// round up, so short durations do not mean never expire
+	return int64((d + time.Second - 1) / time.Second)
+}
+
+// validMemcachedKey reports whether the key can be sent to memcached
+func validMemcachedKey(key string) bool {
+	if len(key) == 0 || len(key) > 250 {
+		return false
+	}
+
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+
+	return true
+}
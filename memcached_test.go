@@ -0,0 +1,508 @@
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/koding/cache/clock/clocktest"
+)
+
+// fakeMemcached is an in-process memcached server which speaks the subset of
+// the text protocol used by MemcachedCache
+type fakeMemcached struct {
+	ln    net.Listener
+	clock *clocktest.Fake
+
+	sync.Mutex
+	items map[string]*fakeItem
+	cas   uint64
+
+	// conns and maxConns count the open connections
+	conns, maxConns int
+}
+
+type fakeItem struct {
+	data     []byte
+	flags    uint32
+	cas      uint64
+	expireAt time.Time
+}
+
+func newFakeMemcached(t *testing.T) *fakeMemcached {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &fakeMemcached{
+		ln:    ln,
+		clock: clocktest.NewFake(time.Now()),
+		items: make(map[string]*fakeItem),
+	}
+
+	go s.serve()
+	t.Cleanup(func() { ln.Close() })
+
+	return s
+}
+
+func (s *fakeMemcached) addr() string {
+	return s.ln.Addr().String()
+}
+
+func (s *fakeMemcached) serve() {
+	for {
+		nc, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		s.Lock()
+		s.conns++
+		if s.conns > s.maxConns {
+			s.maxConns = s.conns
+		}
+		s.Unlock()
+
+		go s.handle(nc)
+	}
+}
+
+func (s *fakeMemcached) handle(nc net.Conn) {
+	defer func() {
+		nc.Close()
+
+		s.Lock()
+		s.conns--
+		s.Unlock()
+	}()
+
+	rw := bufio.NewReadWriter(bufio.NewReader(nc), bufio.NewWriter(nc))
+	for {
+		line, err := rw.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			fmt.Fprint(rw, "ERROR\r\n")
+			rw.Flush()
+			continue
+		}
+
+		switch fields[0] {
+		case "get", "gets":
+			s.get(rw, fields[0] == "gets", fields[1:])
+		case "set", "add", "replace", "cas":
+			if !s.store(rw, fields) {
+				return
+			}
+		case "incr":
+			s.incr(rw, fields[1], fields[2])
+		case "delete":
+			s.delete(rw, fields[1])
+		default:
+			fmt.Fprint(rw, "ERROR\r\n")
+		}
+
+		rw.Flush()
+	}
+}
+
+// item returns the non-expired item of the key, it must be called with the
+// lock held
+func (s *fakeMemcached) item(key string) (*fakeItem, bool) {
+	item, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	if !item.expireAt.IsZero() && !item.expireAt.After(s.clock.Now()) {
+		delete(s.items, key)
+		return nil, false
+	}
+
+	return item, true
+}
+
+func (s *fakeMemcached) get(w io.Writer, withCAS bool, keys []string) {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, key := range keys {
+		item, ok := s.item(key)
+		if !ok {
+			continue
+		}
+
+		if withCAS {
+			fmt.Fprintf(w, "VALUE %s %d %d %d\r\n", key, item.flags, len(item.data), item.cas)
+		} else {
+			fmt.Fprintf(w, "VALUE %s %d %d\r\n", key, item.flags, len(item.data))
+		}
+
+		w.Write(item.data)
+		fmt.Fprint(w, "\r\n")
+	}
+
+	fmt.Fprint(w, "END\r\n")
+}
+
+// store handles the storage commands, it returns false if the connection
+// should be closed
+func (s *fakeMemcached) store(rw *bufio.ReadWriter, fields []string) bool {
+	if len(fields) < 5 {
+		fmt.Fprint(rw, "ERROR\r\n")
+		return true
+	}
+
+	cmd, key := fields[0], fields[1]
+	flags, _ := strconv.ParseUint(fields[2], 10, 32)
+	exptime, _ := strconv.ParseInt(fields[3], 10, 64)
+	size, _ := strconv.Atoi(fields[4])
+
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(rw, data); err != nil {
+		return false
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	item, exists := s.item(key)
+	switch {
+	case cmd == "add" && exists, cmd == "replace" && !exists:
+		fmt.Fprint(rw, "NOT_STORED\r\n")
+		return true
+	case cmd == "cas" && !exists:
+		fmt.Fprint(rw, "NOT_FOUND\r\n")
+		return true
+	case cmd == "cas" && fields[5] != strconv.FormatUint(item.cas, 10):
+		fmt.Fprint(rw, "EXISTS\r\n")
+		return true
+	}
+
+	s.cas++
+	item = &fakeItem{data: data[:size], flags: uint32(flags), cas: s.cas}
+	if exptime > 0 {
+		item.expireAt = s.clock.Now().Add(time.Duration(exptime) * time.Second)
+	}
+
+	s.items[key] = item
+	fmt.Fprint(rw, "STORED\r\n")
+	return true
+}
+
+func (s *fakeMemcached) incr(w io.Writer, key, delta string) {
+	s.Lock()
+	defer s.Unlock()
+
+	item, ok := s.item(key)
+	if !ok {
+		fmt.Fprint(w, "NOT_FOUND\r\n")
+		return
+	}
+
+	n, err := strconv.ParseUint(string(item.data), 10, 64)
+	if err != nil {
+		fmt.Fprint(w, "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n")
+		return
+	}
+
+	d, _ := strconv.ParseUint(delta, 10, 64)
+	s.cas++
+	item.data = []byte(strconv.FormatUint(n+d, 10))
+	item.cas = s.cas
+	fmt.Fprintf(w, "%d\r\n", n+d)
+}
+
+func (s *fakeMemcached) delete(w io.Writer, key string) {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.item(key); !ok {
+		fmt.Fprint(w, "NOT_FOUND\r\n")
+		return
+	}
+
+	delete(s.items, key)
+	fmt.Fprint(w, "DELETED\r\n")
+}
+
+func TestMemcachedCacheGetSet(t *testing.T) {
+	s := newFakeMemcached(t)
+	cache := NewMemcachedCache(s.addr())
+	defer cache.Close()
+
+	testCacheGetSet(t, cache)
+}
+
+func TestMemcachedCacheNilValue(t *testing.T) {
+	s := newFakeMemcached(t)
+	cache := NewMemcachedCache(s.addr())
+	defer cache.Close()
+
+	testCacheNilValue(t, cache)
+}
+
+func TestMemcachedCacheDelete(t *testing.T) {
+	s := newFakeMemcached(t)
+	cache := NewMemcachedCache(s.addr())
+	defer cache.Close()
+
+	testCacheDelete(t, cache)
+}
+
+func TestMemcachedCacheValues(t *testing.T) {
+	s := newFakeMemcached(t)
+	cache := NewMemcachedCache(s.addr())
+	defer cache.Close()
+
+	cache.Set("bytes", []byte("test_data"))
+	if data, err := cache.Get("bytes"); err != nil || string(data.([]byte)) != "test_data" {
+		t.Fatalf("data should equal to test_data, got: %v, %v", data, err)
+	}
+
+	type user struct {
+		Name string
+		Age  int
+	}
+
+	cache.Set("user", user{Name: "koding", Age: 10})
+
+	var u user
+	if err := cache.GetInto("user", &u); err != nil {
+		t.Fatal(err)
+	}
+
+	if u.Name != "koding" || u.Age != 10 {
+		t.Fatalf("user should be decoded, got: %+v", u)
+	}
+}
+
+func TestMemcachedCacheForeignFlags(t *testing.T) {
+	s := newFakeMemcached(t)
+	cache := NewMemcachedCache(s.addr())
+	defer cache.Close()
+
+	// values set by other clients do not have the mark of the flags, even
+	// if their flags fit in a value kind
+	for _, flags := range []uint32{0, 1, 2, 0x102} {
+		s.Lock()
+		s.items["test_key"] = &fakeItem{data: []byte("test_data"), flags: flags}
+		s.Unlock()
+
+		data, err := cache.Get("test_key")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if b, ok := data.([]byte); !ok || string(b) != "test_data" {
+			t.Fatalf("data with flags %#x should be returned as raw bytes, got: %#v", flags, data)
+		}
+	}
+}
+
+func TestMemcachedCacheMaxValueSize(t *testing.T) {
+	s := newFakeMemcached(t)
+	cache := NewMemcachedCache(s.addr(), WithMemcachedMaxValueSize(8))
+	defer cache.Close()
+
+	cache.Set("small", "value")
+	cache.Set("large", "larger value")
+
+	if _, err := cache.Get("large"); err == nil {
+		t.Fatal("value larger than the limit should give err")
+	}
+
+	if data, err := cache.Get("small"); err != nil || data != "value" {
+		t.Fatalf("small should be read after the large value, got: %v, %v", data, err)
+	}
+}
+
+func TestMemcachedCacheNegativeSize(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		nc, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer nc.Close()
+
+		bufio.NewReader(nc).ReadString('\n')
+		io.WriteString(nc, "VALUE test_key 0 -1 1\r\n")
+	}()
+
+	cache := NewMemcachedCache(ln.Addr().String())
+	defer cache.Close()
+
+	if _, err := cache.Get("test_key"); err == nil {
+		t.Fatal("negative size should give err")
+	}
+}
+
+func TestMemcachedCacheSetEx(t *testing.T) {
+	s := newFakeMemcached(t)
+	cache := NewMemcachedCache(s.addr())
+	defer cache.Close()
+
+	if err := cache.SetEx("test_key", time.Minute, "test_data"); err != nil {
+		t.Fatal(err)
+	}
+
+	s.clock.Advance(30 * time.Second)
+	if _, err := cache.Get("test_key"); err != nil {
+		t.Fatalf("test_key should be in the cache, got: %v", err)
+	}
+
+	s.clock.Advance(30 * time.Second)
+	if _, err := cache.Get("test_key"); err != ErrNotFound {
+		t.Fatalf("error should equal to %q, got: %v", ErrNotFound, err)
+	}
+}
+
+func TestMemcachedCacheAddReplace(t *testing.T) {
+	s := newFakeMemcached(t)
+	cache := NewMemcachedCache(s.addr())
+	defer cache.Close()
+
+	if err := cache.Replace("test_key", 0, "test_data"); err != ErrNotStored {
+		t.Fatalf("error should equal to %q, got: %v", ErrNotStored, err)
+	}
+
+	if err := cache.Add("test_key", 0, "test_data"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.Add("test_key", 0, "test_data2"); err != ErrNotStored {
+		t.Fatalf("error should equal to %q, got: %v", ErrNotStored, err)
+	}
+
+	if err := cache.Replace("test_key", 0, "test_data3"); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err := cache.Get("test_key"); err != nil || data != "test_data3" {
+		t.Fatalf("data should equal to test_data3, got: %v, %v", data, err)
+	}
+}
+
+func TestMemcachedCacheCAS(t *testing.T) {
+	s := newFakeMemcached(t)
+	cache := NewMemcachedCache(s.addr())
+	defer cache.Close()
+
+	cache.Set("test_key", "test_data")
+
+	_, cas, err := cache.Gets("test_key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.CAS("test_key", 0, "test_data2", cas); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.CAS("test_key", 0, "test_data3", cas); err != ErrCASConflict {
+		t.Fatalf("error should equal to %q, got: %v", ErrCASConflict, err)
+	}
+
+	if err := cache.CAS("test_key2", 0, "test_data", cas); err != ErrNotFound {
+		t.Fatalf("error should equal to %q, got: %v", ErrNotFound, err)
+	}
+
+	if data, err := cache.Get("test_key"); err != nil || data != "test_data2" {
+		t.Fatalf("data should equal to test_data2, got: %v, %v", data, err)
+	}
+}
+
+func TestMemcachedCacheIncr(t *testing.T) {
+	s := newFakeMemcached(t)
+	cache := NewMemcachedCache(s.addr())
+	defer cache.Close()
+
+	if _, err := cache.Incr("counter", 1); err != ErrNotFound {
+		t.Fatalf("error should equal to %q, got: %v", ErrNotFound, err)
+	}
+
+	cache.Set("counter", "10")
+	if n, err := cache.Incr("counter", 5); err != nil || n != 15 {
+		t.Fatalf("counter should be 15, got: %d, %v", n, err)
+	}
+
+	if data, err := cache.Get("counter"); err != nil || data != "15" {
+		t.Fatalf("data should equal to 15, got: %v, %v", data, err)
+	}
+
+	// error responses do not break the connection
+	cache.Set("test_key", "test_data")
+	if _, err := cache.Incr("test_key", 1); err == nil {
+		t.Fatal("non-numeric value should not be incremented")
+	}
+
+	if data, err := cache.Get("counter"); err != nil || data != "15" {
+		t.Fatalf("data should equal to 15, got: %v, %v", data, err)
+	}
+}
+
+func TestMemcachedCacheMalformedKey(t *testing.T) {
+	s := newFakeMemcached(t)
+	cache := NewMemcachedCache(s.addr())
+	defer cache.Close()
+
+	for _, key := range []string{"", "test key", "test\nkey", strings.Repeat("k", 251)} {
+		if err := cache.Set(key, "test_data"); err != ErrMalformedKey {
+			t.Fatalf("error should equal to %q for %q, got: %v", ErrMalformedKey, key, err)
+		}
+	}
+}
+
+func TestMemcachedCachePool(t *testing.T) {
+	s := newFakeMemcached(t)
+	cache := NewMemcachedCache(s.addr(), WithPoolSize(2))
+	defer cache.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			key := "test_key" + strconv.Itoa(i)
+			if err := cache.Set(key, i); err != nil {
+				t.Error(err)
+			}
+
+			if data, err := cache.Get(key); err != nil || data != float64(i) {
+				t.Errorf("data should equal to %d, got: %v, %v", i, data, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	s.Lock()
+	maxConns := s.maxConns
+	s.Unlock()
+
+	if maxConns > 2 {
+		t.Fatalf("open connections should not exceed the pool size, got: %d", maxConns)
+	}
+
+	cache.Close()
+	if _, err := cache.Get("test_key0"); err != ErrPoolClosed {
+		t.Fatalf("error should equal to %q, got: %v", ErrPoolClosed, err)
+	}
+}
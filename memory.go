@@ -44,3 +44,11 @@ func (r *Memory) Delete(key string) error {
 
 	return r.cache.Delete(key)
 }
+
+// Keys returns the keys of the items in the cache, it implements KeyLister
+func (r *Memory) Keys() []string {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.cache.(KeyLister).Keys()
+}
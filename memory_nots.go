@@ -37,6 +37,21 @@ func (r *MemoryNoTS) Set(key string, value interface{}) error {
 	return nil
 }
 
+// Len returns the number of items in the cache
+func (r *MemoryNoTS) Len() int {
+	return len(r.items)
+}
+
+// Keys returns the keys of the items in the cache, it implements KeyLister
+func (r *MemoryNoTS) Keys() []string {
+	keys := make([]string, 0, len(r.items))
+	for key := range r.items {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
 // Delete deletes a given key, it doesnt return error if the item is not in the
 // system
 func (r *MemoryNoTS) Delete(key string) error {
@@ -16,3 +16,15 @@ func TestMemoryCacheNoTSNilValue(t *testing.T) {
 	cache := NewMemoryNoTS()
 	testCacheNilValue(t, cache)
 }
+
+func TestMemoryCacheNoTSKeys(t *testing.T) {
+	cache := NewMemoryNoTS()
+	cache.Set("test_key1", "test_data1")
+	cache.Set("test_key2", "test_data2")
+	cache.Delete("test_key1")
+
+	keys := cache.Keys()
+	if len(keys) != 1 || keys[0] != "test_key2" {
+		t.Fatalf("only test_key2 should be listed, got %v", keys)
+	}
+}
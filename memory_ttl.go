@@ -1,8 +1,11 @@
 package cache
 
 import (
+	"container/heap"
 	"sync"
 	"time"
+
+	"github.com/koding/cache/clock"
 )
 
 var zeroTTL = time.Duration(0)
@@ -15,59 +18,167 @@ type MemoryTTL struct {
 	sync.RWMutex
 
 	// cache holds the cache data
-	cache *MemoryNoTS
+	cache Cache
+
+	// sharedGet is set when the underlying cache does not update its state
+	// on Get, so Get only takes the read lock
+	sharedGet bool
+
+	// setAts holds the expiry items of the keys, indexed by key
+	setAts map[string]*setAt
 
-	// setAts holds the time that related item's set at
-	setAts map[string]time.Time
+	// expiry is a min-heap of the keys ordered by their expiration times, so
+	// expired keys can be found without iterating over all of them
+	expiry expiryHeap
 
 	// ttl is a duration for a cache key to expire
 	ttl time.Duration
 
+	// notFounds holds the expiration times of the keys which are cached as
+	// not found, indexed by key
+	notFounds map[string]time.Time
+
+	// maxNotFounds is the maximum size of notFounds
+	maxNotFounds int
+
+	// beta is the weight of the recompute cost for early expiration
+	beta float64
+
+	// jitter is the maximum fraction of the ttl which is randomly cut from
+	// the ttl of every key
+	jitter float64
+
+	// clock is used for expiration of keys and gc intervals
+	clock clock.Clock
+
 	// gcTicker controls gc intervals
-	gcTicker *time.Ticker
+	gcTicker clock.Ticker
 
 	// done controls sweeping goroutine lifetime
 	done chan struct{}
 }
 
+// setAt is an item of the expiry heap
+type setAt struct {
+	key      string
+	expireAt time.Time
+
+	// cost is the duration it took to compute the value
+	cost time.Duration
+
+	// index is the position of the item in the heap
+	index int
+}
+
+// expiryHeap orders the keys by their expiration times, it implements
+// heap.Interface
+type expiryHeap []*setAt
+
+func (h expiryHeap) Len() int { return len(h) }
+
+func (h expiryHeap) Less(i, j int) bool { return h[i].expireAt.Before(h[j].expireAt) }
+
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expiryHeap) Push(x interface{}) {
+	at := x.(*setAt)
+	at.index = len(*h)
+	*h = append(*h, at)
+}
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	at := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return at
+}
+
 // NewMemoryWithTTL creates an inmemory cache system
 // Which everytime will return the true values about a cache hit
 // and never will leak memory
 // ttl is used for expiration of a key from cache
-func NewMemoryWithTTL(ttl time.Duration) *MemoryTTL {
-	return &MemoryTTL{
-		cache:  NewMemoryNoTS(),
-		setAts: map[string]time.Time{},
-		ttl:    ttl,
+func NewMemoryWithTTL(ttl time.Duration, opts ...TTLOption) *MemoryTTL {
+	return NewCacheWithTTL(ttl, NewMemNoTSCache, opts...)
+}
+
+// NewCacheWithTTL creates a cache system with TTL based on specified Cache
+// constructor. The constructed cache must not be thread safe, locking is
+// handled by MemoryTTL. Expired keys are removed before a new key is set, so
+// a fixed size cache will evict expired keys before its own eviction policy
+// is applied
+// ttl is used for expiration of a key from cache
+func NewCacheWithTTL(ttl time.Duration, f func() Cache, opts ...TTLOption) *MemoryTTL {
+	o := newTTLOptions(opts)
+
+	c := f()
+	_, sharedGet := c.(*MemoryNoTS)
+
+	r := &MemoryTTL{
+		cache:     c,
+		sharedGet: sharedGet,
+		setAts:    map[string]*setAt{},
+		ttl:       ttl,
+		notFounds: map[string]time.Time{},
+		clock:     o.clock,
+		beta:      o.beta,
+		jitter:    o.jitter,
+
+		maxNotFounds: o.maxNotFounds,
 	}
+
+	// keys evicted by the underlying cache are dropped from the expiry heap,
+	// so it is bounded by the size of the cache
+	if n, ok := c.(EvictionNotifier); ok {
+		n.OnEvict(r.evicted)
+	}
+
+	return r
+}
+
+// NewLRUWithTTL creates a thread-safe, fixed size LRU cache whose keys expire
+// after the given ttl
+func NewLRUWithTTL(size int, ttl time.Duration, opts ...TTLOption) *MemoryTTL {
+	return NewCacheWithTTL(ttl, func() Cache { return NewLRUNoTS(size) }, opts...)
+}
+
+// NewLFUWithTTL creates a thread-safe, fixed size LFU cache whose keys expire
+// after the given ttl
+func NewLFUWithTTL(size int, ttl time.Duration, opts ...TTLOption) *MemoryTTL {
+	return NewCacheWithTTL(ttl, func() Cache { return NewLFUNoTS(size) }, opts...)
 }
 
-// StartGC starts the garbage collection process in a go routine
+// StartGC starts the garbage collection process in a go routine, calling
+// StartGC again restarts the process with the new interval
 func (r *MemoryTTL) StartGC(gcInterval time.Duration) {
 	if gcInterval <= 0 {
 		return
 	}
 
-	ticker := time.NewTicker(gcInterval)
+	r.Lock()
+	defer r.Unlock()
+
+	r.stopGC()
+
+	ticker := r.clock.NewTicker(gcInterval)
 	done := make(chan struct{})
 
-	r.Lock()
 	r.gcTicker = ticker
 	r.done = done
-	r.Unlock()
 
 	go func() {
 		for {
 			select {
-			case <-ticker.C:
-				now := time.Now()
+			case <-ticker.C():
+				now := r.clock.Now()
 
 				r.Lock()
-				for key := range r.cache.items {
-					if !r.isValidTime(key, now) {
-						r.delete(key)
-					}
-				}
+				r.deleteExpired(now)
+				r.deleteExpiredNotFounds(now)
 				r.Unlock()
 			case <-done:
 				return
@@ -76,38 +187,67 @@ func (r *MemoryTTL) StartGC(gcInterval time.Duration) {
 	}()
 }
 
-// StopGC stops sweeping goroutine.
+// StopGC stops sweeping goroutine, it is safe to call StopGC multiple times
 func (r *MemoryTTL) StopGC() {
-	if r.gcTicker != nil {
-		r.Lock()
-		r.gcTicker.Stop()
-		r.gcTicker = nil
-		close(r.done)
-		r.done = nil
-		r.Unlock()
+	r.Lock()
+	defer r.Unlock()
+
+	r.stopGC()
+}
+
+// Close stops sweeping goroutine, it implements io.Closer
+func (r *MemoryTTL) Close() error {
+	r.StopGC()
+	return nil
+}
+
+func (r *MemoryTTL) stopGC() {
+	if r.gcTicker == nil {
+		return
 	}
+
+	r.gcTicker.Stop()
+	r.gcTicker = nil
+	close(r.done)
+	r.done = nil
 }
 
 // Get returns a value of a given key if it exists
 // and valid for the time being
 func (r *MemoryTTL) Get(key string) (interface{}, error) {
-	r.RLock()
-
-	for !r.isValid(key) {
+	if r.sharedGet {
+		r.RLock()
+		value, ok, err := r.getShared(key)
 		r.RUnlock()
-		// Need write lock to delete key, so need to unlock, relock and recheck
-		r.Lock()
-		if !r.isValid(key) {
-			r.delete(key)
-			r.Unlock()
-			return nil, ErrNotFound
+
+		if ok {
+			return value, err
 		}
-		r.Unlock()
-		// Could become invalid again in this window
-		r.RLock()
 	}
 
-	defer r.RUnlock()
+	// underlying cache may update its state on Get (LRU, LFU), or the
+	// expired key needs to be deleted, so we need the write lock here
+	r.Lock()
+	defer r.Unlock()
+
+	if expireAt, ok := r.notFounds[key]; ok {
+		if expireAt.After(r.clock.Now()) {
+			return nil, ErrCachedNotFound
+		}
+
+		delete(r.notFounds, key)
+	}
+
+	if !r.isValid(key) {
+		r.delete(key)
+		return nil, ErrNotFound
+	}
+
+	if at, ok := r.setAts[key]; ok {
+		if expiresEarly(r.clock.Now(), at.expireAt, at.cost, r.beta) {
+			return nil, ErrNotFound
+		}
+	}
 
 	value, err := r.cache.Get(key)
 	if err != nil {
@@ -117,17 +257,196 @@ func (r *MemoryTTL) Get(key string) (interface{}, error) {
 	return value, nil
 }
 
+// getShared gets the key under the read lock, ok is false if the key or its
+// not found mark is expired, then Get deletes it under the write lock
+func (r *MemoryTTL) getShared(key string) (value interface{}, ok bool, err error) {
+	now := r.clock.Now()
+
+	if expireAt, found := r.notFounds[key]; found {
+		if !expireAt.After(now) {
+			return nil, false, nil
+		}
+
+		return nil, true, ErrCachedNotFound
+	}
+
+	if !r.isValidTime(key, now) {
+		return nil, false, nil
+	}
+
+	if at, found := r.setAts[key]; found {
+		if expiresEarly(now, at.expireAt, at.cost, r.beta) {
+			return nil, true, ErrNotFound
+		}
+	}
+
+	value, err = r.cache.Get(key)
+	return value, true, err
+}
+
 // Set will persist a value to the cache or
 // override existing one with the new one
 func (r *MemoryTTL) Set(key string, value interface{}) error {
+	return r.SetWithCost(key, value, 0)
+}
+
+// SetWithCost will persist a value to the cache with the duration it took to
+// compute it, which is used for expiring the key early when WithEarlyRefresh
+// is set. It implements CostSetter
+func (r *MemoryTTL) SetWithCost(key string, value interface{}, cost time.Duration) error {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.set(key, value, jitterTTL(r.ttl, r.jitter), cost)
+}
+
+// SetEx will persist a value to the cache or override existing one with the
+// new one with ttl duration instead of the ttl of the cache, zero duration
+// never expires. It implements ExpiringCache
+func (r *MemoryTTL) SetEx(key string, duration time.Duration, value interface{}) error {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.set(key, value, duration, 0)
+}
+
+// TTL returns the remaining time to live of a given key, zero if the key does
+// not expire. It implements TTLReader
+func (r *MemoryTTL) TTL(key string) (time.Duration, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if !r.isValid(key) {
+		r.delete(key)
+		return 0, ErrNotFound
+	}
+
+	if _, err := r.cache.Get(key); err != nil {
+		return 0, err
+	}
+
+	at, ok := r.setAts[key]
+	if !ok {
+		return 0, nil
+	}
+
+	return at.expireAt.Sub(r.clock.Now()), nil
+}
+
+// Incr adds delta to the integer value of a given key and returns the new
+// value, the ttl of the key is kept. Missing keys are set to delta with the
+// ttl of the cache. It implements Counter
+func (r *MemoryTTL) Incr(key string, delta int64) (int64, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.deleteExpired(r.clock.Now())
+
+	value, err := r.cache.Get(key)
+	if err == ErrNotFound {
+		return delta, r.set(key, delta, jitterTTL(r.ttl, r.jitter), 0)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := incrValue(value, delta)
+	if err != nil {
+		return 0, err
+	}
+
+	return n, r.cache.Set(key, n)
+}
+
+// Expire sets the ttl of a given key without setting its value again, zero
+// ttl never expires. It returns ErrNotFound if the key does not exist. It
+// implements Expirer
+func (r *MemoryTTL) Expire(key string, ttl time.Duration) error {
 	r.Lock()
 	defer r.Unlock()
 
-	r.cache.Set(key, value)
-	r.setAts[key] = time.Now()
+	now := r.clock.Now()
+	r.deleteExpired(now)
+
+	if _, err := r.cache.Get(key); err != nil {
+		return err
+	}
+
+	var cost time.Duration
+	if at, ok := r.setAts[key]; ok {
+		cost = at.cost
+	}
+
+	r.setExpiry(key, now, ttl, cost)
 	return nil
 }
 
+// Keys returns the keys which are not expired, it implements KeyLister. It
+// returns nil if the underlying cache can not list its keys
+func (r *MemoryTTL) Keys() []string {
+	r.Lock()
+	defer r.Unlock()
+
+	lister, ok := r.cache.(KeyLister)
+	if !ok {
+		return nil
+	}
+
+	r.deleteExpired(r.clock.Now())
+	return lister.Keys()
+}
+
+// canListKeys reports whether the underlying cache can list its keys, the
+// servers of the cache check it before relying on Keys
+func (r *MemoryTTL) canListKeys() bool {
+	_, ok := r.cache.(KeyLister)
+	return ok
+}
+
+// set persists the value with the given ttl, zero ttl never expires. It must
+// be called with the lock held
+func (r *MemoryTTL) set(key string, value interface{}, ttl, cost time.Duration) error {
+	now := r.clock.Now()
+
+	// drop the expired keys first, so they are not counted against the size
+	// of the underlying cache
+	r.deleteExpired(now)
+	delete(r.notFounds, key)
+
+	if err := r.cache.Set(key, value); err != nil {
+		return err
+	}
+
+	r.setExpiry(key, now, ttl, cost)
+	return nil
+}
+
+// setExpiry sets the expiration of the key to ttl after now, zero ttl never
+// expires. It must be called with the lock held
+func (r *MemoryTTL) setExpiry(key string, now time.Time, ttl, cost time.Duration) {
+	if ttl == zeroTTL {
+		r.dropExpiry(key)
+		return
+	}
+
+	if at, ok := r.setAts[key]; ok {
+		at.expireAt = now.Add(ttl)
+		at.cost = cost
+		heap.Fix(&r.expiry, at.index)
+		return
+	}
+
+	at := &setAt{
+		key:      key,
+		expireAt: now.Add(ttl),
+		cost:     cost,
+	}
+
+	heap.Push(&r.expiry, at)
+	r.setAts[key] = at
+}
+
 // Delete deletes a given key if exists
 func (r *MemoryTTL) Delete(key string) error {
 	r.Lock()
@@ -137,24 +456,75 @@ func (r *MemoryTTL) Delete(key string) error {
 	return nil
 }
 
+// SetNotFound marks the key as not found for the given duration, any value of
+// the key is deleted. It implements NegativeCache
+func (r *MemoryTTL) SetNotFound(key string, ttl time.Duration) error {
+	r.Lock()
+	defer r.Unlock()
+
+	r.delete(key)
+
+	// an arbitrary mark is dropped for keeping notFounds bounded, the key is
+	// looked up from the source of truth again
+	if len(r.notFounds) >= r.maxNotFounds {
+		for k := range r.notFounds {
+			delete(r.notFounds, k)
+			break
+		}
+	}
+
+	r.notFounds[key] = r.clock.Now().Add(ttl)
+	return nil
+}
+
 func (r *MemoryTTL) delete(key string) {
 	r.cache.Delete(key)
-	delete(r.setAts, key)
+	delete(r.notFounds, key)
+	r.dropExpiry(key)
+}
+
+// evicted drops the expiry of the key evicted by the underlying cache, it is
+// called by the underlying cache with the lock held
+func (r *MemoryTTL) evicted(key string) {
+	r.dropExpiry(key)
+}
+
+// dropExpiry removes the key from the expiry heap
+func (r *MemoryTTL) dropExpiry(key string) {
+	if at, ok := r.setAts[key]; ok {
+		heap.Remove(&r.expiry, at.index)
+		delete(r.setAts, key)
+	}
+}
+
+// deleteExpired deletes all the keys which are expired at the given time
+func (r *MemoryTTL) deleteExpired(t time.Time) {
+	for len(r.expiry) > 0 && !r.expiry[0].expireAt.After(t) {
+		r.delete(r.expiry[0].key)
+	}
+}
+
+// deleteExpiredNotFounds deletes the not found marks which are expired at the
+// given time
+func (r *MemoryTTL) deleteExpiredNotFounds(t time.Time) {
+	for key, expireAt := range r.notFounds {
+		if !expireAt.After(t) {
+			delete(r.notFounds, key)
+		}
+	}
 }
 
 func (r *MemoryTTL) isValid(key string) bool {
-	return r.isValidTime(key, time.Now())
+	return r.isValidTime(key, r.clock.Now())
 }
 
+// isValidTime reports whether the key is not expired at the given time, keys
+// without an expiration time never expire
 func (r *MemoryTTL) isValidTime(key string, t time.Time) bool {
-	setAt, ok := r.setAts[key]
+	at, ok := r.setAts[key]
 	if !ok {
-		return false
-	}
-
-	if r.ttl == zeroTTL {
 		return true
 	}
 
-	return setAt.Add(r.ttl).After(t)
+	return at.expireAt.After(t)
 }
@@ -1,10 +1,15 @@
 package cache
 
 import (
+	"io"
 	"testing"
 	"time"
+
+	"github.com/koding/cache/clock/clocktest"
 )
 
+var _ io.Closer = (*MemoryTTL)(nil)
+
 func TestMemoryCacheGetSet(t *testing.T) {
 	cache := NewMemoryWithTTL(2 * time.Second)
 	cache.StartGC(time.Millisecond * 10)
@@ -20,35 +25,77 @@ func TestMemoryCacheGetSet(t *testing.T) {
 }
 
 func TestMemoryCacheTTL(t *testing.T) {
-	cache := NewMemoryWithTTL(100 * time.Millisecond)
-	cache.StartGC(time.Millisecond * 10)
+	clock := clocktest.NewFake(time.Now())
+	cache := NewMemoryWithTTL(100*time.Millisecond, WithClock(clock))
 	cache.Set("test_key", "test_data")
-	time.Sleep(200 * time.Millisecond)
+	clock.Advance(200 * time.Millisecond)
 	_, err := cache.Get("test_key")
 	if err == nil {
 		t.Fatal("data found")
 	}
 }
 
+func TestMemoryCacheTTLGC(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	cache := NewMemoryWithTTL(100*time.Millisecond, WithClock(clock))
+	cache.StartGC(time.Millisecond * 10)
+	defer cache.StopGC()
+	cache.Set("test_key", "test_data")
+
+	// second tick can only be received after the first sweep is done
+	clock.Advance(200 * time.Millisecond)
+	clock.Advance(10 * time.Millisecond)
+
+	cache.Lock()
+	_, ok := cache.setAts["test_key"]
+	cache.Unlock()
+	if ok {
+		t.Fatal("test_key should be swept")
+	}
+}
+
+func TestMemoryCacheTTLRestartGC(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	cache := NewMemoryWithTTL(100*time.Millisecond, WithClock(clock))
+	cache.StartGC(time.Millisecond * 10)
+	cache.StartGC(time.Millisecond * 20)
+	cache.Set("test_key", "test_data")
+
+	clock.Advance(200 * time.Millisecond)
+	clock.Advance(20 * time.Millisecond)
+
+	cache.Lock()
+	_, ok := cache.setAts["test_key"]
+	cache.Unlock()
+	if ok {
+		t.Fatal("test_key should be swept")
+	}
+
+	cache.StopGC()
+	cache.StopGC()
+	if err := cache.Close(); err != nil {
+		t.Fatal("close should not give error")
+	}
+}
+
 func TestMemoryCacheTTLGetExpired(t *testing.T) {
 	// Needs go test -race to catch problems
-	cache := NewMemoryWithTTL(1 * time.Millisecond)
+	clock := clocktest.NewFake(time.Now())
+	cache := NewMemoryWithTTL(1*time.Millisecond, WithClock(clock))
 	cache.Set("test_key", "test_data")
 	sig := make(chan struct{})
 	go func() {
 		for {
 			_, _ = cache.Get("test_key")
 			select {
-			case _, ok := <-sig:
-				if !ok {
-					break
-				}
+			case <-sig:
+				return
 			default:
 			}
 		}
 
 	}()
-	time.Sleep(20 * time.Millisecond)
+	clock.Advance(20 * time.Millisecond)
 	_, err := cache.Get("test_key")
 	if err == nil {
 		t.Fatal("data found")
@@ -59,6 +106,7 @@ func TestMemoryCacheTTLGetExpired(t *testing.T) {
 func TestMemoryCacheTTLNilValue(t *testing.T) {
 	cache := NewMemoryWithTTL(100 * time.Millisecond)
 	cache.StartGC(time.Millisecond * 10)
+	defer cache.StopGC()
 	cache.Set("test_key", nil)
 	data, err := cache.Get("test_key")
 	if err != nil {
@@ -68,3 +116,399 @@ func TestMemoryCacheTTLNilValue(t *testing.T) {
 		t.Fatal("data is not null")
 	}
 }
+
+func TestMemoryCacheTTLZero(t *testing.T) {
+	cache := NewMemoryWithTTL(0)
+	testCacheGetSet(t, cache)
+	testCacheDelete(t, cache)
+}
+
+func TestLRUWithTTLEviction(t *testing.T) {
+	cache := NewLRUWithTTL(2, 2*time.Second)
+	testCacheGetSet(t, cache)
+
+	err := cache.Set("test_key3", "test_data3")
+	if err != nil {
+		t.Fatal("should not give err while setting item")
+	}
+
+	_, err = cache.Get("test_key")
+	if err == nil {
+		t.Fatal("test_key should not be in the cache")
+	}
+}
+
+func TestLRUWithTTLExpiredFirst(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	cache := NewLRUWithTTL(2, 200*time.Millisecond, WithClock(clock))
+	cache.Set("test_key", "test_data")
+	clock.Advance(120 * time.Millisecond)
+	cache.Set("test_key2", "test_data2")
+
+	// make test_key2 the least recently used item
+	if _, err := cache.Get("test_key"); err != nil {
+		t.Fatal("test_key should be in the cache")
+	}
+
+	clock.Advance(120 * time.Millisecond)
+
+	// test_key is expired, so it should be dropped instead of the least
+	// recently used test_key2
+	if err := cache.Set("test_key3", "test_data3"); err != nil {
+		t.Fatal("should not give err while setting item")
+	}
+
+	if _, err := cache.Get("test_key"); err != ErrNotFound {
+		t.Fatal("test_key should not be in the cache")
+	}
+
+	for _, key := range []string{"test_key2", "test_key3"} {
+		if _, err := cache.Get(key); err != nil {
+			t.Fatalf("%s should be in the cache", key)
+		}
+	}
+}
+
+func TestLFUWithTTL(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	cache := NewLFUWithTTL(2, 100*time.Millisecond, WithClock(clock))
+	testCacheGetSet(t, cache)
+	clock.Advance(200 * time.Millisecond)
+
+	_, err := cache.Get("test_key")
+	if err == nil {
+		t.Fatal("data found")
+	}
+}
+
+func TestMemoryCacheTTLSetNotFound(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	cache := NewMemoryWithTTL(time.Hour, WithClock(clock))
+	cache.Set("test_key", "test_data")
+
+	if err := cache.SetNotFound("test_key", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cache.Get("test_key"); err != ErrCachedNotFound {
+		t.Fatalf("error should equal to %q, got: %v", ErrCachedNotFound, err)
+	}
+
+	clock.Advance(2 * time.Minute)
+	if _, err := cache.Get("test_key"); err != ErrNotFound {
+		t.Fatalf("error should equal to %q, got: %v", ErrNotFound, err)
+	}
+
+	// setting a value clears the not found mark
+	cache.SetNotFound("test_key", time.Minute)
+	cache.Set("test_key", "test_data")
+	if data, err := cache.Get("test_key"); err != nil || data != "test_data" {
+		t.Fatalf("data should equal test_data, got: %v, %v", data, err)
+	}
+}
+
+func TestMemoryCacheTTLEarlyRefresh(t *testing.T) {
+	defer withRand(0.99)()
+
+	clock := clocktest.NewFake(time.Now())
+	cache := NewMemoryWithTTL(time.Minute, WithClock(clock), WithEarlyRefresh(1))
+	cache.SetWithCost("test_key", "test_data", time.Second)
+	cache.Set("test_key2", "test_data2")
+
+	clock.Advance(50 * time.Second)
+	if _, err := cache.Get("test_key"); err != nil {
+		t.Fatalf("key should not expire early yet, got: %v", err)
+	}
+
+	clock.Advance(7 * time.Second)
+	if _, err := cache.Get("test_key"); err != ErrNotFound {
+		t.Fatalf("key should expire early, got: %v", err)
+	}
+
+	// keys without a cost never expire early
+	if _, err := cache.Get("test_key2"); err != nil {
+		t.Fatalf("key without cost should not expire early, got: %v", err)
+	}
+}
+
+func TestMemoryCacheTTLJitter(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	cache := NewMemoryWithTTL(time.Minute, WithClock(clock), WithTTLJitter(0.5))
+
+	restore := withRand(0)
+	cache.Set("test_key1", "test_data1")
+	restore()
+
+	restore = withRand(0.9)
+	cache.Set("test_key2", "test_data2")
+	restore()
+
+	clock.Advance(40 * time.Second)
+
+	// the key set later expires first, it must be deleted even though the
+	// key set before is still valid
+	cache.Set("test_key3", "test_data3")
+	if _, ok := cache.setAts["test_key2"]; ok {
+		t.Fatal("test_key2 should be deleted")
+	}
+
+	if _, err := cache.Get("test_key1"); err != nil {
+		t.Fatalf("test_key1 should not expire yet, got: %v", err)
+	}
+}
+
+func TestMemoryCacheTTLSetEx(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	cache := NewMemoryWithTTL(time.Minute, WithClock(clock))
+
+	cache.SetEx("short", time.Second, "test_data")
+	cache.SetEx("forever", 0, "test_data")
+	cache.Set("default", "test_data")
+
+	if ttl, err := cache.TTL("short"); err != nil || ttl != time.Second {
+		t.Fatalf("ttl of short should be 1s, got %s, %v", ttl, err)
+	}
+
+	if ttl, err := cache.TTL("forever"); err != nil || ttl != 0 {
+		t.Fatalf("ttl of forever should be 0, got %s, %v", ttl, err)
+	}
+
+	clock.Advance(2 * time.Second)
+
+	if _, err := cache.Get("short"); err != ErrNotFound {
+		t.Fatalf("short should be expired, got %v", err)
+	}
+
+	if _, err := cache.TTL("short"); err != ErrNotFound {
+		t.Fatalf("ttl of expired key should give ErrNotFound, got %v", err)
+	}
+
+	clock.Advance(time.Hour)
+
+	if _, err := cache.Get("default"); err != ErrNotFound {
+		t.Fatalf("default should be expired, got %v", err)
+	}
+
+	if _, err := cache.Get("forever"); err != nil {
+		t.Fatalf("forever should not expire, got %v", err)
+	}
+}
+
+func TestMemoryCacheTTLZeroSetEx(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	cache := NewMemoryWithTTL(0, WithClock(clock))
+
+	cache.SetEx("test_key", time.Second, "test_data")
+	clock.Advance(2 * time.Second)
+
+	if _, err := cache.Get("test_key"); err != ErrNotFound {
+		t.Fatalf("test_key should be expired, got %v", err)
+	}
+
+	// Set clears the ttl of the key
+	cache.SetEx("test_key", time.Second, "test_data")
+	cache.Set("test_key", "test_data")
+	clock.Advance(2 * time.Second)
+
+	if _, err := cache.Get("test_key"); err != nil {
+		t.Fatalf("test_key should not expire, got %v", err)
+	}
+}
+
+func TestMemoryCacheTTLIncr(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	cache := NewMemoryWithTTL(time.Minute, WithClock(clock))
+
+	if n, err := cache.Incr("counter", 2); err != nil || n != 2 {
+		t.Fatalf("missing key should be set to delta, got %d, %v", n, err)
+	}
+
+	clock.Advance(30 * time.Second)
+
+	if n, err := cache.Incr("counter", 3); err != nil || n != 5 {
+		t.Fatalf("counter should be 5, got %d, %v", n, err)
+	}
+
+	// incrementing keeps the ttl of the key
+	if ttl, _ := cache.TTL("counter"); ttl != 30*time.Second {
+		t.Fatalf("ttl should be kept, got %s", ttl)
+	}
+
+	cache.Set("text", "41")
+	if n, err := cache.Incr("text", 1); err != nil || n != 42 {
+		t.Fatalf("integer string should be incremented, got %d, %v", n, err)
+	}
+
+	cache.Set("word", "test_data")
+	if _, err := cache.Incr("word", 1); err != ErrNotInteger {
+		t.Fatalf("non integer should give ErrNotInteger, got %v", err)
+	}
+
+	clock.Advance(time.Minute)
+
+	if n, err := cache.Incr("counter", 1); err != nil || n != 1 {
+		t.Fatalf("expired counter should start over, got %d, %v", n, err)
+	}
+}
+
+func TestMemoryCacheTTLExpire(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	cache := NewMemoryWithTTL(0, WithClock(clock))
+
+	cache.Set("test_key", "test_data")
+
+	if err := cache.Expire("test_key", time.Second); err != nil {
+		t.Fatalf("should not give err while expiring item: %s", err)
+	}
+
+	if ttl, _ := cache.TTL("test_key"); ttl != time.Second {
+		t.Fatalf("ttl should be 1s, got %s", ttl)
+	}
+
+	// zero ttl clears the expiration
+	cache.SetEx("forever", time.Second, "test_data")
+	cache.Expire("forever", 0)
+
+	clock.Advance(2 * time.Second)
+
+	if _, err := cache.Get("test_key"); err != ErrNotFound {
+		t.Fatalf("test_key should be expired, got %v", err)
+	}
+
+	if v, err := cache.Get("forever"); err != nil || v != "test_data" {
+		t.Fatalf("forever should not expire, got %v, %v", v, err)
+	}
+
+	if err := cache.Expire("test_key", time.Second); err != ErrNotFound {
+		t.Fatalf("expiring a missing key should give ErrNotFound, got %v", err)
+	}
+}
+
+func TestMemoryCacheTTLKeysNotSupported(t *testing.T) {
+	// embedding hides the KeyLister of the underlying cache
+	cache := NewCacheWithTTL(0, func() Cache { return struct{ Cache }{NewMemoryNoTS()} })
+	cache.Set("test_key", "test_data")
+
+	if _, ok := listKeys(cache); ok {
+		t.Fatal("cache should not list its keys")
+	}
+
+	if _, ok := listKeys(NewMemoryWithTTL(0)); !ok {
+		t.Fatal("MemoryTTL of MemoryNoTS should list its keys")
+	}
+}
+
+func TestMemoryCacheTTLKeys(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	cache := NewLRUWithTTL(10, time.Minute, WithClock(clock))
+
+	cache.Set("test_key1", "test_data1")
+	cache.SetEx("test_key2", time.Second, "test_data2")
+
+	if keys := cache.Keys(); len(keys) != 2 {
+		t.Fatalf("there should be 2 keys, got %v", keys)
+	}
+
+	clock.Advance(2 * time.Second)
+
+	keys := cache.Keys()
+	if len(keys) != 1 || keys[0] != "test_key1" {
+		t.Fatalf("only test_key1 should be listed, got %v", keys)
+	}
+}
+
+func TestLRUWithTTLEvictionDropsExpiry(t *testing.T) {
+	cache := NewLRUWithTTL(2, time.Minute)
+
+	cache.Set("test_key1", "test_data1")
+	cache.Set("test_key2", "test_data2")
+	cache.Set("test_key3", "test_data3")
+
+	if _, ok := cache.setAts["test_key1"]; ok {
+		t.Fatal("expiry of the evicted test_key1 should be dropped")
+	}
+
+	if len(cache.setAts) != 2 || cache.expiry.Len() != 2 {
+		t.Fatalf("expiry should be bounded by the cache size, got %d", len(cache.setAts))
+	}
+}
+
+func TestLFUWithTTLEvictionDropsExpiry(t *testing.T) {
+	cache := NewLFUWithTTL(2, time.Minute)
+
+	cache.Set("test_key1", "test_data1")
+	cache.Set("test_key2", "test_data2")
+	cache.Get("test_key2")
+	cache.Set("test_key3", "test_data3")
+
+	if _, ok := cache.setAts["test_key1"]; ok {
+		t.Fatal("expiry of the evicted test_key1 should be dropped")
+	}
+
+	if len(cache.setAts) != 2 {
+		t.Fatalf("expiry should be bounded by the cache size, got %d", len(cache.setAts))
+	}
+}
+
+func TestMemoryCacheTTLSharedGetExpired(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	cache := NewMemoryWithTTL(time.Second, WithClock(clock))
+
+	if !cache.sharedGet {
+		t.Fatal("Get of MemoryNoTS should take the read lock")
+	}
+
+	cache.Set("test_key", "test_data")
+	clock.Advance(2 * time.Second)
+
+	if _, err := cache.Get("test_key"); err != ErrNotFound {
+		t.Fatalf("test_key should be expired, got %v", err)
+	}
+
+	if _, ok := cache.setAts["test_key"]; ok {
+		t.Fatal("expired test_key should be deleted")
+	}
+}
+
+func TestMemoryCacheTTLMaxNotFounds(t *testing.T) {
+	cache := NewMemoryWithTTL(time.Hour, WithMaxNotFounds(2))
+
+	for _, key := range []string{"test_key1", "test_key2", "test_key3"} {
+		if err := cache.SetNotFound(key, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(cache.notFounds) != 2 {
+		t.Fatalf("not found marks should be bounded by 2, got: %d", len(cache.notFounds))
+	}
+
+	if _, err := cache.Get("test_key3"); err != ErrCachedNotFound {
+		t.Fatalf("error should equal to %q, got: %v", ErrCachedNotFound, err)
+	}
+}
+
+func TestMemoryCacheTTLExpiryOrder(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	cache := NewMemoryWithTTL(0, WithClock(clock))
+
+	// keys are set out of their expiration order, test_key2 is reset with
+	// a shorter ttl
+	for key, ttl := range map[string]time.Duration{"test_key1": 3, "test_key2": 5, "test_key3": 1, "test_key4": 4} {
+		cache.SetEx(key, ttl*time.Second, "test_data")
+	}
+	cache.SetEx("test_key2", 2*time.Second, "test_data")
+
+	for i, key := range []string{"test_key3", "test_key2", "test_key1", "test_key4"} {
+		clock.Advance(time.Second)
+		cache.deleteExpired(clock.Now())
+
+		if _, ok := cache.setAts[key]; ok {
+			t.Fatalf("%s should be expired after %d seconds", key, i+1)
+		}
+
+		if cache.expiry.Len() != 3-i {
+			t.Fatalf("expiry heap should have %d keys, got: %d", 3-i, cache.expiry.Len())
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Middleware wraps a Cache with additional behaviour, like logging or metrics.
+// Wrapped caches of the package's middlewares implement ExpiringCache,
+// CostSetter, NegativeCache, PrefixDeleter, KeyLister and io.Closer by
+// forwarding them to the underlying cache, SetEx fails if the underlying cache
+// does not support ttl and DeletePrefix fails if it can not delete or list its
+// keys
+type Middleware func(Cache) Cache
+
+// Chain wraps the given cache with the middlewares. First middleware is the
+// outermost one, so it is the first to see the calls:
+//
+//	Chain(c, Logging(logger), Recover())
+//
+// is equal to Logging(logger)(Recover()(c))
+func Chain(c Cache, mws ...Middleware) Cache {
+	for i := len(mws) - 1; i >= 0; i-- {
+		c = mws[i](c)
+	}
+
+	return c
+}
+
+// middleware is a helper for implementing the middlewares which handle all
+// operations the same way. call is called with the operation name, key and
+// the function doing the actual call on the underlying cache
+type middleware struct {
+	cache Cache
+	call  func(op, key string, f func() error) error
+}
+
+// Get calls the underlying Get through the middleware
+func (m *middleware) Get(key string) (interface{}, error) {
+	var value interface{}
+	err := m.call("get", key, func() (err error) {
+		value, err = m.cache.Get(key)
+		return err
+	})
+
+	return value, err
+}
+
+// Set calls the underlying Set through the middleware
+func (m *middleware) Set(key string, value interface{}) error {
+	return m.call("set", key, func() error {
+		return m.cache.Set(key, value)
+	})
+}
+
+// Delete calls the underlying Delete through the middleware
+func (m *middleware) Delete(key string) error {
+	return m.call("delete", key, func() error {
+		return m.cache.Delete(key)
+	})
+}
+
+// SetEx calls the underlying SetEx through the middleware, underlying cache
+// must implement ExpiringCache
+func (m *middleware) SetEx(key string, duration time.Duration, value interface{}) error {
+	return m.call("setex", key, func() error {
+		return setEx(m.cache, key, duration, value)
+	})
+}
+
+// SetWithCost calls the underlying SetWithCost through the middleware, Set is
+// called if the underlying cache is not a CostSetter
+func (m *middleware) SetWithCost(key string, value interface{}, cost time.Duration) error {
+	return m.call("set", key, func() error {
+		return setWithCost(m.cache, key, value, cost)
+	})
+}
+
+// SetNotFound calls the underlying SetNotFound through the middleware, the
+// key is deleted if the underlying cache is not a NegativeCache
+func (m *middleware) SetNotFound(key string, ttl time.Duration) error {
+	return m.call("setnotfound", key, func() error {
+		return setNotFound(m.cache, key, ttl)
+	})
+}
+
+// DeletePrefix calls the underlying DeletePrefix through the middleware, the
+// keys of the underlying cache are listed if it is not a PrefixDeleter
+func (m *middleware) DeletePrefix(prefix string) error {
+	return m.call("deleteprefix", prefix, func() error {
+		return deletePrefix(m.cache, prefix)
+	})
+}
+
+// Keys calls the underlying Keys through the middleware, it returns nil if the
+// underlying cache can not list its keys
+func (m *middleware) Keys() []string {
+	var keys []string
+	m.call("keys", "", func() error {
+		if lister, ok := listKeys(m.cache); ok {
+			keys = lister.Keys()
+		}
+
+		return nil
+	})
+
+	return keys
+}
+
+// canListKeys reports whether the underlying cache can list its keys
+func (m *middleware) canListKeys() bool {
+	_, ok := listKeys(m.cache)
+	return ok
+}
+
+// Close closes the underlying cache if it is an io.Closer
+func (m *middleware) Close() error {
+	return closeCache(m.cache)
+}
+
+// setEx calls SetEx of the cache, if it is an ExpiringCache
+func setEx(c Cache, key string, duration time.Duration, value interface{}) error {
+	ec, ok := c.(ExpiringCache)
+	if !ok {
+		return errTTLNotSupported
+	}
+
+	return ec.SetEx(key, duration, value)
+}
+
+// setWithCost calls SetWithCost of the cache if it is a CostSetter, Set
+// otherwise since the cost is only a hint for early expiration
+func setWithCost(c Cache, key string, value interface{}, cost time.Duration) error {
+	if cs, ok := c.(CostSetter); ok {
+		return cs.SetWithCost(key, value, cost)
+	}
+
+	return c.Set(key, value)
+}
+
+// setNotFound calls SetNotFound of the cache if it is a NegativeCache, the key
+// is deleted otherwise, so its stale value is not returned either
+func setNotFound(c Cache, key string, ttl time.Duration) error {
+	if nc, ok := c.(NegativeCache); ok {
+		return nc.SetNotFound(key, ttl)
+	}
+
+	return c.Delete(key)
+}
+
+// closeCache closes the cache if it is an io.Closer
+func closeCache(c Cache) error {
+	if closer, ok := c.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// Logging logs every operation with its duration to the given logger. Failed
+// operations are logged with error level, others with debug level. ErrNotFound
+// is not counted as a failure
+func Logging(logger *slog.Logger) Middleware {
+	return func(c Cache) Cache {
+		return &middleware{
+			cache: c,
+			call: func(op, key string, f func() error) error {
+				start := time.Now()
+				err := f()
+
+				level := slog.LevelDebug
+				if err != nil && err != ErrNotFound {
+					level = slog.LevelError
+				}
+
+				logger.Log(context.Background(), level, "cache "+op,
+					slog.String("key", key),
+					slog.Duration("duration", time.Since(start)),
+					slog.Any("error", err),
+				)
+
+				return err
+			},
+		}
+	}
+}
+
+// Latency records the duration of every operation into the given histogram
+func Latency(h *LatencyHistogram) Middleware {
+	return func(c Cache) Cache {
+		return &middleware{
+			cache: c,
+			call: func(op, key string, f func() error) error {
+				start := time.Now()
+				err := f()
+				h.Observe(op, time.Since(start))
+				return err
+			},
+		}
+	}
+}
+
+// PanicError is returned by the caches wrapped with Recover when the
+// underlying cache panics
+type PanicError struct {
+	// Op is the operation which panicked
+	Op string
+
+	// Key is the key of the operation
+	Key string
+
+	// Value is the recovered value
+	Value interface{}
+}
+
+// Error implements the error interface
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("cache: panic while %s %q: %v", e.Op, e.Key, e.Value)
+}
+
+// Recover recovers the panics of the underlying cache, e.g. while encoding an
+// unsupported value, and returns them as *PanicError
+func Recover() Middleware {
+	return func(c Cache) Cache {
+		return &middleware{
+			cache: c,
+			call: func(op, key string, f func() error) (err error) {
+				defer func() {
+					if v := recover(); v != nil {
+						err = &PanicError{Op: op, Key: key, Value: v}
+					}
+				}()
+
+				return f()
+			},
+		}
+	}
+}
+
+// Namespace prefixes all the keys with the given namespace, so multiple users
+// can share the same cache without key collisions. The returned cache is a
+// *NamespacedCache
+func Namespace(ns string) Middleware {
+	return func(c Cache) Cache {
+		return NewNamespace(c, ns)
+	}
+}
+
+// defaultLatencyBuckets holds the upper bounds of the default histogram
+// buckets
+var defaultLatencyBuckets = []time.Duration{
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+}
+
+// LatencyHistogram counts the operation durations in buckets, indexed by the
+// operation name
+type LatencyHistogram struct {
+	// Mutex is used for handling the concurrent
+	// read/write requests for histogram
+	sync.Mutex
+
+	// buckets holds the upper bounds of the buckets in increasing order
+	buckets []time.Duration
+
+	// counts holds the bucket counts of the operations, last count is for
+	// the durations greater than all bounds
+	counts map[string][]int64
+}
+
+// NewLatencyHistogram creates a histogram with the given bucket upper bounds,
+// default buckets are used if none is given
+func NewLatencyHistogram(buckets ...time.Duration) *LatencyHistogram {
+	if len(buckets) == 0 {
+		buckets = defaultLatencyBuckets
+	}
+
+	b := make([]time.Duration, len(buckets))
+	copy(b, buckets)
+	sort.Slice(b, func(i, j int) bool { return b[i] < b[j] })
+
+	return &LatencyHistogram{
+		buckets: b,
+		counts:  make(map[string][]int64),
+	}
+}
+
+// Observe records the duration of the operation
+func (h *LatencyHistogram) Observe(op string, d time.Duration) {
+	i := sort.Search(len(h.buckets), func(i int) bool { return d <= h.buckets[i] })
+
+	h.Lock()
+	defer h.Unlock()
+
+	counts, ok := h.counts[op]
+	if !ok {
+		counts = make([]int64, len(h.buckets)+1)
+		h.counts[op] = counts
+	}
+
+	counts[i]++
+}
+
+// Buckets returns the upper bounds of the buckets
+func (h *LatencyHistogram) Buckets() []time.Duration {
+	b := make([]time.Duration, len(h.buckets))
+	copy(b, h.buckets)
+	return b
+}
+
+// Counts returns the bucket counts of the given operation, it has one more
+// item than Buckets for the durations greater than all bounds
+func (h *LatencyHistogram) Counts(op string) []int64 {
+	h.Lock()
+	defer h.Unlock()
+
+	counts := make([]int64, len(h.buckets)+1)
+	copy(counts, h.counts[op])
+	return counts
+}
@@ -0,0 +1,211 @@
+package cache
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/koding/cache/clock/clocktest"
+)
+
+func TestChainOrder(t *testing.T) {
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(c Cache) Cache {
+			return &middleware{
+				cache: c,
+				call: func(op, key string, f func() error) error {
+					calls = append(calls, name)
+					return f()
+				},
+			}
+		}
+	}
+
+	cache := Chain(NewMemory(), trace("first"), trace("second"))
+	cache.Set("test_key", "test_data")
+
+	if strings.Join(calls, ",") != "first,second" {
+		t.Fatalf("middlewares should be called in order, got: %v", calls)
+	}
+}
+
+func TestChainGetSet(t *testing.T) {
+	cache := Chain(NewMemory(),
+		Logging(slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))),
+		Latency(NewLatencyHistogram()),
+		Namespace("ns:"),
+		Recover(),
+	)
+
+	testCacheGetSet(t, cache)
+	testCacheDelete(t, cache)
+	testCacheNilValue(t, cache)
+}
+
+func TestChainOptionalInterfaces(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	cache := Chain(NewMemoryWithTTL(time.Hour, WithClock(clock)),
+		Logging(slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))),
+		Latency(NewLatencyHistogram()),
+		Namespace("ns:"),
+		Recover(),
+	)
+
+	if err := cache.(ExpiringCache).SetEx("test_key", time.Second, "test_data"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.(CostSetter).SetWithCost("test_key2", "test_data2", time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.(NegativeCache).SetNotFound("test_key3", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cache.Get("test_key3"); err != ErrCachedNotFound {
+		t.Fatalf("error should be ErrCachedNotFound, got: %v", err)
+	}
+
+	clock.Advance(2 * time.Second)
+
+	if _, err := cache.Get("test_key"); err != ErrNotFound {
+		t.Fatal("key should be expired with its own ttl")
+	}
+
+	if _, err := cache.Get("test_key2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.(io.Closer).Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChainOptionalInterfacesFallback(t *testing.T) {
+	cache := Chain(NewMemory(), Latency(NewLatencyHistogram()), Namespace("ns:"))
+
+	if err := cache.(ExpiringCache).SetEx("test_key", time.Second, "test_data"); err != errTTLNotSupported {
+		t.Fatalf("error should be %q, got: %v", errTTLNotSupported, err)
+	}
+
+	cache.Set("test_key", "test_data")
+	if err := cache.(NegativeCache).SetNotFound("test_key", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cache.Get("test_key"); err != ErrNotFound {
+		t.Fatal("key should be deleted if the cache is not a NegativeCache")
+	}
+
+	if err := cache.(io.Closer).Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChainFlushNamespace(t *testing.T) {
+	memory := NewMemory()
+	logger := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+	cache := Chain(memory, Namespace("ns:"), Logging(logger)).(*NamespacedCache)
+
+	cache.Set("test_key", "test_data")
+	memory.Set("other", "test_data")
+
+	if err := cache.FlushNamespace(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cache.Get("test_key"); err != ErrNotFound {
+		t.Fatal("test_key should be flushed")
+	}
+
+	if _, err := memory.Get("other"); err != nil {
+		t.Fatal("other should not be flushed")
+	}
+
+	// embedding hides the KeyLister of the underlying cache
+	cache = Chain(struct{ Cache }{NewMemory()}, Namespace("ns:"), Logging(logger)).(*NamespacedCache)
+	if err := cache.FlushNamespace(); err != errFlushNotSupported {
+		t.Fatalf("error should be %q, got: %v", errFlushNotSupported, err)
+	}
+
+	if _, ok := listKeys(Logging(logger)(struct{ Cache }{NewMemory()})); ok {
+		t.Fatal("middleware should not list the keys of a cache which can not")
+	}
+}
+
+func TestLogging(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	cache := Chain(NewMemory(), Logging(logger))
+
+	cache.Get("test_key")
+
+	out := buf.String()
+	if !strings.Contains(out, "level=DEBUG") || !strings.Contains(out, `msg="cache get"`) ||
+		!strings.Contains(out, "key=test_key") || !strings.Contains(out, `error="not found"`) {
+		t.Fatalf("get should be logged, got: %q", out)
+	}
+}
+
+func TestLatency(t *testing.T) {
+	h := NewLatencyHistogram(time.Hour)
+	cache := Chain(NewMemory(), Latency(h))
+
+	cache.Set("test_key", "test_data")
+	cache.Get("test_key")
+	cache.Get("test_key")
+
+	if counts := h.Counts("get"); len(counts) != 2 || counts[0] != 2 || counts[1] != 0 {
+		t.Fatalf("get counts should be [2 0], got: %v", counts)
+	}
+
+	if counts := h.Counts("delete"); counts[0] != 0 {
+		t.Fatalf("delete counts should be empty, got: %v", counts)
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	memory := NewMemory()
+	cache := Chain(memory, Namespace("ns:"))
+
+	cache.Set("test_key", "test_data")
+
+	if _, err := memory.Get("ns:test_key"); err != nil {
+		t.Fatal("key should be prefixed with the namespace")
+	}
+
+	if _, err := memory.Get("test_key"); err != ErrNotFound {
+		t.Fatal("key should not be set without the namespace")
+	}
+}
+
+type panickingCache struct {
+	Cache
+}
+
+func (p *panickingCache) Set(key string, value interface{}) error {
+	panic("unsupported value")
+}
+
+func TestRecover(t *testing.T) {
+	cache := Chain(&panickingCache{NewMemory()}, Recover())
+
+	err := cache.Set("test_key", "test_data")
+	perr, ok := err.(*PanicError)
+	if !ok {
+		t.Fatalf("error should be *PanicError, got: %v", err)
+	}
+
+	if perr.Op != "set" || perr.Key != "test_key" || perr.Value != "unsupported value" {
+		t.Fatalf("unexpected panic error: %#v", perr)
+	}
+
+	if _, err := cache.Get("test_key"); err != ErrNotFound {
+		t.Fatal("get should not be affected")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/koding/cache/clock"
 	mgo "gopkg.in/mgo.v2"
 )
 
@@ -34,8 +35,23 @@ type MongoCache struct {
 	// expired keys from mongo with given time interval
 	GCStart bool
 
+	// codec encodes the values before storing them, values are stored as
+	// they are if it is nil
+	codec Codec
+
+	// beta is the weight of the recompute cost for early expiration, keys
+	// are not expired early if it is 0
+	beta float64
+
+	// jitter is the maximum fraction of the TTL which is randomly cut from
+	// the TTL of the keys set with Set
+	jitter float64
+
+	// clock is used for calculating expireAt values and gc intervals
+	clock clock.Clock
+
 	// gcTicker controls gc intervals
-	gcTicker *time.Ticker
+	gcTicker clock.Ticker
 
 	// done controls sweeping goroutine lifetime
 	done chan struct{}
@@ -85,6 +101,7 @@ func NewMongoCacheWithTTL(session *mgo.Session, configs ...Option) *MongoCache {
 		CollectionName: defaultCollectionName,
 		GCInterval:     defaultGCInterval,
 		GCStart:        false,
+		clock:          clock.New(),
 	}
 
 	for _, configFunc := range configs {
@@ -145,7 +162,54 @@ func SetCollectionName(collName string) Option {
 	}
 }
 
-// Get returns a value of a given key if it exists
+// SetClock sets the clock which is used for calculating the expiration times
+// and ticking the garbage collector in MongoCache struct as option
+// usage:
+// NewMongoCacheWithTTL(mongoSession, SetClock(clocktest.NewFake(time.Now())))
+func SetClock(c clock.Clock) Option {
+	return func(m *MongoCache) {
+		m.clock = c
+	}
+}
+
+// SetCodec sets the codec for encoding the values in MongoCache struct as
+// option, values are stored as BSON if no codec is set
+// usage:
+// NewMongoCacheWithTTL(mongoSession, SetCodec(JSONCodec))
+func SetCodec(c Codec) Option {
+	return func(m *MongoCache) {
+		m.codec = c
+	}
+}
+
+// SetEarlyRefresh enables the probabilistic early expiration in MongoCache
+// struct as option. Get reports a miss before a key expires with a
+// probability which rises as the expiry approaches, weighted by the recompute
+// cost of the key set with SetWithCost and beta
+// usage:
+// NewMongoCacheWithTTL(mongoSession, SetEarlyRefresh(1))
+func SetEarlyRefresh(beta float64) Option {
+	return func(m *MongoCache) {
+		m.beta = beta
+	}
+}
+
+// SetTTLJitter sets the maximum fraction of the TTL which is randomly cut
+// from the TTL of the keys set with Set in MongoCache struct as option, so
+// the keys set at the same time do not expire at the same time
+// usage:
+// NewMongoCacheWithTTL(mongoSession, SetTTLJitter(0.1))
+func SetTTLJitter(fraction float64) Option {
+	return func(m *MongoCache) {
+		m.jitter = fraction
+	}
+}
+
+// Get returns a value of a given key if it exists. Encoded values are decoded
+// into their generic forms, e.g. structs are returned as map[string]interface{}
+// by JSONCodec, GetInto should be used for decoding them into their own types.
+// GobCodec only decodes the values of the basic and the registered types,
+// ErrTypedDestination is returned for the others
 func (m *MongoCache) Get(key string) (interface{}, error) {
 	data, err := m.get(key)
 	if err == mgo.ErrNotFound {
@@ -156,19 +220,105 @@ func (m *MongoCache) Get(key string) (interface{}, error) {
 		return nil, err
 	}
 
-	return data.Value, nil
+	if data.NotFound {
+		return nil, ErrCachedNotFound
+	}
+
+	if m.expiresEarly(data.ExpireAt, data.Cost) {
+		return nil, ErrNotFound
+	}
+
+	if data.Codec == "" {
+		return data.Value, nil
+	}
+
+	b, ok := data.Value.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("value of %q is not encoded with %s", key, data.Codec)
+	}
+
+	codec, err := codecByName(data.Codec, m.codec)
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	if err := codec.Unmarshal(b, &value); err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+// GetInto decodes the value of a given key into dst, which must be a pointer.
+// Values are decoded with the codec they are encoded with, values set without
+// a codec are decoded from BSON
+func (m *MongoCache) GetInto(key string, dst interface{}) error {
+	data, err := m.getRaw(key)
+	if err == mgo.ErrNotFound {
+		return ErrNotFound
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if data.NotFound {
+		return ErrCachedNotFound
+	}
+
+	if m.expiresEarly(data.ExpireAt, data.Cost) {
+		return ErrNotFound
+	}
+
+	if data.Codec == "" {
+		return data.Value.Unmarshal(dst)
+	}
+
+	var b []byte
+	if err := data.Value.Unmarshal(&b); err != nil {
+		return err
+	}
+
+	codec, err := codecByName(data.Codec, m.codec)
+	if err != nil {
+		return err
+	}
+
+	return codec.Unmarshal(b, dst)
 }
 
 // Set will persist a value to the cache or override existing one with the new
 // one
 func (m *MongoCache) Set(key string, value interface{}) error {
-	return m.set(key, m.TTL, value)
+	return m.set(key, jitterTTL(m.TTL, m.jitter), 0, value)
+}
+
+// SetWithCost will persist a value to the cache with the duration it took to
+// compute it, which is used for expiring the key early when SetEarlyRefresh
+// is set. It implements CostSetter
+func (m *MongoCache) SetWithCost(key string, value interface{}, cost time.Duration) error {
+	return m.set(key, jitterTTL(m.TTL, m.jitter), cost, value)
 }
 
 // SetEx will persist a value to the cache or override existing one with the new
 // one with ttl duration
 func (m *MongoCache) SetEx(key string, duration time.Duration, value interface{}) error {
-	return m.set(key, duration, value)
+	return m.set(key, duration, 0, value)
+}
+
+// SetNotFound marks the key as not found for the given duration, Get returns
+// ErrCachedNotFound for the key until it expires or is set. It implements
+// NegativeCache
+func (m *MongoCache) SetNotFound(key string, duration time.Duration) error {
+	return m.setNotFound(key, duration)
+}
+
+// WriteBatch persists the values and deletes the keys of the batch with a
+// single bulk operation, values are set with the TTL. It implements Writer, so
+// MongoCache can be the backing store of a WriteBehindCache
+func (m *MongoCache) WriteBatch(batch []Write) error {
+	return m.writeBatch(batch)
 }
 
 // Delete deletes a given key if exists
@@ -176,6 +326,12 @@ func (m *MongoCache) Delete(key string) error {
 	return m.delete(key)
 }
 
+// DeletePrefix deletes all the keys starting with the given prefix, it
+// implements PrefixDeleter
+func (m *MongoCache) DeletePrefix(prefix string) error {
+	return m.deletePrefix(prefix)
+}
+
 // EnsureIndex ensures the index with expireAt key
 func (m *MongoCache) EnsureIndex() error {
 	query := func(c *mgo.Collection) error {
@@ -186,24 +342,28 @@ func (m *MongoCache) EnsureIndex() error {
 }
 
 // StartGC starts the garbage collector with given time interval The
-// expired data will be checked & deleted with given interval time
+// expired data will be checked & deleted with given interval time, calling
+// StartGC again restarts the garbage collector with the new interval
 func (m *MongoCache) StartGC(gcInterval time.Duration) {
 	if gcInterval <= 0 {
 		return
 	}
 
-	ticker := time.NewTicker(gcInterval)
+	m.Lock()
+	defer m.Unlock()
+
+	m.stopGC()
+
+	ticker := m.clock.NewTicker(gcInterval)
 	done := make(chan struct{})
 
-	m.Lock()
 	m.gcTicker = ticker
 	m.done = done
-	m.Unlock()
 
 	go func() {
 		for {
 			select {
-			case <-ticker.C:
+			case <-ticker.C():
 				m.Lock()
 				m.deleteExpiredKeys()
 				m.Unlock()
@@ -214,14 +374,28 @@ func (m *MongoCache) StartGC(gcInterval time.Duration) {
 	}()
 }
 
-// StopGC stops sweeping goroutine.
+// StopGC stops sweeping goroutine, it is safe to call StopGC multiple times
 func (m *MongoCache) StopGC() {
-	if m.gcTicker != nil {
-		m.Lock()
-		m.gcTicker.Stop()
-		m.gcTicker = nil
-		close(m.done)
-		m.done = nil
-		m.Unlock()
+	m.Lock()
+	defer m.Unlock()
+
+	m.stopGC()
+}
+
+// Close stops sweeping goroutine, it implements io.Closer. Session is not
+// closed, the responsibility of closing the session belongs to the user
+func (m *MongoCache) Close() error {
+	m.StopGC()
+	return nil
+}
+
+func (m *MongoCache) stopGC() {
+	if m.gcTicker == nil {
+		return
 	}
+
+	m.gcTicker.Stop()
+	m.gcTicker = nil
+	close(m.done)
+	m.done = nil
 }
@@ -1,10 +1,12 @@
 package cache
 
 import (
+	"io"
 	"os"
 	"testing"
 	"time"
 
+	"github.com/koding/cache/clock/clocktest"
 	mgo "gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
@@ -14,6 +16,8 @@ var (
 	session = initMongo()
 )
 
+var _ io.Closer = (*MongoCache)(nil)
+
 func TestMongoCacheSetOptionFuncs(t *testing.T) {
 	mgoCache := NewMongoCacheWithTTL(session)
 	defer mgoCache.StopGC()
@@ -150,8 +154,9 @@ func TestMongoCacheTTL(t *testing.T) {
 	// duration specifies the time duration to hold the data in mongo
 	// after the duration interval, data will be deleted from mongoDB
 	duration := time.Millisecond * 100
+	clock := clocktest.NewFake(time.Now())
 
-	mgoCache := NewMongoCacheWithTTL(session, SetTTL(duration))
+	mgoCache := NewMongoCacheWithTTL(session, SetTTL(duration), SetClock(clock))
 	defer mgoCache.StopGC()
 	if mgoCache == nil {
 		t.Fatal("config should not be nil")
@@ -170,7 +175,7 @@ func TestMongoCacheTTL(t *testing.T) {
 		t.Fatalf("data should equal: %v, but got: %v", value, data)
 	}
 
-	time.Sleep(duration)
+	clock.Advance(duration)
 
 	if _, err := mgoCache.Get(key); err != ErrNotFound {
 		t.Fatalf("error should equal to %q but got: %q", ErrNotFound, err)
@@ -259,3 +264,164 @@ func initMongo() *mgo.Session {
 
 	return ses
 }
+
+func TestMongoCacheDeletePrefix(t *testing.T) {
+	mgoCache := NewMongoCacheWithTTL(session)
+	defer mgoCache.StopGC()
+
+	prefix := bson.NewObjectId().Hex() + ".*:"
+	ns := NewNamespace(mgoCache, prefix)
+	key, value := bson.NewObjectId().Hex(), bson.NewObjectId().Hex()
+
+	if err := ns.Set(key, value); err != nil {
+		t.Fatalf("error should be nil: %q", err)
+	}
+
+	// prefix should be matched literally, not as a regex
+	if err := mgoCache.Set(prefix[:24]+"x:"+key, value); err != nil {
+		t.Fatalf("error should be nil: %q", err)
+	}
+
+	if err := ns.FlushNamespace(); err != nil {
+		t.Fatalf("error should be nil: %q", err)
+	}
+
+	if _, err := ns.Get(key); err != ErrNotFound {
+		t.Fatalf("error should equal to %q but got: %q", ErrNotFound, err)
+	}
+
+	if _, err := mgoCache.Get(prefix[:24] + "x:" + key); err != nil {
+		t.Fatalf("error should be nil: %q", err)
+	}
+}
+
+func TestMongoCacheCodec(t *testing.T) {
+	type user struct {
+		Name string
+		Age  int
+	}
+
+	value := user{Name: "test_user", Age: 42}
+
+	for _, codec := range []Codec{JSONCodec, GobCodec, MsgpackCodec} {
+		mgoCache := NewMongoCacheWithTTL(session, SetCodec(codec))
+		key := bson.NewObjectId().Hex()
+
+		if err := mgoCache.Set(key, value); err != nil {
+			t.Fatalf("%s: error should be nil: %q", codec.Name(), err)
+		}
+
+		var u user
+		if err := mgoCache.GetInto(key, &u); err != nil {
+			t.Fatalf("%s: error should be nil: %q", codec.Name(), err)
+		}
+
+		if u != value {
+			t.Fatalf("%s: data should equal: %v, but got: %v", codec.Name(), value, u)
+		}
+
+		// values are decoded with their type tag, not the configured codec
+		var u2 user
+		if err := NewMongoCacheWithTTL(session).GetInto(key, &u2); err != nil || u2 != value {
+			t.Fatalf("%s: data should equal: %v, but got: %v, %v", codec.Name(), value, u2, err)
+		}
+	}
+}
+
+func TestMongoCacheGetIntoBSON(t *testing.T) {
+	mgoCache := NewMongoCacheWithTTL(session)
+	key := bson.NewObjectId().Hex()
+
+	if err := mgoCache.Set(key, "test_data"); err != nil {
+		t.Fatalf("error should be nil: %q", err)
+	}
+
+	var data string
+	if err := mgoCache.GetInto(key, &data); err != nil {
+		t.Fatalf("error should be nil: %q", err)
+	}
+
+	if data != "test_data" {
+		t.Fatalf("data should equal: test_data, but got: %v", data)
+	}
+
+	if err := mgoCache.GetInto(bson.NewObjectId().Hex(), &data); err != ErrNotFound {
+		t.Fatalf("error should equal to %q but got: %q", ErrNotFound, err)
+	}
+}
+
+func TestMongoCacheSetNotFound(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	mgoCache := NewMongoCacheWithTTL(session, SetClock(clock))
+	key := bson.NewObjectId().Hex()
+
+	if err := mgoCache.SetNotFound(key, time.Minute); err != nil {
+		t.Fatalf("error should be nil: %q", err)
+	}
+
+	if _, err := mgoCache.Get(key); err != ErrCachedNotFound {
+		t.Fatalf("error should equal to %q but got: %q", ErrCachedNotFound, err)
+	}
+
+	var data string
+	if err := mgoCache.GetInto(key, &data); err != ErrCachedNotFound {
+		t.Fatalf("error should equal to %q but got: %q", ErrCachedNotFound, err)
+	}
+
+	clock.Advance(2 * time.Minute)
+	if _, err := mgoCache.Get(key); err != ErrNotFound {
+		t.Fatalf("error should equal to %q but got: %q", ErrNotFound, err)
+	}
+}
+
+func TestMongoCacheEarlyRefresh(t *testing.T) {
+	defer withRand(0.99)()
+
+	clock := clocktest.NewFake(time.Now())
+	mgoCache := NewMongoCacheWithTTL(session, SetClock(clock), SetTTL(time.Minute), SetEarlyRefresh(1))
+	key := bson.NewObjectId().Hex()
+
+	if err := mgoCache.SetWithCost(key, "test_data", time.Second); err != nil {
+		t.Fatalf("error should be nil: %q", err)
+	}
+
+	clock.Advance(50 * time.Second)
+	if _, err := mgoCache.Get(key); err != nil {
+		t.Fatalf("error should be nil: %q", err)
+	}
+
+	clock.Advance(7 * time.Second)
+	if _, err := mgoCache.Get(key); err != ErrNotFound {
+		t.Fatalf("error should equal to %q but got: %q", ErrNotFound, err)
+	}
+
+	var data string
+	if err := mgoCache.GetInto(key, &data); err != ErrNotFound {
+		t.Fatalf("error should equal to %q but got: %q", ErrNotFound, err)
+	}
+}
+
+func TestMongoCacheWriteBatch(t *testing.T) {
+	mgoCache := NewMongoCacheWithTTL(session)
+	key1, key2 := bson.NewObjectId().Hex(), bson.NewObjectId().Hex()
+
+	if err := mgoCache.Set(key2, "test_data"); err != nil {
+		t.Fatalf("error should be nil: %q", err)
+	}
+
+	cache := NewWriteBehindCache(NewMemory(), mgoCache)
+	cache.Set(key1, "test_data")
+	cache.Delete(key2)
+
+	if err := cache.Close(); err != nil {
+		t.Fatalf("error should be nil: %q", err)
+	}
+
+	if data, err := mgoCache.Get(key1); err != nil || data != "test_data" {
+		t.Fatalf("data should equal to test_data but got: %v, %v", data, err)
+	}
+
+	if _, err := mgoCache.Get(key2); err != ErrNotFound {
+		t.Fatalf("error should equal to %q but got: %q", ErrNotFound, err)
+	}
+}
@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"regexp"
 	"time"
 
 	mgo "gopkg.in/mgo.v2"
@@ -9,36 +10,153 @@ import (
 
 // Document holds the key-value pair for mongo cache
 type Document struct {
-	Key      string      `bson:"_id" json:"_id"`
-	Value    interface{} `bson:"value" json:"value"`
-	ExpireAt time.Time   `bson:"expireAt" json:"expireAt"`
+	Key   string      `bson:"_id" json:"_id"`
+	Value interface{} `bson:"value" json:"value"`
+
+	// Codec is the type tag of the encoded values, Value holds the encoded
+	// bytes if it is set
+	Codec string `bson:"codec,omitempty" json:"codec,omitempty"`
+
+	// NotFound marks the key as cached not found, Value is not set if it is
+	// true
+	NotFound bool `bson:"notFound,omitempty" json:"notFound,omitempty"`
+
+	// Cost is the duration it took to compute the value, it is used for
+	// expiring the key early
+	Cost time.Duration `bson:"cost,omitempty" json:"cost,omitempty"`
+
+	ExpireAt time.Time `bson:"expireAt" json:"expireAt"`
+}
+
+// rawDocument is a Document whose value is not decoded yet
+type rawDocument struct {
+	Key      string        `bson:"_id"`
+	Value    bson.Raw      `bson:"value"`
+	Codec    string        `bson:"codec,omitempty"`
+	NotFound bool          `bson:"notFound,omitempty"`
+	Cost     time.Duration `bson:"cost,omitempty"`
+	ExpireAt time.Time     `bson:"expireAt"`
 }
 
 // getKey fetches the key with its key
 func (m *MongoCache) get(key string) (*Document, error) {
 	keyValue := new(Document)
 
+	if err := m.find(key, keyValue); err != nil {
+		return nil, err
+	}
+
+	return keyValue, nil
+}
+
+// getRaw fetches the key without decoding its value
+func (m *MongoCache) getRaw(key string) (*rawDocument, error) {
+	keyValue := new(rawDocument)
+
+	if err := m.find(key, keyValue); err != nil {
+		return nil, err
+	}
+
+	return keyValue, nil
+}
+
+// find fetches the non-expired document of the key into result
+func (m *MongoCache) find(key string, result interface{}) error {
 	query := func(c *mgo.Collection) error {
 		return c.Find(bson.M{
 			"_id": key,
 			"expireAt": bson.M{
-				"$gt": time.Now().UTC(),
-			}}).One(&keyValue)
+				"$gt": m.clock.Now().UTC(),
+			}}).One(result)
 	}
 
-	err := m.run(m.CollectionName, query)
+	return m.run(m.CollectionName, query)
+}
+
+func (m *MongoCache) set(key string, duration, cost time.Duration, value interface{}) error {
+	update, err := m.document(key, duration, cost, value)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return keyValue, nil
+	query := func(c *mgo.Collection) error {
+		_, err := c.UpsertId(key, update)
+		return err
+	}
+
+	return m.run(m.CollectionName, query)
 }
 
-func (m *MongoCache) set(key string, duration time.Duration, value interface{}) error {
-	update := bson.M{
+// document builds the document of the key to be upserted
+func (m *MongoCache) document(key string, duration, cost time.Duration, value interface{}) (bson.M, error) {
+	doc := bson.M{
 		"_id":      key,
 		"value":    value,
-		"expireAt": time.Now().Add(duration),
+		"expireAt": m.clock.Now().Add(duration),
+	}
+
+	if cost > 0 {
+		doc["cost"] = cost
+	}
+
+	if m.codec != nil {
+		data, err := m.codec.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+
+		doc["value"] = data
+		doc["codec"] = m.codec.Name()
+	}
+
+	return doc, nil
+}
+
+// writeBatch upserts and deletes the keys of the batch in a single bulk
+// operation
+func (m *MongoCache) writeBatch(batch []Write) error {
+	docs := make([]interface{}, 0, 2*len(batch))
+	var removes []interface{}
+
+	for _, w := range batch {
+		if w.Deleted {
+			removes = append(removes, bson.M{"_id": w.Key})
+			continue
+		}
+
+		doc, err := m.document(w.Key, jitterTTL(m.TTL, m.jitter), 0, w.Value)
+		if err != nil {
+			return err
+		}
+
+		docs = append(docs, bson.M{"_id": w.Key}, doc)
+	}
+
+	query := func(c *mgo.Collection) error {
+		bulk := c.Bulk()
+		bulk.Unordered()
+
+		if len(docs) > 0 {
+			bulk.Upsert(docs...)
+		}
+
+		if len(removes) > 0 {
+			bulk.RemoveAll(removes...)
+		}
+
+		_, err := bulk.Run()
+		return err
+	}
+
+	return m.run(m.CollectionName, query)
+}
+
+// setNotFound marks the key as not found in mongoDB
+func (m *MongoCache) setNotFound(key string, duration time.Duration) error {
+	update := bson.M{
+		"_id":      key,
+		"notFound": true,
+		"expireAt": m.clock.Now().Add(duration),
 	}
 
 	query := func(c *mgo.Collection) error {
@@ -49,6 +167,12 @@ func (m *MongoCache) set(key string, duration time.Duration, value interface{})
 	return m.run(m.CollectionName, query)
 }
 
+// expiresEarly reports whether the key which expires at expireAt should be
+// recomputed before its expiry
+func (m *MongoCache) expiresEarly(expireAt time.Time, cost time.Duration) bool {
+	return expiresEarly(m.clock.Now(), expireAt, cost, m.beta)
+}
+
 // deleteKey removes the key-value from mongoDB
 func (m *MongoCache) delete(key string) error {
 	query := func(c *mgo.Collection) error {
@@ -59,9 +183,24 @@ func (m *MongoCache) delete(key string) error {
 	return m.run(m.CollectionName, query)
 }
 
+// deletePrefix removes the keys starting with the given prefix from mongoDB,
+// anchored prefix regex is able to use the index of _id
+func (m *MongoCache) deletePrefix(prefix string) error {
+	var selector = bson.M{"_id": bson.M{
+		"$regex": "^" + regexp.QuoteMeta(prefix),
+	}}
+
+	query := func(c *mgo.Collection) error {
+		_, err := c.RemoveAll(selector)
+		return err
+	}
+
+	return m.run(m.CollectionName, query)
+}
+
 func (m *MongoCache) deleteExpiredKeys() error {
 	var selector = bson.M{"expireAt": bson.M{
-		"$lte": time.Now().UTC(),
+		"$lte": m.clock.Now().UTC(),
 	}}
 
 	query := func(c *mgo.Collection) error {
@@ -0,0 +1,122 @@
+package cache
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// PrefixDeleter is implemented by the caches which can delete all the keys
+// with a given prefix efficiently, like MongoCache
+type PrefixDeleter interface {
+	// DeletePrefix deletes all the keys starting with the given prefix
+	DeletePrefix(prefix string) error
+}
+
+// errFlushNotSupported is returned by FlushNamespace when the underlying cache
+// can neither delete nor list its keys by prefix
+var errFlushNotSupported = errors.New("cache does not support flushing namespaces")
+
+// NamespacedCache prefixes all the keys with its namespace, so multiple users
+// can share the same cache without key collisions, and the keys of a single
+// namespace can be flushed without affecting the others
+type NamespacedCache struct {
+	// cache holds the underlying cache
+	cache Cache
+
+	// prefix is prepended to all keys
+	prefix string
+}
+
+// namespaceSeparator ends the prefix of a namespace, namespaces can not
+// contain it elsewhere so no prefix starts another one
+const namespaceSeparator = ":"
+
+// NewNamespace creates a NamespacedCache over the given cache. Keys are
+// prefixed with the namespace and ":", which is appended if the namespace does
+// not end with it. Namespace must not be empty or contain ":" elsewhere,
+// otherwise it panics. For flushing the namespace, the cache must be a
+// PrefixDeleter or a KeyLister
+func NewNamespace(c Cache, ns string) *NamespacedCache {
+	prefix := strings.TrimSuffix(ns, namespaceSeparator)
+	if prefix == "" || strings.Contains(prefix, namespaceSeparator) {
+		panic("invalid namespace")
+	}
+
+	return &NamespacedCache{
+		cache:  c,
+		prefix: prefix + namespaceSeparator,
+	}
+}
+
+// Get returns the value of the prefixed key
+func (n *NamespacedCache) Get(key string) (interface{}, error) {
+	return n.cache.Get(n.prefix + key)
+}
+
+// Set sets the value of the prefixed key
+func (n *NamespacedCache) Set(key string, value interface{}) error {
+	return n.cache.Set(n.prefix+key, value)
+}
+
+// SetEx sets the value of the prefixed key with ttl duration, underlying cache
+// must implement ExpiringCache
+func (n *NamespacedCache) SetEx(key string, duration time.Duration, value interface{}) error {
+	return setEx(n.cache, n.prefix+key, duration, value)
+}
+
+// SetWithCost sets the value of the prefixed key with its cost, Set is called
+// if the underlying cache is not a CostSetter
+func (n *NamespacedCache) SetWithCost(key string, value interface{}, cost time.Duration) error {
+	return setWithCost(n.cache, n.prefix+key, value, cost)
+}
+
+// SetNotFound marks the prefixed key as not found, the key is deleted if the
+// underlying cache is not a NegativeCache
+func (n *NamespacedCache) SetNotFound(key string, ttl time.Duration) error {
+	return setNotFound(n.cache, n.prefix+key, ttl)
+}
+
+// Delete deletes the prefixed key
+func (n *NamespacedCache) Delete(key string) error {
+	return n.cache.Delete(n.prefix + key)
+}
+
+// Close closes the underlying cache if it is an io.Closer
+func (n *NamespacedCache) Close() error {
+	return closeCache(n.cache)
+}
+
+// FlushNamespace deletes all the keys of the namespace. Keys are deleted with
+// DeletePrefix if the underlying cache is a PrefixDeleter, otherwise all the
+// keys of the cache are listed and the ones with the prefix are deleted, which
+// takes time proportional to the size of the whole cache
+func (n *NamespacedCache) FlushNamespace() error {
+	return deletePrefix(n.cache, n.prefix)
+}
+
+// deletePrefix deletes the keys with the given prefix with DeletePrefix if the
+// cache is a PrefixDeleter, otherwise by listing the keys of the cache. It
+// returns errFlushNotSupported if the cache can do neither
+func deletePrefix(c Cache, prefix string) error {
+	if pd, ok := c.(PrefixDeleter); ok {
+		return pd.DeletePrefix(prefix)
+	}
+
+	lister, ok := listKeys(c)
+	if !ok {
+		return errFlushNotSupported
+	}
+
+	for _, key := range lister.Keys() {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		if err := c.Delete(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package cache
+
+import "testing"
+
+func TestNamespaceGetSet(t *testing.T) {
+	cache := NewNamespace(NewMemory(), "ns:")
+	testCacheGetSet(t, cache)
+	testCacheDelete(t, cache)
+	testCacheNilValue(t, cache)
+}
+
+func TestNamespaceFlush(t *testing.T) {
+	memory := NewMemory()
+	ns1 := NewNamespace(memory, "ns1:")
+	ns2 := NewNamespace(memory, "ns2:")
+
+	ns1.Set("test_key", "test_data")
+	ns1.Set("test_key2", "test_data2")
+	ns2.Set("test_key", "test_data")
+
+	if err := ns1.FlushNamespace(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"test_key", "test_key2"} {
+		if _, err := ns1.Get(key); err != ErrNotFound {
+			t.Fatalf("%s should be flushed from ns1", key)
+		}
+	}
+
+	if _, err := ns2.Get("test_key"); err != nil {
+		t.Fatal("test_key should be in ns2")
+	}
+
+	// keys set directly to the cache are flushed too
+	memory.Set("ns1:test_key3", "test_data3")
+	if err := ns1.FlushNamespace(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := memory.Get("ns1:test_key3"); err != ErrNotFound {
+		t.Fatal("ns1:test_key3 should be flushed")
+	}
+}
+
+func TestNamespaceFlushLRU(t *testing.T) {
+	cache := NewNamespace(NewLRU(1), "ns:")
+	cache.Set("test_key", "test_data")
+	cache.Set("test_key2", "test_data2")
+
+	if err := cache.FlushNamespace(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cache.Get("test_key2"); err != ErrNotFound {
+		t.Fatal("test_key2 should be flushed")
+	}
+}
+
+func TestNamespaceFlushNotSupported(t *testing.T) {
+	cache := NewNamespace(failingCache{}, "ns:")
+	if err := cache.FlushNamespace(); err != errFlushNotSupported {
+		t.Fatalf("error should be %q, got: %v", errFlushNotSupported, err)
+	}
+}
+
+func TestNamespaceSeparator(t *testing.T) {
+	memory := NewMemory()
+	a := NewNamespace(memory, "a")
+	ab := NewNamespace(memory, "ab")
+
+	a.Set("bx", "test_data")
+	ab.Set("x", "test_data2")
+
+	if v, err := a.Get("bx"); err != nil || v != "test_data" {
+		t.Fatalf("bx should be in a, got %v, %v", v, err)
+	}
+
+	if err := a.FlushNamespace(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ab.Get("x"); err != nil {
+		t.Fatal("flushing a should not flush ab")
+	}
+
+	if _, err := memory.Get("a:bx"); err != ErrNotFound {
+		t.Fatal("a:bx should be flushed")
+	}
+
+	for _, ns := range []string{"", ":", "a:b"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("namespace %q should panic", ns)
+				}
+			}()
+
+			NewNamespace(memory, ns)
+		}()
+	}
+}
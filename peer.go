@@ -0,0 +1,418 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultPeerBasePath     = "/_cache/"
+	defaultPeerCacheSize    = 10000
+	defaultPeerIdleConns    = 16
+	defaultPeerMaxValueSize = 32 << 20
+
+	// peerKindHeader carries the kind of the values between the peers
+	peerKindHeader = "X-Cache-Kind"
+)
+
+// PeerCache is a cache shared by the processes of a service without a
+// central store, like groupcache. Every key is owned by one of the peers,
+// which is chosen with consistent hashing. Owner fills its misses with the
+// loader, other peers fetch the key from the owner over HTTP and mirror it in
+// a small LRU, so the hot keys are served locally. Concurrent loads and
+// fetches of a key are deduplicated.
+//
+// Mirrored values are not invalidated, so PeerCache suits the values which do
+// not change once they are loaded. Set and Delete are sent to the owner of
+// the key
+type PeerCache struct {
+	// name is the name of the cache, peers serve multiple caches by name
+	name string
+
+	// self is the base URL of this peer
+	self string
+
+	// basePath is the path prefix of the peer requests
+	basePath string
+
+	// loader fills the misses of the owned keys
+	loader Loader
+
+	// codec encodes the values which are not []byte or string
+	codec Codec
+
+	// client sends the requests to the other peers
+	client *http.Client
+
+	// size and hotSize are the sizes of the owned and mirrored key caches
+	size, hotSize int
+
+	// maxValueSize is the maximum size of the encoded values sent between
+	// the peers
+	maxValueSize int64
+
+	// owned holds the values of the owned keys
+	owned Cache
+
+	// local loads the missing owned keys into owned
+	local *LoadingCache
+
+	// hot mirrors the keys owned by the other peers, fetching the missing
+	// ones from their owners
+	hot *LoadingCache
+
+	// RWMutex guards ring
+	sync.RWMutex
+
+	// ring chooses the owner of a key
+	ring *Ring
+}
+
+// PeerOption sets the options specified for PeerCache.
+type PeerOption func(*PeerCache)
+
+// WithPeerCacheSize sets the sizes of the LRU caches of the owned and the
+// mirrored keys, defaults are 10000 and an eighth of it
+func WithPeerCacheSize(size, hotSize int) PeerOption {
+	if size <= 0 || hotSize <= 0 {
+		panic("invalid peer cache size")
+	}
+
+	return func(p *PeerCache) {
+		p.size = size
+		p.hotSize = hotSize
+	}
+}
+
+// WithPeerBasePath sets the path prefix of the peer requests, default is
+// "/_cache/". All peers must use the same path
+func WithPeerBasePath(path string) PeerOption {
+	return func(p *PeerCache) {
+		p.basePath = path
+	}
+}
+
+// WithPeerCodec sets the codec for encoding the values which are not []byte
+// or string, default is JSONCodec. All peers must use the same codec
+func WithPeerCodec(c Codec) PeerOption {
+	return func(p *PeerCache) {
+		p.codec = c
+	}
+}
+
+// WithPeerMaxValueSize sets the maximum size of the encoded values sent
+// between the peers, larger ones are rejected. Default is 32MB
+func WithPeerMaxValueSize(n int64) PeerOption {
+	if n <= 0 {
+		panic("invalid max value size")
+	}
+
+	return func(p *PeerCache) {
+		p.maxValueSize = n
+	}
+}
+
+// WithHTTPClient sets the client for the requests to the other peers, default
+// client keeps 16 idle connections to every peer
+func WithHTTPClient(c *http.Client) PeerOption {
+	return func(p *PeerCache) {
+		p.client = c
+	}
+}
+
+// NewPeerCache creates a peer of the named cache, self is the base URL this
+// peer is reachable at. PeerCache is an http.Handler which must be served at
+// the base path of self, peers are set with SetPeers
+// usage:
+// p := NewPeerCache("thumbnails", "http://10.0.0.1:8080", loadThumbnail)
+// p.SetPeers("http://10.0.0.1:8080", "http://10.0.0.2:8080")
+// http.Handle("/_cache/", p)
+func NewPeerCache(name, self string, loader Loader, opts ...PeerOption) *PeerCache {
+	p := &PeerCache{
+		name:     name,
+		self:     strings.TrimSuffix(self, "/"),
+		basePath: defaultPeerBasePath,
+		loader:   loader,
+		codec:    JSONCodec,
+		size:     defaultPeerCacheSize,
+		hotSize:  defaultPeerCacheSize / 8,
+
+		maxValueSize: defaultPeerMaxValueSize,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	if p.client == nil {
+		p.client = newHTTPClient(defaultPeerIdleConns)
+	}
+
+	p.owned = NewLRU(p.size)
+	p.local = NewLoadingCache(p.owned, loader, 0)
+	p.hot = NewLoadingCache(NewLRU(p.hotSize), p.fetch, 0)
+	p.SetPeers(p.self)
+
+	return p
+}
+
+// SetPeers sets the base URLs of all peers, including this one. Keys are
+// moved to their new owners, they are loaded again by them and the ones which
+// are not owned by this peer anymore are dropped
+func (p *PeerCache) SetPeers(peers ...string) {
+	ring := NewRing()
+	ring.AddNode(p.self, p.local, 1)
+
+	for _, peer := range peers {
+		peer = strings.TrimSuffix(peer, "/")
+		if peer == p.self {
+			continue
+		}
+
+		ring.AddNode(peer, &httpPeer{
+			base:         peer + p.basePath + url.PathEscape(p.name) + "/",
+			client:       p.client,
+			codec:        p.codec,
+			maxValueSize: p.maxValueSize,
+		}, 1)
+	}
+
+	p.Lock()
+	p.ring = ring
+	p.Unlock()
+
+	for _, key := range p.owned.(KeyLister).Keys() {
+		if ring.nodesFor(key)[0].name != p.self {
+			p.local.Delete(key)
+		}
+	}
+}
+
+// Get returns the value of the key from its owner, owned keys are loaded if
+// they are missing
+func (p *PeerCache) Get(key string) (interface{}, error) {
+	owner := p.owner(key)
+
+	if owner.name == p.self {
+		return p.local.Get(key)
+	}
+
+	return p.hot.Get(key)
+}
+
+// Set sets the value on the owner of the key
+func (p *PeerCache) Set(key string, value interface{}) error {
+	owner := p.owner(key)
+
+	if owner.name != p.self {
+		p.hot.Delete(key)
+	}
+
+	return owner.cache.Set(key, value)
+}
+
+// Delete deletes the key from its owner and the local mirror
+func (p *PeerCache) Delete(key string) error {
+	owner := p.owner(key)
+
+	if owner.name != p.self {
+		p.hot.Delete(key)
+	}
+
+	return owner.cache.Delete(key)
+}
+
+// ServeHTTP serves the requests of the other peers for the owned keys, it
+// implements http.Handler
+func (p *PeerCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	prefix := p.basePath + url.PathEscape(p.name) + "/"
+	if !strings.HasPrefix(r.URL.EscapedPath(), prefix) {
+		http.NotFound(w, r)
+		return
+	}
+
+	key, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), prefix))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		value, err := p.local.Get(key)
+		if err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+
+		data, kind, err := encodeValue(p.codec, value)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set(peerKindHeader, strconv.Itoa(int(kind)))
+		w.Write(data)
+	case http.MethodPut:
+		// peers with an outdated ring may send the keys owned by another
+		// peer, they would never be invalidated here
+		if p.owner(key).name != p.self {
+			http.Error(w, "key is not owned by this peer", http.StatusMisdirectedRequest)
+			return
+		}
+
+		value, err := readPeerValue(r.Header, http.MaxBytesReader(w, r.Body, p.maxValueSize), p.codec, p.maxValueSize)
+		if err != nil {
+			status := http.StatusBadRequest
+			if _, ok := err.(*http.MaxBytesError); ok {
+				status = http.StatusRequestEntityTooLarge
+			}
+
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		if err := p.local.Set(key, value); err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		if err := p.local.Delete(key); err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// owner returns the owner node of the key, ring always has a node as this
+// peer is on it
+func (p *PeerCache) owner(key string) *ringNode {
+	p.RLock()
+	defer p.RUnlock()
+
+	return p.ring.nodesFor(key)[0]
+}
+
+// fetch gets the key from its owner, the key is loaded locally if the owner
+// can not be reached
+func (p *PeerCache) fetch(key string) (interface{}, error) {
+	owner := p.owner(key)
+
+	value, err := owner.cache.Get(key)
+	if err == nil || err == ErrNotFound {
+		return value, err
+	}
+
+	if _, ok := err.(*HTTPError); ok {
+		return nil, err
+	}
+
+	return p.loader(key)
+}
+
+// httpPeer is the Cache of the keys owned by another peer, it sends the
+// operations to the peer over HTTP
+type httpPeer struct {
+	base   string
+	client *http.Client
+	codec  Codec
+
+	// maxValueSize is the maximum size of the fetched values
+	maxValueSize int64
+}
+
+// Get fetches the value of the key from the peer
+func (h *httpPeer) Get(key string) (interface{}, error) {
+	resp, err := h.client.Get(h.base + url.PathEscape(key))
+	if err != nil {
+		return nil, err
+	}
+	defer closeHTTPBody(resp.Body)
+
+	if err := checkHTTPResponse(resp); err != nil {
+		return nil, err
+	}
+
+	return readPeerValue(resp.Header, resp.Body, h.codec, h.maxValueSize)
+}
+
+// Set sends the value of the key to the peer
+func (h *httpPeer) Set(key string, value interface{}) error {
+	data, kind, err := encodeValue(h.codec, value)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, h.base+url.PathEscape(key), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(peerKindHeader, strconv.Itoa(int(kind)))
+
+	return h.do(req)
+}
+
+// Delete deletes the key from the peer
+func (h *httpPeer) Delete(key string) error {
+	req, err := http.NewRequest(http.MethodDelete, h.base+url.PathEscape(key), nil)
+	if err != nil {
+		return err
+	}
+
+	return h.do(req)
+}
+
+func (h *httpPeer) do(req *http.Request) error {
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer closeHTTPBody(resp.Body)
+
+	return checkHTTPResponse(resp)
+}
+
+// readPeerValue decodes the value sent by a peer, values larger than
+// maxSize bytes are rejected
+func readPeerValue(header http.Header, body io.Reader, codec Codec, maxSize int64) (interface{}, error) {
+	kind, err := strconv.ParseUint(header.Get(peerKindHeader), 10, 8)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s header: %v", peerKindHeader, err)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("value is larger than %d bytes", maxSize)
+	}
+
+	return decodeValue(codec, data, uint8(kind))
+}
+
+// newHTTPClient creates a client which keeps the given number of idle
+// connections to every host, default transport keeps only 2 of them, which
+// makes the concurrent requests open new connections
+func newHTTPClient(idleConns int) *http.Client {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConnsPerHost = idleConns
+	if t.MaxIdleConns < idleConns {
+		t.MaxIdleConns = idleConns
+	}
+
+	return &http.Client{Transport: t}
+}
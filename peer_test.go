@@ -0,0 +1,284 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testPeers is a group of peers served with httptest
+type testPeers struct {
+	peers   []*PeerCache
+	servers []*httptest.Server
+
+	// requests counts the requests served by the peers
+	requests int32
+}
+
+func newTestPeers(t *testing.T, n int, loader Loader, opts ...PeerOption) *testPeers {
+	tp := &testPeers{}
+
+	var urls []string
+	for i := 0; i < n; i++ {
+		i := i
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&tp.requests, 1)
+			tp.peers[i].ServeHTTP(w, r)
+		}))
+		t.Cleanup(srv.Close)
+
+		tp.servers = append(tp.servers, srv)
+		urls = append(urls, srv.URL)
+	}
+
+	for _, u := range urls {
+		tp.peers = append(tp.peers, NewPeerCache("test", u, loader, opts...))
+	}
+
+	for _, p := range tp.peers {
+		p.SetPeers(urls...)
+	}
+
+	return tp
+}
+
+// ownerOf returns the index of the owner peer of the key
+func (tp *testPeers) ownerOf(key string) int {
+	owner := tp.peers[0].owner(key).name
+	for i, srv := range tp.servers {
+		if srv.URL == owner {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// countingLoader counts the loads of every key
+type countingLoader struct {
+	sync.Mutex
+	loads map[string]int
+}
+
+func (l *countingLoader) load(key string) (interface{}, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.loads == nil {
+		l.loads = make(map[string]int)
+	}
+
+	l.loads[key]++
+	if key == "missing" {
+		return nil, ErrNotFound
+	}
+
+	return key + "_data", nil
+}
+
+func TestPeerCacheGet(t *testing.T) {
+	loader := &countingLoader{}
+	tp := newTestPeers(t, 3, loader.load)
+
+	for i := 0; i < 30; i++ {
+		key := "test_key" + strconv.Itoa(i)
+		for _, p := range tp.peers {
+			data, err := p.Get(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if data != key+"_data" {
+				t.Fatalf("data should equal to %s_data, got: %v", key, data)
+			}
+		}
+	}
+
+	// every key is loaded once by its owner
+	for key, loads := range loader.loads {
+		if loads != 1 {
+			t.Fatalf("%s should be loaded once, got: %d", key, loads)
+		}
+	}
+
+	if _, err := tp.peers[0].Get("missing"); err != ErrNotFound {
+		t.Fatalf("error should equal to %q, got: %v", ErrNotFound, err)
+	}
+}
+
+func TestPeerCacheHotKeys(t *testing.T) {
+	loader := &countingLoader{}
+	tp := newTestPeers(t, 2, loader.load)
+
+	key := "test_key"
+	peer := tp.peers[1-tp.ownerOf(key)]
+
+	for i := 0; i < 10; i++ {
+		if _, err := peer.Get(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// key is fetched once and mirrored
+	if requests := atomic.LoadInt32(&tp.requests); requests != 1 {
+		t.Fatalf("key should be fetched from its owner once, got: %d", requests)
+	}
+}
+
+func TestPeerCacheDeduplicate(t *testing.T) {
+	var loads int32
+	release := make(chan struct{})
+	tp := newTestPeers(t, 2, func(key string) (interface{}, error) {
+		atomic.AddInt32(&loads, 1)
+		<-release
+		return "test_data", nil
+	})
+
+	key := "test_key"
+	owner := tp.ownerOf(key)
+
+	var wg sync.WaitGroup
+	for _, p := range []*PeerCache{tp.peers[owner], tp.peers[1-owner]} {
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func(p *PeerCache) {
+				defer wg.Done()
+				if data, err := p.Get(key); err != nil || data != "test_data" {
+					t.Errorf("data should equal to test_data, got: %v, %v", data, err)
+				}
+			}(p)
+		}
+	}
+
+	waitFor(t, func() bool { return atomic.LoadInt32(&loads) > 0 })
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if loads != 1 {
+		t.Fatalf("key should be loaded once, got: %d", loads)
+	}
+
+	if requests := atomic.LoadInt32(&tp.requests); requests != 1 {
+		t.Fatalf("key should be fetched from its owner once, got: %d", requests)
+	}
+}
+
+func TestPeerCacheOwnerDown(t *testing.T) {
+	loader := &countingLoader{}
+	tp := newTestPeers(t, 2, loader.load)
+
+	key := "test_key"
+	owner := tp.ownerOf(key)
+	tp.servers[owner].Close()
+
+	// key is loaded locally when its owner can not be reached
+	if data, err := tp.peers[1-owner].Get(key); err != nil || data != "test_key_data" {
+		t.Fatalf("data should equal to test_key_data, got: %v, %v", data, err)
+	}
+}
+
+func TestPeerCacheSetDelete(t *testing.T) {
+	loader := &countingLoader{}
+	tp := newTestPeers(t, 2, loader.load)
+
+	key := "test/key with spaces"
+	owner := tp.ownerOf(key)
+	peer := tp.peers[1-owner]
+
+	if err := peer.Set(key, map[string]interface{}{"name": "koding"}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := tp.peers[owner].Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data.(map[string]interface{})["name"] != "koding" {
+		t.Fatalf("value should be set on the owner, got: %v", data)
+	}
+
+	if err := peer.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+
+	// owner loads the key again after it is deleted
+	if data, err := peer.Get(key); err != nil || data != key+"_data" {
+		t.Fatalf("data should equal to %s_data, got: %v, %v", key, data, err)
+	}
+}
+
+func TestPeerCacheMaxValueSize(t *testing.T) {
+	loader := &countingLoader{}
+	tp := newTestPeers(t, 2, loader.load, WithPeerMaxValueSize(8))
+
+	key := "test_key"
+	peer := tp.peers[1-tp.ownerOf(key)]
+
+	err := peer.Set(key, "larger than 8 bytes")
+	if perr, ok := err.(*HTTPError); !ok || perr.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("error should be 413 *HTTPError, got: %v", err)
+	}
+}
+
+func TestPeerCacheMisdirectedSet(t *testing.T) {
+	loader := &countingLoader{}
+	tp := newTestPeers(t, 2, loader.load)
+
+	// a peer with an outdated ring sends the key to the wrong peer
+	key := "test_key"
+	wrong := &httpPeer{
+		base:         tp.servers[1-tp.ownerOf(key)].URL + defaultPeerBasePath + "test/",
+		client:       http.DefaultClient,
+		codec:        JSONCodec,
+		maxValueSize: defaultPeerMaxValueSize,
+	}
+
+	err := wrong.Set(key, "test_data")
+	if perr, ok := err.(*HTTPError); !ok || perr.StatusCode != http.StatusMisdirectedRequest {
+		t.Fatalf("error should be 421 *HTTPError, got: %v", err)
+	}
+}
+
+func TestPeerCacheSetPeersDropsKeys(t *testing.T) {
+	loader := &countingLoader{}
+	p := NewPeerCache("test", "http://peer1", loader.load)
+
+	var keys []string
+	for i := 0; i < 20; i++ {
+		key := "test_key" + strconv.Itoa(i)
+		if _, err := p.Get(key); err != nil {
+			t.Fatal(err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	p.SetPeers("http://peer1", "http://peer2")
+
+	moved := 0
+	for _, key := range keys {
+		_, err := p.owned.Get(key)
+		if p.owner(key).name == p.self {
+			if err != nil {
+				t.Fatalf("%s is still owned, it should be kept", key)
+			}
+
+			continue
+		}
+
+		moved++
+		if err != ErrNotFound {
+			t.Fatalf("%s is moved to peer2, it should be dropped", key)
+		}
+	}
+
+	if moved == 0 {
+		t.Fatal("some keys should be moved to peer2")
+	}
+}
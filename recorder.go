@@ -0,0 +1,325 @@
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"os"
+	"sync"
+
+	"github.com/koding/cache/clock"
+)
+
+// Recorder is a Cache decorator which records every access to the underlying
+// cache as a line to the given writer, so the production workloads can be
+// replayed later on with cmd/cachesim. Recorded lines are in the form of;
+//
+//	<unix nano time> <get|set|delete> <fnv-64a hash of key> <hit|miss|-> <value size>
+//
+// Value size is only recorded when a size function is given, it is 0
+// otherwise. Recording errors do not fail the cache operations, they are
+// reported by Err and Close
+type Recorder struct {
+	// Mutex is used for serializing the writes
+	sync.Mutex
+
+	// cache holds the recorded cache
+	cache Cache
+
+	// w buffers the writes to the underlying writer
+	w *bufio.Writer
+
+	// closer closes the underlying writer, it is nil if the writer is not an
+	// io.Closer
+	closer io.Closer
+
+	// threshold is the upper bound of the sampled key hashes
+	threshold uint64
+
+	// sizeOf returns the size of a value
+	sizeOf func(value interface{}) int
+
+	// clock is used for timestamping the records
+	clock clock.Clock
+
+	// err holds the first error while recording
+	err error
+}
+
+// RecorderOption sets the options specified for Recorder.
+type RecorderOption func(*Recorder)
+
+// WithSampleRate sets the ratio of the keys to be recorded, between 0 and 1.
+// Keys are sampled by their hashes, so all accesses to a sampled key are
+// recorded, which keeps the hit ratios of the sampled trace close to the
+// original one
+func WithSampleRate(rate float64) RecorderOption {
+	if rate < 0 || rate > 1 {
+		panic("invalid sample rate")
+	}
+
+	return func(r *Recorder) {
+		r.threshold = uint64(rate * math.MaxUint64)
+		if rate == 1 {
+			r.threshold = math.MaxUint64
+		}
+	}
+}
+
+// WithValueSize sets the function which calculates the recorded value sizes
+func WithValueSize(f func(value interface{}) int) RecorderOption {
+	return func(r *Recorder) {
+		r.sizeOf = f
+	}
+}
+
+// WithRecorderClock sets the clock which is used for timestamping the records
+func WithRecorderClock(c clock.Clock) RecorderOption {
+	return func(r *Recorder) {
+		r.clock = c
+	}
+}
+
+// NewRecorder creates a Recorder which records the accesses to the given
+// cache into w. If w is an io.Closer, it is closed with the Recorder
+func NewRecorder(c Cache, w io.Writer, opts ...RecorderOption) *Recorder {
+	r := &Recorder{
+		cache:     c,
+		w:         bufio.NewWriter(w),
+		threshold: math.MaxUint64,
+		clock:     clock.New(),
+	}
+
+	if closer, ok := w.(io.Closer); ok {
+		r.closer = closer
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// Get returns the value of a given key from the underlying cache and records
+// the access as a hit or miss, failed accesses are not recorded
+func (r *Recorder) Get(key string) (interface{}, error) {
+	value, err := r.cache.Get(key)
+
+	switch {
+	case err == nil:
+		r.record("get", key, "hit", value)
+	case IsNotFound(err):
+		r.record("get", key, "miss", nil)
+	}
+
+	return value, err
+}
+
+// Set sets the value to the underlying cache and records the access if it
+// succeeds
+func (r *Recorder) Set(key string, value interface{}) error {
+	err := r.cache.Set(key, value)
+	if err == nil {
+		r.record("set", key, "-", value)
+	}
+
+	return err
+}
+
+// Delete deletes the key from the underlying cache and records the access if
+// it succeeds
+func (r *Recorder) Delete(key string) error {
+	err := r.cache.Delete(key)
+	if err == nil {
+		r.record("delete", key, "-", nil)
+	}
+
+	return err
+}
+
+// Flush writes the buffered records to the underlying writer
+func (r *Recorder) Flush() error {
+	r.Lock()
+	defer r.Unlock()
+
+	if err := r.w.Flush(); err != nil && r.err == nil {
+		r.err = err
+	}
+
+	return r.err
+}
+
+// Err returns the first error occurred while recording
+func (r *Recorder) Err() error {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.err
+}
+
+// Close flushes the buffered records and closes the underlying writer if it
+// is an io.Closer, it implements io.Closer
+func (r *Recorder) Close() error {
+	err := r.Flush()
+
+	if r.closer != nil {
+		if cerr := r.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+func (r *Recorder) record(op, key, result string, value interface{}) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+
+	if mix(sum) > r.threshold {
+		return
+	}
+
+	size := 0
+	if r.sizeOf != nil && value != nil {
+		size = r.sizeOf(value)
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	_, err := fmt.Fprintf(r.w, "%d %s %016x %s %d\n", r.clock.Now().UnixNano(), op, sum, result, size)
+	if err != nil && r.err == nil {
+		r.err = err
+	}
+}
+
+// mix spreads the bits of the fnv hash, high bits of fnv hashes are not
+// uniformly distributed for similar keys. It is the finalizer of murmur3
+func mix(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// RotatingFile is an io.WriteCloser which writes to a file and rotates it
+// when its size exceeds the limit. Rotated files are renamed with .1, .2 ...
+// suffixes, .1 is the most recent one
+type RotatingFile struct {
+	// Mutex is used for serializing the writes and rotations
+	sync.Mutex
+
+	// path holds the path of the current file
+	path string
+
+	// maxSize is the size limit of a file in bytes
+	maxSize int64
+
+	// maxBackups is the number of the rotated files to keep
+	maxBackups int
+
+	// file holds the current file
+	file *os.File
+
+	// size holds the size of the current file
+	size int64
+}
+
+// NewRotatingFile opens the file at path for appending, it is rotated when its
+// size exceeds maxSize bytes and at most maxBackups rotated files are kept
+func NewRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	if maxSize < 1 {
+		return nil, fmt.Errorf("invalid max size %d", maxSize)
+	}
+
+	if maxBackups < 0 {
+		return nil, fmt.Errorf("invalid max backups %d", maxBackups)
+	}
+
+	f := &RotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Write writes p to the current file, the file is rotated before the write if
+// it would exceed the size limit
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Close closes the current file, it implements io.Closer
+func (f *RotatingFile) Close() error {
+	f.Lock()
+	defer f.Unlock()
+
+	return f.file.Close()
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+
+	if f.maxBackups < 1 {
+		if err := os.Remove(f.path); err != nil {
+			return err
+		}
+
+		return f.open()
+	}
+
+	for i := f.maxBackups - 1; i > 0; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if err := os.Rename(f.path, f.path+".1"); err != nil {
+		return err
+	}
+
+	return f.open()
+}
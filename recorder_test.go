@@ -0,0 +1,134 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/koding/cache/clock/clocktest"
+)
+
+func TestRecorderGetSet(t *testing.T) {
+	var buf bytes.Buffer
+	cache := NewRecorder(NewMemory(), &buf)
+	testCacheGetSet(t, cache)
+}
+
+func TestRecorderRecords(t *testing.T) {
+	var buf bytes.Buffer
+	now := time.Unix(0, 42)
+	cache := NewRecorder(NewMemory(), &buf,
+		WithRecorderClock(clocktest.NewFake(now)),
+		WithValueSize(func(v interface{}) int { return len(v.(string)) }),
+	)
+
+	cache.Get("test_key")
+	cache.Set("test_key", "test_data")
+	cache.Get("test_key")
+	cache.Delete("test_key")
+
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte("test_key"))
+	sum := fmt.Sprintf("%016x", h.Sum64())
+
+	expected := strings.Join([]string{
+		"42 get " + sum + " miss 0",
+		"42 set " + sum + " - 9",
+		"42 get " + sum + " hit 9",
+		"42 delete " + sum + " - 0",
+	}, "\n") + "\n"
+
+	if buf.String() != expected {
+		t.Fatalf("records should be %q, got: %q", expected, buf.String())
+	}
+}
+
+func TestRecorderSkipsFailures(t *testing.T) {
+	var buf bytes.Buffer
+	cache := NewRecorder(failingCache{}, &buf)
+
+	if _, err := cache.Get("test_key"); err != errFailingCache {
+		t.Fatalf("error should be %q, got: %v", errFailingCache, err)
+	}
+
+	if err := cache.Set("test_key", "test_data"); err != errFailingCache {
+		t.Fatalf("error should be %q, got: %v", errFailingCache, err)
+	}
+
+	if err := cache.Delete("test_key"); err != errFailingCache {
+		t.Fatalf("error should be %q, got: %v", errFailingCache, err)
+	}
+
+	cache.Flush()
+	if buf.Len() != 0 {
+		t.Fatalf("failed accesses should not be recorded, got: %q", buf.String())
+	}
+}
+
+func TestRecorderSampleRate(t *testing.T) {
+	var buf bytes.Buffer
+	cache := NewRecorder(NewMemory(), &buf, WithSampleRate(0.5))
+
+	for i := 0; i < 1000; i++ {
+		cache.Set(fmt.Sprintf("test_key%d", i), i)
+	}
+	cache.Flush()
+
+	n := strings.Count(buf.String(), "\n")
+	if n < 400 || n > 600 {
+		t.Fatalf("about half of the keys should be recorded, got: %d", n)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recorder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "trace")
+	f, err := NewRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []string{"aaaaaa\n", "bbbbbb\n", "cccccc\n", "dddddd\n"} {
+		if _, err := f.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for suffix, content := range map[string]string{"": "dddddd\n", ".1": "cccccc\n", ".2": "bbbbbb\n"} {
+		data, err := ioutil.ReadFile(path + suffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(data) != content {
+			t.Fatalf("%s should contain %q, got: %q", path+suffix, content, data)
+		}
+	}
+
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatal("only 2 backups should be kept")
+	}
+
+	if _, err := NewRotatingFile(path, 0, 2); err == nil {
+		t.Fatal("invalid max size should give error")
+	}
+}
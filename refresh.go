@@ -0,0 +1,483 @@
+package cache
+
+import (
+	"sync"
+	"time"
+
+	"github.com/koding/cache/clock"
+)
+
+const (
+	defaultRefreshWorkers = 4
+	defaultMinBackoff     = time.Second
+	defaultMaxBackoff     = time.Minute
+	defaultMaxTrackedKeys = 10000
+)
+
+// RefreshCache is a read-through cache which reloads the registered and the
+// frequently accessed keys in the background, before they expire in the
+// underlying cache, so they are never missed. Refresh interval must be
+// shorter than the TTL of the underlying cache, e.g. MemoryTTL or MongoCache.
+//
+// Reloads are done by a bounded number of workers, failing reloads are
+// retried with exponential backoff and the last good value of the key is
+// served until a reload succeeds
+type RefreshCache struct {
+	// Mutex guards keys and hits
+	sync.Mutex
+
+	// cache holds the underlying cache
+	cache Cache
+
+	// loading loads the missing keys into the cache
+	loading *LoadingCache
+
+	// loader loads the refreshed keys
+	loader Loader
+
+	// interval is the duration between the refreshes of a key
+	interval time.Duration
+
+	// workers is the maximum number of concurrent reloads
+	workers int
+
+	// minBackoff and maxBackoff are the bounds of the retry delay of the
+	// failing reloads
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	// hotThreshold is the access count in an interval for a key to be
+	// refreshed, access counts are not tracked if it is 0
+	hotThreshold int
+
+	// keys holds the refreshed keys
+	keys map[string]*refreshKey
+
+	// hits holds the access counts of the keys in the current interval
+	hits map[string]int
+
+	// maxTrackedKeys is the maximum number of keys in hits
+	maxTrackedKeys int
+
+	// windowEnd is the end of the current interval for the access counts
+	windowEnd time.Time
+
+	// clock is used for scheduling the refreshes
+	clock clock.Clock
+
+	// jobs passes the keys to be reloaded to the workers
+	jobs chan string
+
+	// done stops the scheduler
+	done chan struct{}
+
+	// wg waits for the scheduler and the workers
+	wg sync.WaitGroup
+
+	// closeOnce guards Close
+	closeOnce sync.Once
+}
+
+// refreshKey is the refresh state of a key
+type refreshKey struct {
+	// registered keys are refreshed until they are unregistered, the others
+	// until they are not accessed for an interval
+	registered bool
+
+	// value holds the last good value of the key
+	value    interface{}
+	hasValue bool
+
+	// nextAt is the time of the next reload
+	nextAt time.Time
+
+	// loading is true while the key is being reloaded
+	loading bool
+
+	// failures is the number of consecutive failed reloads
+	failures int
+}
+
+// RefreshOption sets the options specified for RefreshCache.
+type RefreshOption func(*RefreshCache)
+
+// WithWorkers sets the maximum number of concurrent reloads, default is 4
+func WithWorkers(n int) RefreshOption {
+	if n <= 0 {
+		panic("invalid worker count")
+	}
+
+	return func(r *RefreshCache) {
+		r.workers = n
+	}
+}
+
+// WithBackoff sets the bounds of the retry delay of the failing reloads, the
+// delay doubles with every failure. Default is between a second and a minute
+func WithBackoff(min, max time.Duration) RefreshOption {
+	if min <= 0 || max < min {
+		panic("invalid backoff")
+	}
+
+	return func(r *RefreshCache) {
+		r.minBackoff = min
+		r.maxBackoff = max
+	}
+}
+
+// WithHotKeys enables refreshing the keys which are accessed at least
+// threshold times in an interval, they are refreshed until they are not
+// accessed for a whole interval
+func WithHotKeys(threshold int) RefreshOption {
+	if threshold < 0 {
+		panic("invalid hot key threshold")
+	}
+
+	return func(r *RefreshCache) {
+		r.hotThreshold = threshold
+	}
+}
+
+// WithMaxTrackedKeys sets the maximum number of keys whose access counts are
+// tracked in an interval for WithHotKeys, accesses to the other keys are not
+// counted until the interval ends. Default is 10000
+func WithMaxTrackedKeys(n int) RefreshOption {
+	if n <= 0 {
+		panic("invalid tracked key count")
+	}
+
+	return func(r *RefreshCache) {
+		r.maxTrackedKeys = n
+	}
+}
+
+// WithRefreshClock sets the clock which is used for scheduling the refreshes
+func WithRefreshClock(c clock.Clock) RefreshOption {
+	return func(r *RefreshCache) {
+		r.clock = c
+	}
+}
+
+// NewRefreshCache creates a refresh-ahead cache over the given cache and
+// starts its scheduler, which must be stopped with Close
+func NewRefreshCache(c Cache, loader Loader, interval time.Duration, opts ...RefreshOption) *RefreshCache {
+	if interval <= 0 {
+		panic("invalid refresh interval")
+	}
+
+	r := &RefreshCache{
+		cache:      c,
+		loading:    NewLoadingCache(c, loader, 0),
+		loader:     loader,
+		interval:   interval,
+		workers:    defaultRefreshWorkers,
+		minBackoff: defaultMinBackoff,
+		maxBackoff: defaultMaxBackoff,
+		keys:       make(map[string]*refreshKey),
+		hits:       make(map[string]int),
+		clock:      clock.New(),
+		done:       make(chan struct{}),
+
+		maxTrackedKeys: defaultMaxTrackedKeys,
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	// ticks are frequent enough for the retries of the failing reloads
+	tick := interval
+	if r.minBackoff < tick {
+		tick = r.minBackoff
+	}
+
+	r.windowEnd = r.clock.Now().Add(interval)
+	r.jobs = make(chan string)
+
+	r.wg.Add(r.workers + 1)
+	for i := 0; i < r.workers; i++ {
+		go r.work()
+	}
+
+	go r.schedule(r.clock.NewTicker(tick))
+
+	return r
+}
+
+// Register loads the key and refreshes it until it is unregistered. Key stays
+// registered even if the load fails, it is retried in the background
+func (r *RefreshCache) Register(key string) error {
+	r.Lock()
+	k, ok := r.keys[key]
+	if !ok {
+		k = &refreshKey{}
+		r.keys[key] = k
+	}
+	k.registered = true
+	k.nextAt = r.clock.Now().Add(r.interval)
+	r.Unlock()
+
+	value, err := r.loading.Load(key)
+	if err != nil {
+		r.Lock()
+		r.fail(key)
+		r.Unlock()
+
+		return err
+	}
+
+	r.Lock()
+	r.store(key, value)
+	r.Unlock()
+
+	return nil
+}
+
+// Unregister stops refreshing the key, it is still refreshed if it is hot
+func (r *RefreshCache) Unregister(key string) {
+	r.Lock()
+	defer r.Unlock()
+
+	k, ok := r.keys[key]
+	if !ok {
+		return
+	}
+
+	// hot keys are dropped by rotateHits when they are not accessed for an
+	// interval, there are no hot keys without the threshold
+	if r.hotThreshold == 0 {
+		delete(r.keys, key)
+		return
+	}
+
+	k.registered = false
+}
+
+// Get returns the value of a given key, it is loaded if it is not in the
+// cache. Last good value of a refreshed key is returned if the key is missing
+// or the underlying cache fails
+func (r *RefreshCache) Get(key string) (interface{}, error) {
+	r.hit(key)
+
+	value, err := r.cache.Get(key)
+	if err == nil || err == ErrCachedNotFound {
+		return value, err
+	}
+
+	if value, ok := r.lastGood(key); ok {
+		return value, nil
+	}
+
+	if err != ErrNotFound {
+		return nil, err
+	}
+
+	// key is already looked up, so it is loaded without looking it up again
+	value, err = r.loading.Load(key)
+	if err != nil {
+		return nil, err
+	}
+
+	r.Lock()
+	r.store(key, value)
+	r.Unlock()
+
+	return value, nil
+}
+
+// Set sets the value to the underlying cache
+func (r *RefreshCache) Set(key string, value interface{}) error {
+	if err := r.cache.Set(key, value); err != nil {
+		return err
+	}
+
+	r.Lock()
+	r.store(key, value)
+	r.Unlock()
+
+	return nil
+}
+
+// Delete deletes the given key from the underlying cache, last good value of
+// the key is dropped but it is still refreshed if it is registered
+func (r *RefreshCache) Delete(key string) error {
+	r.Lock()
+	if k, ok := r.keys[key]; ok {
+		k.value, k.hasValue = nil, false
+	}
+	r.Unlock()
+
+	return r.cache.Delete(key)
+}
+
+// Close stops the scheduler and waits for the running reloads, it implements
+// io.Closer
+func (r *RefreshCache) Close() error {
+	r.closeOnce.Do(func() {
+		close(r.done)
+		r.wg.Wait()
+	})
+
+	return nil
+}
+
+// hit counts the access to the key
+func (r *RefreshCache) hit(key string) {
+	if r.hotThreshold == 0 {
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	if _, ok := r.hits[key]; ok || len(r.hits) < r.maxTrackedKeys {
+		r.hits[key]++
+	}
+}
+
+// lastGood returns the last good value of a refreshed key
+func (r *RefreshCache) lastGood(key string) (interface{}, bool) {
+	r.Lock()
+	defer r.Unlock()
+
+	k, ok := r.keys[key]
+	if !ok || !k.hasValue {
+		return nil, false
+	}
+
+	return k.value, true
+}
+
+// store records the value as the last good value of the key if it is
+// refreshed. It must be called with the lock held
+func (r *RefreshCache) store(key string, value interface{}) {
+	if k, ok := r.keys[key]; ok {
+		k.value, k.hasValue = value, true
+	}
+}
+
+// fail schedules the retry of a failed reload. It must be called with the
+// lock held
+func (r *RefreshCache) fail(key string) {
+	k, ok := r.keys[key]
+	if !ok {
+		return
+	}
+
+	k.failures++
+
+	backoff := r.minBackoff
+	for i := 1; i < k.failures && backoff < r.maxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > r.maxBackoff {
+		backoff = r.maxBackoff
+	}
+
+	k.nextAt = r.clock.Now().Add(backoff)
+}
+
+// schedule dispatches the due keys to the workers at every tick
+func (r *RefreshCache) schedule(ticker clock.Ticker) {
+	defer r.wg.Done()
+	defer close(r.jobs)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C():
+			for _, key := range r.due(now) {
+				select {
+				case r.jobs <- key:
+				case <-r.done:
+					return
+				}
+			}
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// due returns the keys which should be reloaded at the given time and marks
+// them as loading. Hot keys are tracked at the end of every interval
+func (r *RefreshCache) due(now time.Time) []string {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.hotThreshold > 0 && !now.Before(r.windowEnd) {
+		r.rotateHits(now)
+	}
+
+	var keys []string
+	for key, k := range r.keys {
+		if k.loading || now.Before(k.nextAt) {
+			continue
+		}
+
+		k.loading = true
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// rotateHits starts refreshing the keys which are hot in the ended interval
+// and stops refreshing the ones which are not accessed in it. It must be
+// called with the lock held
+func (r *RefreshCache) rotateHits(now time.Time) {
+	for key, k := range r.keys {
+		if !k.registered && !k.loading && r.hits[key] == 0 {
+			delete(r.keys, key)
+		}
+	}
+
+	for key, hits := range r.hits {
+		if _, ok := r.keys[key]; ok || hits < r.hotThreshold {
+			continue
+		}
+
+		r.keys[key] = &refreshKey{nextAt: now}
+	}
+
+	r.hits = make(map[string]int)
+	r.windowEnd = now.Add(r.interval)
+}
+
+// work reloads the keys dispatched by the scheduler
+func (r *RefreshCache) work() {
+	defer r.wg.Done()
+
+	for key := range r.jobs {
+		r.reload(key)
+	}
+}
+
+// reload loads the key and sets it to the underlying cache, panics of the
+// loader are failed reloads
+func (r *RefreshCache) reload(key string) {
+	value, err := r.loader.load(key)
+	if err == nil {
+		err = r.cache.Set(key, value)
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	k, ok := r.keys[key]
+	if !ok {
+		return
+	}
+
+	k.loading = false
+
+	if err != nil {
+		r.fail(key)
+		return
+	}
+
+	k.failures = 0
+	k.nextAt = r.clock.Now().Add(r.interval)
+	r.store(key, value)
+}
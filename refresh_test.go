@@ -0,0 +1,319 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/koding/cache/clock/clocktest"
+)
+
+// testLoader is a loader which returns the load count of the key as value,
+// it fails while err is set
+type testLoader struct {
+	sync.Mutex
+	loads map[string]int
+	err   error
+}
+
+func newTestLoader() *testLoader {
+	return &testLoader{loads: make(map[string]int)}
+}
+
+func (l *testLoader) load(key string) (interface{}, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	l.loads[key]++
+	if l.err != nil {
+		return nil, l.err
+	}
+
+	return l.loads[key], nil
+}
+
+func (l *testLoader) count(key string) int {
+	l.Lock()
+	defer l.Unlock()
+
+	return l.loads[key]
+}
+
+func (l *testLoader) setErr(err error) {
+	l.Lock()
+	defer l.Unlock()
+
+	l.err = err
+}
+
+// waitFor waits until the condition is true
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the condition")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// waitReload waits until the key is reloaded for the given times and the
+// reload is done
+func waitReload(t *testing.T, r *RefreshCache, l *testLoader, key string, loads int) {
+	waitFor(t, func() bool {
+		r.Lock()
+		defer r.Unlock()
+
+		k, ok := r.keys[key]
+		return l.count(key) >= loads && ok && !k.loading
+	})
+}
+
+func TestRefreshCacheRegister(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	loader := newTestLoader()
+	cache := NewRefreshCache(NewMemoryWithTTL(90*time.Second, WithClock(clock)), loader.load, time.Minute, WithRefreshClock(clock))
+	defer cache.Close()
+
+	if err := cache.Register("test_key"); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err := cache.Get("test_key"); err != nil || data != 1 {
+		t.Fatalf("data should equal 1, got: %v, %v", data, err)
+	}
+
+	clock.Advance(time.Minute)
+	waitReload(t, cache, loader, "test_key", 2)
+
+	if data, err := cache.Get("test_key"); err != nil || data != 2 {
+		t.Fatalf("data should equal 2, got: %v, %v", data, err)
+	}
+
+	// key never expires in the underlying cache while it is refreshed
+	clock.Advance(time.Minute)
+	waitReload(t, cache, loader, "test_key", 3)
+
+	if data, err := cache.Get("test_key"); err != nil || data != 3 {
+		t.Fatalf("data should equal 3, got: %v, %v", data, err)
+	}
+}
+
+func TestRefreshCacheUnregister(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	loader := newTestLoader()
+	cache := NewRefreshCache(NewMemory(), loader.load, time.Minute, WithRefreshClock(clock))
+	defer cache.Close()
+
+	if err := cache.Register("test_key"); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(time.Minute)
+	waitReload(t, cache, loader, "test_key", 2)
+
+	cache.Unregister("test_key")
+
+	cache.Lock()
+	_, ok := cache.keys["test_key"]
+	cache.Unlock()
+
+	if ok {
+		t.Fatal("unregistered key should not be refreshed")
+	}
+
+	for i := 0; i < 3; i++ {
+		clock.Advance(time.Minute)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	if n := loader.count("test_key"); n != 2 {
+		t.Fatalf("unregistered key should not be reloaded, got %d loads", n)
+	}
+}
+
+func TestRefreshCachePanic(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+
+	var panics int32
+	loader := func(key string) (interface{}, error) {
+		if atomic.LoadInt32(&panics) == 1 {
+			panic("source is down")
+		}
+
+		return "test_data", nil
+	}
+
+	cache := NewRefreshCache(NewMemory(), loader, time.Minute, WithRefreshClock(clock), WithBackoff(time.Second, time.Second))
+	defer cache.Close()
+
+	if err := cache.Register("test_key"); err != nil {
+		t.Fatal(err)
+	}
+
+	atomic.StoreInt32(&panics, 1)
+	clock.Advance(time.Minute)
+
+	// panicking reload is a failed one, it is retried
+	waitFor(t, func() bool {
+		cache.Lock()
+		defer cache.Unlock()
+
+		k := cache.keys["test_key"]
+		return k.failures == 1 && !k.loading
+	})
+
+	atomic.StoreInt32(&panics, 0)
+	clock.Advance(time.Second)
+
+	waitFor(t, func() bool {
+		cache.Lock()
+		defer cache.Unlock()
+
+		k := cache.keys["test_key"]
+		return k.failures == 0 && !k.loading
+	})
+}
+
+func TestRefreshCacheLastGood(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	loader := newTestLoader()
+	cache := NewRefreshCache(NewMemoryWithTTL(90*time.Second, WithClock(clock)), loader.load, time.Minute, WithRefreshClock(clock))
+	defer cache.Close()
+
+	if err := cache.Register("test_key"); err != nil {
+		t.Fatal(err)
+	}
+
+	loader.setErr(errors.New("load failed"))
+
+	clock.Advance(time.Minute)
+	waitReload(t, cache, loader, "test_key", 2)
+
+	// key is expired in the underlying cache, last good value is served
+	clock.Advance(time.Minute)
+	if data, err := cache.Get("test_key"); err != nil || data != 1 {
+		t.Fatalf("data should equal 1, got: %v, %v", data, err)
+	}
+}
+
+func TestRefreshCacheBackoff(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	loader := newTestLoader()
+	cache := NewRefreshCache(NewMemory(), loader.load, time.Minute, WithRefreshClock(clock), WithBackoff(time.Second, 3*time.Second))
+	defer cache.Close()
+
+	loader.setErr(errors.New("load failed"))
+	if err := cache.Register("test_key"); err == nil {
+		t.Fatal("error should not be nil")
+	}
+
+	for i, backoff := range []time.Duration{2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		clock.Advance(time.Second)
+		waitReload(t, cache, loader, "test_key", i+2)
+
+		cache.Lock()
+		nextAt := cache.keys["test_key"].nextAt
+		cache.Unlock()
+
+		if want := clock.Now().Add(backoff); !nextAt.Equal(want) {
+			t.Fatalf("retry %d should be after %s, got: %s", i, backoff, nextAt.Sub(clock.Now()))
+		}
+
+		clock.Advance(backoff - time.Second)
+	}
+
+	loader.setErr(nil)
+	clock.Advance(time.Second)
+	waitReload(t, cache, loader, "test_key", 5)
+
+	if data, err := cache.Get("test_key"); err != nil || data != 5 {
+		t.Fatalf("data should equal 5, got: %v, %v", data, err)
+	}
+}
+
+func TestRefreshCacheHotKeys(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	loader := newTestLoader()
+	cache := NewRefreshCache(NewMemory(), loader.load, time.Minute, WithRefreshClock(clock), WithHotKeys(2))
+	defer cache.Close()
+
+	cache.Get("hot_key")
+	cache.Get("hot_key")
+	cache.Get("cold_key")
+
+	clock.Advance(time.Minute)
+	waitReload(t, cache, loader, "hot_key", 2)
+
+	if data, err := cache.Get("hot_key"); err != nil || data != 2 {
+		t.Fatalf("data should equal 2, got: %v, %v", data, err)
+	}
+
+	cache.Lock()
+	_, ok := cache.keys["cold_key"]
+	cache.Unlock()
+
+	if ok {
+		t.Fatal("cold_key should not be refreshed")
+	}
+
+	// hot key is not refreshed after an interval without access
+	clock.Advance(time.Minute)
+	waitReload(t, cache, loader, "hot_key", 3)
+	clock.Advance(time.Minute)
+	clock.Advance(time.Minute)
+
+	cache.Lock()
+	_, ok = cache.keys["hot_key"]
+	cache.Unlock()
+
+	if ok {
+		t.Fatal("hot_key should not be refreshed anymore")
+	}
+}
+
+func TestRefreshCacheMaxTrackedKeys(t *testing.T) {
+	loader := newTestLoader()
+	cache := NewRefreshCache(NewMemory(), loader.load, time.Minute, WithHotKeys(1), WithMaxTrackedKeys(2))
+	defer cache.Close()
+
+	for _, key := range []string{"test_key1", "test_key2", "test_key3", "test_key1"} {
+		cache.Get(key)
+	}
+
+	cache.Lock()
+	hits := cache.hits
+	cache.Unlock()
+
+	if len(hits) != 2 || hits["test_key1"] != 2 {
+		t.Fatalf("only the access counts of 2 keys should be tracked, got: %v", hits)
+	}
+}
+
+// countingCache counts the Get calls of the underlying cache
+type countingCache struct {
+	Cache
+	gets int32
+}
+
+func (c *countingCache) Get(key string) (interface{}, error) {
+	atomic.AddInt32(&c.gets, 1)
+	return c.Cache.Get(key)
+}
+
+func TestRefreshCacheMissLookup(t *testing.T) {
+	counting := &countingCache{Cache: NewMemory()}
+	cache := NewRefreshCache(counting, newTestLoader().load, time.Minute)
+	defer cache.Close()
+
+	if _, err := cache.Get("test_key"); err != nil {
+		t.Fatal(err)
+	}
+
+	if gets := atomic.LoadInt32(&counting.gets); gets != 1 {
+		t.Fatalf("missing key should be looked up once, got: %d", gets)
+	}
+}
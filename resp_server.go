@@ -0,0 +1,983 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxRESPArgs is the maximum number of arguments of a command
+	maxRESPArgs = 1 << 20
+
+	// maxRESPBulkLen is the maximum length of an argument, as in Redis
+	maxRESPBulkLen = 512 << 20
+
+	// maxRESPInlineLen is the maximum length of an inline command
+	maxRESPInlineLen = 64 << 10
+
+	// respPrealloc bounds the buffers allocated for the claimed lengths of
+	// a command, larger ones grow as the bytes are read
+	respPrealloc = 64 << 10
+
+	defaultRESPScanCount = 10
+
+	// maxDuration is the longest ttl, longer ones overflow time.Duration
+	maxDuration = time.Duration(1<<63 - 1)
+)
+
+// ErrServerClosed is returned by RESPServer.Serve after the server is closed
+var ErrServerClosed = errors.New("cache: server closed")
+
+// respProtocolError is returned for the malformed requests, the connection is
+// closed after it is responded
+type respProtocolError string
+
+func (e respProtocolError) Error() string {
+	return "Protocol error: " + string(e)
+}
+
+// RESPServer serves a Cache with the Redis protocol (RESP2), so redis-cli and
+// the Redis clients can be used with the caches of this package. It supports
+// the following commands:
+//
+//	GET key
+//	SET key value [EX seconds|PX milliseconds] [NX|XX]
+//	DEL key [key ...]
+//	EXISTS key [key ...]
+//	INCR key
+//	TTL key
+//	EXPIRE key seconds
+//	FLUSHDB
+//	DBSIZE
+//	SCAN cursor [MATCH pattern] [COUNT count]
+//	PING [message], SELECT 0, QUIT
+//
+// Values are set as strings. Values which are not []byte or string are
+// returned encoded with the codec, integers as their decimal forms.
+//
+// Expiration requires the cache to implement ExpiringCache, TTL reports -1
+// for all the existing keys unless it implements TTLReader. EXPIRE uses
+// Expirer and INCR uses Counter when they are implemented. FLUSHDB uses
+// PrefixDeleter or KeyLister, DBSIZE and SCAN require KeyLister. SCAN lists
+// and sorts all the keys on every call, so it is not incremental and costs as
+// much as listing the whole cache for each page. The commands which read and
+// then update a key, like SET NX, are atomic only among the clients of the
+// server.
+//
+// Pipelined commands are responded in a single write and every connection is
+// served in its own goroutine
+type RESPServer struct {
+	// cache is the served cache
+	cache Cache
+
+	// codec encodes the values which are not []byte or string
+	codec Codec
+
+	// update serializes the commands which read and then update a key
+	update sync.Mutex
+
+	// Mutex guards listeners, conns and closed
+	sync.Mutex
+
+	// listeners holds the listeners of Serve
+	listeners map[net.Listener]struct{}
+
+	// conns holds the served connections
+	conns map[net.Conn]struct{}
+
+	// closed is set by Close
+	closed bool
+
+	// wg waits for the connection goroutines
+	wg sync.WaitGroup
+}
+
+// RESPOption sets the options specified for RESPServer.
+type RESPOption func(*RESPServer)
+
+// WithRESPCodec sets the codec for encoding the values which are not []byte
+// or string, default is JSONCodec
+func WithRESPCodec(c Codec) RESPOption {
+	return func(s *RESPServer) {
+		s.codec = c
+	}
+}
+
+// NewRESPServer creates a server for the given cache
+// usage:
+// s := NewRESPServer(NewMemoryWithTTL(0))
+// go s.ListenAndServe("localhost:6379")
+// defer s.Close()
+func NewRESPServer(c Cache, opts ...RESPOption) *RESPServer {
+	s := &RESPServer{
+		cache:     c,
+		codec:     JSONCodec,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// ListenAndServe listens on the given TCP address and serves the connections,
+// it returns ErrServerClosed after Close
+func (s *RESPServer) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(l)
+}
+
+// Serve serves the connections accepted from the listener, the listener is
+// closed when Serve returns. It returns ErrServerClosed after Close
+func (s *RESPServer) Serve(l net.Listener) error {
+	s.Lock()
+	if s.closed {
+		s.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.Unlock()
+
+	defer func() {
+		s.Lock()
+		delete(s.listeners, l)
+		s.Unlock()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+
+			return err
+		}
+
+		if !s.track(conn) {
+			conn.Close()
+			return ErrServerClosed
+		}
+
+		go s.serveConn(conn)
+	}
+}
+
+// Close closes the listeners and the connections, it waits for the commands
+// in progress. It implements io.Closer
+func (s *RESPServer) Close() error {
+	s.Lock()
+	s.closed = true
+
+	for l := range s.listeners {
+		l.Close()
+	}
+
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.Unlock()
+
+	s.wg.Wait()
+	return nil
+}
+
+func (s *RESPServer) isClosed() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.closed
+}
+
+// track adds the connection to the served ones, it returns false if the
+// server is closed. It is added to the wait group under the lock, so Close
+// waits for it
+func (s *RESPServer) track(conn net.Conn) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.closed {
+		return false
+	}
+
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+// serveConn reads the commands of the connection until it is closed. Replies
+// are buffered while there are pipelined commands to read. A panic closes only
+// the connection which caused it
+func (s *RESPServer) serveConn(conn net.Conn) {
+	defer func() {
+		recover()
+
+		s.Lock()
+		delete(s.conns, conn)
+		s.Unlock()
+
+		conn.Close()
+		s.wg.Done()
+	}()
+
+	r := bufio.NewReader(conn)
+	w := &respWriter{Writer: bufio.NewWriter(conn)}
+
+	for {
+		args, err := readRESPCommand(r)
+		if err != nil {
+			if perr, ok := err.(respProtocolError); ok {
+				w.error("ERR " + perr.Error())
+				w.Flush()
+			}
+
+			return
+		}
+
+		if len(args) == 0 {
+			continue
+		}
+
+		quit := s.exec(w, args)
+
+		if quit || r.Buffered() == 0 {
+			if err := w.Flush(); err != nil || quit {
+				return
+			}
+		}
+	}
+}
+
+// exec runs the command and writes its reply, it returns true if the
+// connection should be closed. Panics of the command are replied as
+// *PanicError and close the connection
+func (s *RESPServer) exec(w *respWriter, args []string) (quit bool) {
+	name := strings.ToLower(args[0])
+	args = args[1:]
+
+	defer func() {
+		if v := recover(); v != nil {
+			var key string
+			if len(args) > 0 {
+				key = args[0]
+			}
+
+			w.error("ERR " + (&PanicError{Op: name, Key: key, Value: v}).Error())
+			quit = true
+		}
+	}()
+
+	cmd, ok := respCommands[name]
+	if !ok {
+		w.error(fmt.Sprintf("ERR unknown command '%s'", name))
+		return false
+	}
+
+	if len(args) < cmd.minArgs || (cmd.maxArgs >= 0 && len(args) > cmd.maxArgs) {
+		w.error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+		return false
+	}
+
+	if name == "quit" {
+		w.simple("OK")
+		return true
+	}
+
+	cmd.run(s, w, args)
+	return false
+}
+
+// respCommand is a command of RESPServer, maxArgs is -1 for the commands
+// with any number of arguments
+type respCommand struct {
+	minArgs, maxArgs int
+	run              func(s *RESPServer, w *respWriter, args []string)
+}
+
+// respCommands holds the commands of RESPServer, indexed by lowercase name.
+// quit is handled by exec
+var respCommands = map[string]respCommand{
+	"ping":    {0, 1, (*RESPServer).ping},
+	"quit":    {0, 0, nil},
+	"select":  {1, 1, (*RESPServer).selectDB},
+	"get":     {1, 1, (*RESPServer).get},
+	"set":     {2, -1, (*RESPServer).set},
+	"del":     {1, -1, (*RESPServer).del},
+	"exists":  {1, -1, (*RESPServer).exists},
+	"incr":    {1, 1, (*RESPServer).incr},
+	"ttl":     {1, 1, (*RESPServer).ttl},
+	"expire":  {2, 2, (*RESPServer).expire},
+	"flushdb": {0, 1, (*RESPServer).flushDB},
+	"dbsize":  {0, 0, (*RESPServer).dbSize},
+	"scan":    {1, -1, (*RESPServer).scan},
+}
+
+func (s *RESPServer) ping(w *respWriter, args []string) {
+	if len(args) == 0 {
+		w.simple("PONG")
+		return
+	}
+
+	w.bulk([]byte(args[0]))
+}
+
+// selectDB accepts only the database 0, which is selected by some clients on
+// connect
+func (s *RESPServer) selectDB(w *respWriter, args []string) {
+	if args[0] != "0" {
+		w.error("ERR DB index is out of range")
+		return
+	}
+
+	w.simple("OK")
+}
+
+func (s *RESPServer) get(w *respWriter, args []string) {
+	value, err := s.cache.Get(args[0])
+	if IsNotFound(err) {
+		w.null()
+		return
+	}
+
+	if err != nil {
+		w.cacheError(err)
+		return
+	}
+
+	w.value(s.codec, value)
+}
+
+func (s *RESPServer) set(w *respWriter, args []string) {
+	key, value := args[0], args[1]
+
+	var (
+		ttl    time.Duration
+		nx, xx bool
+	)
+
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToLower(args[i]); {
+		case opt == "nx" && !xx:
+			nx = true
+		case opt == "xx" && !nx:
+			xx = true
+		case (opt == "ex" || opt == "px") && ttl == 0 && i+1 < len(args):
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				w.error("ERR value is not an integer or out of range")
+				return
+			}
+
+			unit := time.Second
+			if opt == "px" {
+				unit = time.Millisecond
+			}
+
+			if n <= 0 || n > int64(maxDuration/unit) {
+				w.error("ERR invalid expire time in 'set' command")
+				return
+			}
+
+			ttl = time.Duration(n) * unit
+			i++
+		default:
+			w.error("ERR syntax error")
+			return
+		}
+	}
+
+	var ec ExpiringCache
+	if ttl > 0 {
+		var ok bool
+		if ec, ok = s.cache.(ExpiringCache); !ok {
+			w.error("ERR cache does not support expiration")
+			return
+		}
+	}
+
+	// taken for every SET, so it does not interleave with the commands which
+	// read and then update the key
+	s.update.Lock()
+	defer s.update.Unlock()
+
+	if nx || xx {
+		exists, err := s.keyExists(key)
+		if err != nil {
+			w.cacheError(err)
+			return
+		}
+
+		if exists != xx {
+			w.null()
+			return
+		}
+	}
+
+	var err error
+	if ttl > 0 {
+		err = ec.SetEx(key, ttl, value)
+	} else {
+		err = s.cache.Set(key, value)
+	}
+
+	if err != nil {
+		w.cacheError(err)
+		return
+	}
+
+	w.simple("OK")
+}
+
+func (s *RESPServer) del(w *respWriter, args []string) {
+	s.update.Lock()
+	defer s.update.Unlock()
+
+	var n int64
+	for _, key := range args {
+		exists, err := s.keyExists(key)
+		if err != nil {
+			w.cacheError(err)
+			return
+		}
+
+		if !exists {
+			continue
+		}
+
+		if err := s.cache.Delete(key); err != nil {
+			w.cacheError(err)
+			return
+		}
+
+		n++
+	}
+
+	w.integer(n)
+}
+
+func (s *RESPServer) exists(w *respWriter, args []string) {
+	var n int64
+	for _, key := range args {
+		exists, err := s.keyExists(key)
+		if err != nil {
+			w.cacheError(err)
+			return
+		}
+
+		if exists {
+			n++
+		}
+	}
+
+	w.integer(n)
+}
+
+func (s *RESPServer) incr(w *respWriter, args []string) {
+	key := args[0]
+
+	if c, ok := s.cache.(Counter); ok {
+		n, err := c.Incr(key, 1)
+		if err != nil {
+			w.cacheError(err)
+			return
+		}
+
+		w.integer(n)
+		return
+	}
+
+	s.update.Lock()
+	defer s.update.Unlock()
+
+	var n int64
+
+	value, err := s.cache.Get(key)
+	switch {
+	case IsNotFound(err):
+		n = 1
+	case err != nil:
+		w.cacheError(err)
+		return
+	default:
+		if n, err = incrValue(value, 1); err != nil {
+			w.cacheError(err)
+			return
+		}
+	}
+
+	if err := s.cache.Set(key, n); err != nil {
+		w.cacheError(err)
+		return
+	}
+
+	w.integer(n)
+}
+
+func (s *RESPServer) ttl(w *respWriter, args []string) {
+	key := args[0]
+
+	tr, ok := s.cache.(TTLReader)
+	if !ok {
+		exists, err := s.keyExists(key)
+		if err != nil {
+			w.cacheError(err)
+			return
+		}
+
+		if exists {
+			w.integer(-1)
+		} else {
+			w.integer(-2)
+		}
+
+		return
+	}
+
+	ttl, err := tr.TTL(key)
+	switch {
+	case IsNotFound(err):
+		w.integer(-2)
+	case err != nil:
+		w.cacheError(err)
+	case ttl == 0:
+		w.integer(-1)
+	default:
+		// rounded as Redis does
+		w.integer(int64((ttl + time.Second/2) / time.Second))
+	}
+}
+
+func (s *RESPServer) expire(w *respWriter, args []string) {
+	key := args[0]
+
+	secs, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		w.error("ERR value is not an integer or out of range")
+		return
+	}
+
+	if secs > int64(maxDuration/time.Second) {
+		w.error("ERR invalid expire time in 'expire' command")
+		return
+	}
+
+	e, isExpirer := s.cache.(Expirer)
+	ec, ok := s.cache.(ExpiringCache)
+	if !isExpirer && !ok {
+		w.error("ERR cache does not support expiration")
+		return
+	}
+
+	s.update.Lock()
+	defer s.update.Unlock()
+
+	if isExpirer && secs > 0 {
+		err := e.Expire(key, time.Duration(secs)*time.Second)
+		if IsNotFound(err) {
+			w.integer(0)
+			return
+		}
+
+		if err != nil {
+			w.cacheError(err)
+			return
+		}
+
+		w.integer(1)
+		return
+	}
+
+	value, err := s.cache.Get(key)
+	if IsNotFound(err) {
+		w.integer(0)
+		return
+	}
+
+	if err != nil {
+		w.cacheError(err)
+		return
+	}
+
+	// keys expire immediately with a non positive ttl, as in Redis
+	if secs <= 0 {
+		err = s.cache.Delete(key)
+	} else {
+		err = ec.SetEx(key, time.Duration(secs)*time.Second, value)
+	}
+
+	if err != nil {
+		w.cacheError(err)
+		return
+	}
+
+	w.integer(1)
+}
+
+func (s *RESPServer) flushDB(w *respWriter, args []string) {
+	if len(args) == 1 {
+		if opt := strings.ToLower(args[0]); opt != "sync" && opt != "async" {
+			w.error("ERR syntax error")
+			return
+		}
+	}
+
+	err := deletePrefix(s.cache, "")
+	if err == errFlushNotSupported {
+		w.error("ERR cache does not support FLUSHDB")
+		return
+	}
+
+	if err != nil {
+		w.cacheError(err)
+		return
+	}
+
+	w.simple("OK")
+}
+
+func (s *RESPServer) dbSize(w *respWriter, args []string) {
+	lister, ok := listKeys(s.cache)
+	if !ok {
+		w.error("ERR cache does not support DBSIZE")
+		return
+	}
+
+	w.integer(int64(len(lister.Keys())))
+}
+
+// scan iterates over the sorted keys, the cursor is the index of the next
+// key. All the keys are listed and sorted for every page, it is not
+// incremental. Keys which are set or deleted during the iteration may be
+// skipped or returned twice
+func (s *RESPServer) scan(w *respWriter, args []string) {
+	lister, ok := listKeys(s.cache)
+	if !ok {
+		w.error("ERR cache does not support SCAN")
+		return
+	}
+
+	cursor, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		w.error("ERR invalid cursor")
+		return
+	}
+
+	pattern := "*"
+	count := defaultRESPScanCount
+
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			w.error("ERR syntax error")
+			return
+		}
+
+		switch strings.ToLower(args[i]) {
+		case "match":
+			pattern = args[i+1]
+		case "count":
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				w.error("ERR value is not an integer or out of range")
+				return
+			}
+
+			if n < 1 {
+				w.error("ERR syntax error")
+				return
+			}
+
+			count = n
+		default:
+			w.error("ERR syntax error")
+			return
+		}
+	}
+
+	keys := lister.Keys()
+	sort.Strings(keys)
+
+	var matched []string
+
+	i := cursor
+	for ; i < uint64(len(keys)) && i < cursor+uint64(count); i++ {
+		if globMatch(pattern, keys[i]) {
+			matched = append(matched, keys[i])
+		}
+	}
+
+	if i >= uint64(len(keys)) {
+		i = 0
+	}
+
+	w.array(2)
+	w.bulk([]byte(strconv.FormatUint(i, 10)))
+	w.array(len(matched))
+	for _, key := range matched {
+		w.bulk([]byte(key))
+	}
+}
+
+// keyExists reports whether the key exists
+func (s *RESPServer) keyExists(key string) (bool, error) {
+	_, err := s.cache.Get(key)
+	if IsNotFound(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// readRESPCommand reads a command, which is either an array of bulk strings
+// or an inline command separated by spaces
+func readRESPCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readRESPLine(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) == 0 || line[0] != '*' {
+		return strings.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > maxRESPArgs {
+		return nil, respProtocolError("invalid multibulk length")
+	}
+
+	// empty and null multibulks are skipped, as in Redis
+	if n <= 0 {
+		return nil, nil
+	}
+
+	// the arguments are appended as they are read, the claimed count is not
+	// allocated up front
+	args := make([]string, 0, min(n, respPrealloc/16))
+	for i := 0; i < n; i++ {
+		line, err := readRESPLine(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(line) == 0 || line[0] != '$' {
+			return nil, respProtocolError(fmt.Sprintf("expected '$', got '%.1s'", line))
+		}
+
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > maxRESPBulkLen {
+			return nil, respProtocolError("invalid bulk length")
+		}
+
+		// the buffer grows as the bytes are read, so the claimed size is
+		// not allocated before it is sent
+		var b bytes.Buffer
+		b.Grow(min(size+2, respPrealloc))
+		if _, err := io.CopyN(&b, r, int64(size+2)); err != nil {
+			return nil, err
+		}
+
+		buf := b.Bytes()
+		if buf[size] != '\r' || buf[size+1] != '\n' {
+			return nil, respProtocolError("bulk string is not terminated by CRLF")
+		}
+
+		args = append(args, string(buf[:size]))
+	}
+
+	return args, nil
+}
+
+// readRESPLine reads a line without its line ending
+func readRESPLine(r *bufio.Reader) (string, error) {
+	var line []byte
+
+	for {
+		part, isPrefix, err := r.ReadLine()
+		if err != nil {
+			return "", err
+		}
+
+		line = append(line, part...)
+		if len(line) > maxRESPInlineLen {
+			return "", respProtocolError("too big inline request")
+		}
+
+		if !isPrefix {
+			return string(line), nil
+		}
+	}
+}
+
+// respWriter writes the RESP2 replies
+type respWriter struct {
+	*bufio.Writer
+}
+
+func (w *respWriter) simple(s string) {
+	w.WriteString("+" + s + "\r\n")
+}
+
+func (w *respWriter) error(s string) {
+	// simple strings can not contain line endings
+	s = strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+	w.WriteString("-" + s + "\r\n")
+}
+
+// cacheError writes the error of the cache
+func (w *respWriter) cacheError(err error) {
+	w.error("ERR " + err.Error())
+}
+
+func (w *respWriter) integer(n int64) {
+	w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func (w *respWriter) bulk(b []byte) {
+	w.WriteString("$" + strconv.Itoa(len(b)) + "\r\n")
+	w.Write(b)
+	w.WriteString("\r\n")
+}
+
+func (w *respWriter) null() {
+	w.WriteString("$-1\r\n")
+}
+
+func (w *respWriter) array(n int) {
+	w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
+
+// value writes the value of a key as a bulk string
+func (w *respWriter) value(codec Codec, value interface{}) {
+	var data []byte
+
+	switch v := value.(type) {
+	case int64:
+		data = []byte(strconv.FormatInt(v, 10))
+	case int:
+		data = []byte(strconv.Itoa(v))
+	default:
+		var err error
+		if data, _, err = encodeValue(codec, value); err != nil {
+			w.cacheError(err)
+			return
+		}
+	}
+
+	w.bulk(data)
+}
+
+// globMatch reports whether the string matches the glob-style pattern of
+// Redis, which supports *, ?, [abc], [^abc], [a-z] and \ escapes. Only the
+// last * is backtracked, so matching takes O(len(pattern)*len(s)) time
+func globMatch(pattern, s string) bool {
+	var p, i int
+
+	// star is the position after the last * of the pattern and next is the
+	// position of the string it is retried from, star is -1 before any *
+	star, next := -1, 0
+
+	for i < len(s) {
+		if p < len(pattern) {
+			if pattern[p] == '*' {
+				for p < len(pattern) && pattern[p] == '*' {
+					p++
+				}
+
+				star, next = p, i
+				continue
+			}
+
+			if n, ok := matchGlobByte(pattern[p:], s[i]); ok {
+				p += n
+				i++
+				continue
+			}
+		}
+
+		if star < 0 {
+			return false
+		}
+
+		// the last * takes one more byte
+		next++
+		p, i = star, next
+	}
+
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+
+	return p == len(pattern)
+}
+
+// matchGlobByte matches the byte with the first element of the pattern, which
+// is not *. It returns the length of the element
+func matchGlobByte(pattern string, c byte) (int, bool) {
+	switch pattern[0] {
+	case '?':
+		return 1, true
+	case '[':
+		end := strings.IndexByte(pattern[1:], ']')
+		if end < 0 {
+			// unterminated class matches the literal '['
+			return 1, c == '['
+		}
+
+		return end + 2, matchClass(pattern[1:end+1], c)
+	case '\\':
+		if len(pattern) > 1 {
+			return 2, c == pattern[1]
+		}
+	}
+
+	return 1, c == pattern[0]
+}
+
+// matchClass reports whether the byte is in the character class of a glob
+// pattern, without its brackets
+func matchClass(class string, c byte) bool {
+	negate := len(class) > 0 && class[0] == '^'
+	if negate {
+		class = class[1:]
+	}
+
+	match := false
+	for i := 0; i < len(class); i++ {
+		if i+2 < len(class) && class[i+1] == '-' {
+			lo, hi := class[i], class[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+
+			if lo <= c && c <= hi {
+				match = true
+			}
+
+			i += 2
+			continue
+		}
+
+		if class[i] == c {
+			match = true
+		}
+	}
+
+	return match != negate
+}
@@ -0,0 +1,502 @@
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/koding/cache/clock/clocktest"
+)
+
+// respTestError is an error reply of the server
+type respTestError string
+
+// respTestClient is a minimal RESP2 client for the tests
+type respTestClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// serveRESP serves the server on a local port and returns its address
+func serveRESP(t *testing.T, s *RESPServer) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(l) }()
+
+	t.Cleanup(func() {
+		s.Close()
+		if err := <-done; err != ErrServerClosed {
+			t.Errorf("Serve should return ErrServerClosed, got %v", err)
+		}
+	})
+
+	return l.Addr().String()
+}
+
+func dialRESP(t *testing.T, addr string) *respTestClient {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return &respTestClient{conn: conn, r: bufio.NewReader(conn)}
+}
+
+func encodeRESPCommand(args ...string) string {
+	s := "*" + strconv.Itoa(len(args)) + "\r\n"
+	for _, arg := range args {
+		s += "$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n"
+	}
+
+	return s
+}
+
+func (c *respTestClient) write(t *testing.T, s string) {
+	if _, err := io.WriteString(c.conn, s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *respTestClient) do(t *testing.T, args ...string) interface{} {
+	c.write(t, encodeRESPCommand(args...))
+	return c.reply(t)
+}
+
+// reply reads a reply; simple and bulk strings are returned as string, nil
+// bulk strings as nil, integers as int64 and arrays as []interface{}
+func (c *respTestClient) reply(t *testing.T) interface{} {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	line = strings.TrimSuffix(line, "\r\n")
+
+	switch line[0] {
+	case '+':
+		return line[1:]
+	case '-':
+		return respTestError(line[1:])
+	case ':':
+		n, _ := strconv.ParseInt(line[1:], 10, 64)
+		return n
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return nil
+		}
+
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			t.Fatal(err)
+		}
+
+		return string(buf[:n])
+	case '*':
+		n, _ := strconv.Atoi(line[1:])
+
+		items := make([]interface{}, n)
+		for i := range items {
+			items[i] = c.reply(t)
+		}
+
+		return items
+	}
+
+	t.Fatalf("invalid reply %q", line)
+	return nil
+}
+
+func expectReply(t *testing.T, got, want interface{}) {
+	t.Helper()
+
+	if fmt.Sprintf("%#v", got) != fmt.Sprintf("%#v", want) {
+		t.Fatalf("reply should be %#v, got %#v", want, got)
+	}
+}
+
+func expectErrorReply(t *testing.T, got interface{}, prefix string) {
+	t.Helper()
+
+	e, ok := got.(respTestError)
+	if !ok || !strings.HasPrefix(string(e), prefix) {
+		t.Fatalf("reply should be an error starting with %q, got %#v", prefix, got)
+	}
+}
+
+func TestRESPServerGetSet(t *testing.T) {
+	cache := NewMemory()
+	c := dialRESP(t, serveRESP(t, NewRESPServer(cache)))
+
+	expectReply(t, c.do(t, "PING"), "PONG")
+	expectReply(t, c.do(t, "SET", "test_key", "test_data"), "OK")
+	expectReply(t, c.do(t, "get", "test_key"), "test_data")
+	expectReply(t, c.do(t, "GET", "missing"), nil)
+	expectReply(t, c.do(t, "EXISTS", "test_key", "missing", "test_key"), int64(2))
+	expectReply(t, c.do(t, "DEL", "test_key", "missing"), int64(1))
+	expectReply(t, c.do(t, "GET", "test_key"), nil)
+
+	// values set by Go are encoded
+	cache.Set("number", 42)
+	cache.Set("struct", struct{ Name string }{"koding"})
+	expectReply(t, c.do(t, "GET", "number"), "42")
+	expectReply(t, c.do(t, "GET", "struct"), `{"Name":"koding"}`)
+
+	// values set with RESP are strings
+	c.do(t, "SET", "test_key", "test_data")
+	if v, _ := cache.Get("test_key"); v != "test_data" {
+		t.Fatalf("value should be set as a string, got %#v", v)
+	}
+
+	expectErrorReply(t, c.do(t, "GET"), "ERR wrong number of arguments for 'get'")
+	expectErrorReply(t, c.do(t, "HGET", "k", "f"), "ERR unknown command 'hget'")
+}
+
+func TestRESPServerSetOptions(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	c := dialRESP(t, serveRESP(t, NewRESPServer(NewMemoryWithTTL(0, WithClock(clock)))))
+
+	expectReply(t, c.do(t, "SET", "ex", "v", "EX", "10"), "OK")
+	expectReply(t, c.do(t, "TTL", "ex"), int64(10))
+
+	expectReply(t, c.do(t, "SET", "px", "v", "px", "1500"), "OK")
+	expectReply(t, c.do(t, "TTL", "px"), int64(2))
+
+	expectReply(t, c.do(t, "SET", "ex", "other", "NX"), nil)
+	expectReply(t, c.do(t, "GET", "ex"), "v")
+	expectReply(t, c.do(t, "SET", "missing", "v", "XX"), nil)
+	expectReply(t, c.do(t, "GET", "missing"), nil)
+	expectReply(t, c.do(t, "SET", "ex", "other", "XX", "EX", "5"), "OK")
+	expectReply(t, c.do(t, "GET", "ex"), "other")
+	expectReply(t, c.do(t, "SET", "nx", "v", "NX"), "OK")
+
+	clock.Advance(6 * time.Second)
+
+	expectReply(t, c.do(t, "GET", "ex"), nil)
+	expectReply(t, c.do(t, "TTL", "ex"), int64(-2))
+	expectReply(t, c.do(t, "TTL", "nx"), int64(-1))
+
+	expectErrorReply(t, c.do(t, "SET", "k", "v", "NX", "XX"), "ERR syntax error")
+	expectErrorReply(t, c.do(t, "SET", "k", "v", "EX"), "ERR syntax error")
+	expectErrorReply(t, c.do(t, "SET", "k", "v", "EX", "0"), "ERR invalid expire time")
+	expectErrorReply(t, c.do(t, "SET", "k", "v", "EX", "ten"), "ERR value is not an integer")
+}
+
+func TestRESPServerExpire(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	c := dialRESP(t, serveRESP(t, NewRESPServer(NewMemoryWithTTL(0, WithClock(clock)))))
+
+	c.do(t, "SET", "test_key", "test_data")
+	expectReply(t, c.do(t, "TTL", "test_key"), int64(-1))
+	expectReply(t, c.do(t, "EXPIRE", "test_key", "10"), int64(1))
+	expectReply(t, c.do(t, "TTL", "test_key"), int64(10))
+	expectReply(t, c.do(t, "EXPIRE", "missing", "10"), int64(0))
+
+	clock.Advance(11 * time.Second)
+	expectReply(t, c.do(t, "EXISTS", "test_key"), int64(0))
+
+	c.do(t, "SET", "test_key", "test_data")
+	expectReply(t, c.do(t, "EXPIRE", "test_key", "0"), int64(1))
+	expectReply(t, c.do(t, "EXISTS", "test_key"), int64(0))
+}
+
+func TestRESPServerExpireKeepsValue(t *testing.T) {
+	cache := NewMemoryWithTTL(0)
+	c := dialRESP(t, serveRESP(t, NewRESPServer(cache)))
+
+	// EXPIRE changes only the ttl, the value set by Go is not encoded again
+	cache.Set("number", 42)
+	expectReply(t, c.do(t, "EXPIRE", "number", "10"), int64(1))
+	expectReply(t, c.do(t, "TTL", "number"), int64(10))
+
+	if v, _ := cache.Get("number"); v != 42 {
+		t.Fatalf("value should be kept, got %#v", v)
+	}
+}
+
+func TestRESPServerWithoutCapabilities(t *testing.T) {
+	// embedding hides the optional interfaces of the cache
+	c := dialRESP(t, serveRESP(t, NewRESPServer(struct{ Cache }{NewLRU(10)})))
+
+	c.do(t, "SET", "test_key", "test_data")
+	expectReply(t, c.do(t, "TTL", "test_key"), int64(-1))
+	expectReply(t, c.do(t, "TTL", "missing"), int64(-2))
+
+	expectErrorReply(t, c.do(t, "SET", "k", "v", "EX", "10"), "ERR cache does not support expiration")
+	expectErrorReply(t, c.do(t, "EXPIRE", "test_key", "10"), "ERR cache does not support expiration")
+	expectErrorReply(t, c.do(t, "FLUSHDB"), "ERR cache does not support FLUSHDB")
+	expectErrorReply(t, c.do(t, "DBSIZE"), "ERR cache does not support DBSIZE")
+	expectErrorReply(t, c.do(t, "SCAN", "0"), "ERR cache does not support SCAN")
+}
+
+func TestRESPServerKeysNotSupported(t *testing.T) {
+	// MemoryTTL is a KeyLister, its underlying cache is not
+	cache := NewCacheWithTTL(0, func() Cache { return struct{ Cache }{NewMemoryNoTS()} })
+	c := dialRESP(t, serveRESP(t, NewRESPServer(cache)))
+
+	c.do(t, "SET", "test_key", "test_data")
+
+	expectErrorReply(t, c.do(t, "FLUSHDB"), "ERR cache does not support FLUSHDB")
+	expectErrorReply(t, c.do(t, "DBSIZE"), "ERR cache does not support DBSIZE")
+	expectErrorReply(t, c.do(t, "SCAN", "0"), "ERR cache does not support SCAN")
+	expectReply(t, c.do(t, "GET", "test_key"), "test_data")
+
+	c = dialRESP(t, serveRESP(t, NewRESPServer(NewLRU(10))))
+
+	c.do(t, "SET", "test_key", "test_data")
+	expectReply(t, c.do(t, "DBSIZE"), int64(1))
+	expectReply(t, c.do(t, "FLUSHDB"), "OK")
+	expectReply(t, c.do(t, "DBSIZE"), int64(0))
+}
+
+func TestRESPServerIncr(t *testing.T) {
+	// MemoryTTL implements Counter, Memory is served with Get and Set
+	caches := map[string]Cache{
+		"Counter": NewMemoryWithTTL(0),
+		"Memory":  NewMemory(),
+	}
+
+	for name, cache := range caches {
+		t.Run(name, func(t *testing.T) {
+			addr := serveRESP(t, NewRESPServer(cache))
+
+			var wg sync.WaitGroup
+			for i := 0; i < 4; i++ {
+				c := dialRESP(t, addr)
+
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+
+					for j := 0; j < 50; j++ {
+						if _, err := io.WriteString(c.conn, encodeRESPCommand("INCR", "counter")); err != nil {
+							t.Error(err)
+							return
+						}
+
+						line, err := c.r.ReadString('\n')
+						if err != nil || line[0] != ':' {
+							t.Errorf("INCR should reply an integer, got %q, %v", line, err)
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+
+			c := dialRESP(t, addr)
+			expectReply(t, c.do(t, "GET", "counter"), "200")
+
+			c.do(t, "SET", "word", "test_data")
+			expectErrorReply(t, c.do(t, "INCR", "word"), "ERR value is not an integer")
+
+			c.do(t, "SET", "text", "41")
+			expectReply(t, c.do(t, "INCR", "text"), int64(42))
+		})
+	}
+}
+
+func TestRESPServerKeys(t *testing.T) {
+	c := dialRESP(t, serveRESP(t, NewRESPServer(NewMemoryWithTTL(0))))
+
+	for i := 0; i < 25; i++ {
+		c.do(t, "SET", fmt.Sprintf("user:%02d", i), "v")
+	}
+	c.do(t, "SET", "session:1", "v")
+
+	expectReply(t, c.do(t, "DBSIZE"), int64(26))
+
+	scan := func(args ...string) []string {
+		var keys []string
+
+		cursor := "0"
+		for {
+			reply := c.do(t, append([]string{"SCAN", cursor}, args...)...).([]interface{})
+			for _, key := range reply[1].([]interface{}) {
+				keys = append(keys, key.(string))
+			}
+
+			if cursor = reply[0].(string); cursor == "0" {
+				break
+			}
+		}
+
+		sort.Strings(keys)
+		return keys
+	}
+
+	if keys := scan("COUNT", "7"); len(keys) != 26 {
+		t.Fatalf("scan should return all the keys, got %d", len(keys))
+	}
+
+	keys := scan("MATCH", "user:1?")
+	if len(keys) != 10 || keys[0] != "user:10" || keys[9] != "user:19" {
+		t.Fatalf("scan should return the matched keys, got %v", keys)
+	}
+
+	expectErrorReply(t, c.do(t, "SCAN", "x"), "ERR invalid cursor")
+	expectErrorReply(t, c.do(t, "SCAN", "0", "COUNT"), "ERR syntax error")
+
+	expectReply(t, c.do(t, "FLUSHDB"), "OK")
+	expectReply(t, c.do(t, "DBSIZE"), int64(0))
+}
+
+func TestRESPServerPipelining(t *testing.T) {
+	c := dialRESP(t, serveRESP(t, NewRESPServer(NewMemory())))
+
+	// all commands are sent before reading any reply
+	c.write(t, encodeRESPCommand("SET", "test_key", "test_data")+
+		encodeRESPCommand("GET", "test_key")+
+		encodeRESPCommand("DEL", "test_key")+
+		encodeRESPCommand("GET", "test_key"))
+
+	expectReply(t, c.reply(t), "OK")
+	expectReply(t, c.reply(t), "test_data")
+	expectReply(t, c.reply(t), int64(1))
+	expectReply(t, c.reply(t), nil)
+}
+
+func TestRESPServerInline(t *testing.T) {
+	c := dialRESP(t, serveRESP(t, NewRESPServer(NewMemory())))
+
+	c.write(t, "PING\r\nSET test_key test_data\r\n\r\nGET test_key\n")
+
+	expectReply(t, c.reply(t), "PONG")
+	expectReply(t, c.reply(t), "OK")
+	expectReply(t, c.reply(t), "test_data")
+
+	c.write(t, "QUIT\r\n")
+	expectReply(t, c.reply(t), "OK")
+
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		t.Fatalf("connection should be closed after QUIT, got %v", err)
+	}
+}
+
+func TestRESPServerProtocolError(t *testing.T) {
+	c := dialRESP(t, serveRESP(t, NewRESPServer(NewMemory())))
+
+	c.write(t, "*-1\r\n*0\r\n")
+	expectReply(t, c.do(t, "PING"), "PONG")
+
+	c.write(t, "*1\r\n:1\r\n")
+	expectErrorReply(t, c.reply(t), "ERR Protocol error")
+
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		t.Fatalf("connection should be closed after a protocol error, got %v", err)
+	}
+}
+
+func TestReadRESPCommandEmpty(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("*-1\r\n*0\r\n*1\r\n$4\r\nPING\r\n"))
+
+	for _, line := range []string{"*-1", "*0"} {
+		args, err := readRESPCommand(r)
+		if err != nil || len(args) != 0 {
+			t.Fatalf("%s should be an empty command, got %v, %v", line, args, err)
+		}
+	}
+
+	if args, err := readRESPCommand(r); err != nil || len(args) != 1 || args[0] != "PING" {
+		t.Fatalf("command after the empty ones should be read, got %v, %v", args, err)
+	}
+}
+
+func TestRESPServerPanic(t *testing.T) {
+	addr := serveRESP(t, NewRESPServer(&panickingCache{NewMemory()}))
+	c := dialRESP(t, addr)
+
+	expectErrorReply(t, c.do(t, "SET", "test_key", "test_data"), "ERR cache: panic while set")
+
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		t.Fatalf("connection should be closed after a panic, got %v", err)
+	}
+
+	// other connections are still served
+	expectReply(t, dialRESP(t, addr).do(t, "PING"), "PONG")
+}
+
+func TestReadRESPCommandClaimedSize(t *testing.T) {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	// the claimed sizes are not sent
+	r := bufio.NewReader(strings.NewReader("*1048576\r\n$536870000\r\ntest_data"))
+	_, err := readRESPCommand(r)
+
+	runtime.ReadMemStats(&after)
+
+	if err == nil {
+		t.Fatal("truncated command should give err")
+	}
+
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+		t.Fatalf("claimed sizes should not be allocated, allocated %d bytes", n)
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		match      bool
+	}{
+		{"*", "", true},
+		{"*", "anything", true},
+		{"user:*", "user:1", true},
+		{"user:*", "session:1", false},
+		{"*:1", "user:1", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{"a*b*c", "aXbYc", true},
+		{"a*b*c", "aXbY", false},
+		{"a*", "a", true},
+		{"*a", "ba", true},
+		{"*a", "ab", false},
+		{"**b", "aab", true},
+		{"[abc", "[abc", true},
+		{"[abc", "abc", false},
+		{`a\`, `a\`, true},
+		{"?", "", false},
+	}
+
+	for _, test := range tests {
+		if got := globMatch(test.pattern, test.s); got != test.match {
+			t.Errorf("globMatch(%q, %q) should be %t", test.pattern, test.s, test.match)
+		}
+	}
+}
+
+func TestGlobMatchBacktracking(t *testing.T) {
+	done := make(chan bool)
+	go func() { done <- globMatch("*a*a*a*a*a*a*a*b", strings.Repeat("a", 1000)) }()
+
+	select {
+	case match := <-done:
+		if match {
+			t.Fatal("pattern should not match")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("matching should not take exponential time")
+	}
+}
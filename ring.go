@@ -0,0 +1,297 @@
+package cache
+
+import (
+	"errors"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const (
+	defaultVirtualNodes = 160
+	defaultReplicas     = 1
+)
+
+// ErrNoNodes is returned by Ring when it has no nodes
+var ErrNoNodes = errors.New("ring has no nodes")
+
+// Ring is a Cache which spreads the keys across named cache nodes with
+// consistent hashing. Every node is placed on a hash ring as virtual nodes in
+// proportion to its weight, a key is stored on the first node found clockwise
+// from the hash of the key. Adding or removing a node only moves the keys of
+// that node.
+//
+// Keys can be replicated to multiple nodes, they are written to all of their
+// replicas and read from the first replica which has them
+type Ring struct {
+	// RWMutex guards nodes and points
+	sync.RWMutex
+
+	// vnodes is the virtual node count of a node with weight 1
+	vnodes int
+
+	// replicas is the number of nodes a key is stored on
+	replicas int
+
+	// nodes holds the caches of the nodes, indexed by name
+	nodes map[string]*ringNode
+
+	// points holds the virtual nodes sorted by their hashes
+	points []ringPoint
+}
+
+// ringNode is a named cache node
+type ringNode struct {
+	name   string
+	cache  Cache
+	weight int
+}
+
+// ringPoint is a virtual node on the ring
+type ringPoint struct {
+	hash uint64
+	node *ringNode
+}
+
+// RingOption sets the options specified for Ring.
+type RingOption func(*Ring)
+
+// WithReplicas sets the number of nodes a key is stored on, default is 1. If
+// there are fewer nodes, keys are stored on all of them
+func WithReplicas(n int) RingOption {
+	if n <= 0 {
+		panic("invalid replica count")
+	}
+
+	return func(r *Ring) {
+		r.replicas = n
+	}
+}
+
+// WithVirtualNodes sets the virtual node count of a node with weight 1,
+// default is 160. More virtual nodes spread the keys more evenly
+func WithVirtualNodes(n int) RingOption {
+	if n <= 0 {
+		panic("invalid virtual node count")
+	}
+
+	return func(r *Ring) {
+		r.vnodes = n
+	}
+}
+
+// NewRing creates an empty ring, nodes are added with AddNode
+// usage:
+// r := NewRing(WithReplicas(2))
+// r.AddNode("mongo-1", mongoCache1, 1)
+// r.AddNode("mongo-2", mongoCache2, 2)
+func NewRing(opts ...RingOption) *Ring {
+	r := &Ring{
+		vnodes:   defaultVirtualNodes,
+		replicas: defaultReplicas,
+		nodes:    make(map[string]*ringNode),
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// AddNode adds a cache node with the given weight, a node with twice the
+// weight gets twice the keys. Adding an existing node replaces it
+func (r *Ring) AddNode(name string, c Cache, weight int) {
+	if weight <= 0 {
+		panic("invalid node weight")
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.nodes[name] = &ringNode{name: name, cache: c, weight: weight}
+	r.rebuild()
+}
+
+// RemoveNode removes the node, its keys are moved to the next nodes on the
+// ring. Keys are not copied, so they are missed until they are set again
+func (r *Ring) RemoveNode(name string) {
+	r.Lock()
+	defer r.Unlock()
+
+	delete(r.nodes, name)
+	r.rebuild()
+}
+
+// Nodes returns the names of the nodes in sorted order
+func (r *Ring) Nodes() []string {
+	r.RLock()
+	defer r.RUnlock()
+
+	names := make([]string, 0, len(r.nodes))
+	for name := range r.nodes {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// NodesFor returns the names of the nodes the key is stored on, in the order
+// they are read
+func (r *Ring) NodesFor(key string) []string {
+	r.RLock()
+	defer r.RUnlock()
+
+	nodes := r.lookup(key)
+
+	names := make([]string, len(nodes))
+	for i, node := range nodes {
+		names[i] = node.name
+	}
+
+	return names
+}
+
+// Get returns the value of the key from the first replica which has it, the
+// next replica is tried if a replica misses the key or fails. ErrNotFound is
+// returned if a replica misses it, the last error otherwise
+func (r *Ring) Get(key string) (interface{}, error) {
+	nodes := r.nodesFor(key)
+	if len(nodes) == 0 {
+		return nil, ErrNoNodes
+	}
+
+	var lastErr error
+	notFound := false
+
+	for _, node := range nodes {
+		value, err := node.cache.Get(key)
+		if err == nil {
+			return value, nil
+		}
+
+		if err == ErrNotFound {
+			notFound = true
+			continue
+		}
+
+		lastErr = err
+	}
+
+	if notFound {
+		return nil, ErrNotFound
+	}
+
+	return nil, lastErr
+}
+
+// Set sets the value to all replicas of the key, it returns the first error
+// after trying all of them
+func (r *Ring) Set(key string, value interface{}) error {
+	return r.each(key, func(c Cache) error {
+		return c.Set(key, value)
+	})
+}
+
+// Delete deletes the key from all replicas of the key, it returns the first
+// error after trying all of them
+func (r *Ring) Delete(key string) error {
+	return r.each(key, func(c Cache) error {
+		return c.Delete(key)
+	})
+}
+
+// each calls f for the caches of all replicas of the key
+func (r *Ring) each(key string, f func(c Cache) error) error {
+	nodes := r.nodesFor(key)
+	if len(nodes) == 0 {
+		return ErrNoNodes
+	}
+
+	var firstErr error
+	for _, node := range nodes {
+		if err := f(node.cache); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// nodesFor returns the replica nodes of the key
+func (r *Ring) nodesFor(key string) []*ringNode {
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.lookup(key)
+}
+
+// lookup walks the ring clockwise from the hash of the key and returns the
+// first distinct nodes, up to the replica count. It must be called with the
+// lock held
+func (r *Ring) lookup(key string) []*ringNode {
+	if len(r.points) == 0 {
+		return nil
+	}
+
+	n := r.replicas
+	if n > len(r.nodes) {
+		n = len(r.nodes)
+	}
+
+	h := ringHash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= h })
+
+	nodes := make([]*ringNode, 0, n)
+	for j := 0; j < len(r.points) && len(nodes) < n; j++ {
+		node := r.points[(i+j)%len(r.points)].node
+		if !containsNode(nodes, node) {
+			nodes = append(nodes, node)
+		}
+	}
+
+	return nodes
+}
+
+// rebuild places the virtual nodes of all nodes on the ring. It must be
+// called with the lock held
+func (r *Ring) rebuild() {
+	r.points = r.points[:0]
+
+	for _, node := range r.nodes {
+		for i := 0; i < node.weight*r.vnodes; i++ {
+			r.points = append(r.points, ringPoint{
+				hash: ringHash(node.name + "#" + strconv.Itoa(i)),
+				node: node,
+			})
+		}
+	}
+
+	// ties are broken by name, so the ring does not depend on the map order
+	sort.Slice(r.points, func(i, j int) bool {
+		if r.points[i].hash != r.points[j].hash {
+			return r.points[i].hash < r.points[j].hash
+		}
+
+		return r.points[i].node.name < r.points[j].node.name
+	})
+}
+
+// ringHash returns the position of the key on the ring
+func ringHash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return mix(h.Sum64())
+}
+
+func containsNode(nodes []*ringNode, node *ringNode) bool {
+	for _, n := range nodes {
+		if n == node {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,149 @@
+package cache
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func newTestRing(opts ...RingOption) *Ring {
+	r := NewRing(opts...)
+	r.AddNode("node1", NewMemory(), 1)
+	r.AddNode("node2", NewMemory(), 1)
+	r.AddNode("node3", NewMemory(), 1)
+	return r
+}
+
+func TestRingGetSet(t *testing.T) {
+	testCacheGetSet(t, newTestRing())
+}
+
+func TestRingNilValue(t *testing.T) {
+	testCacheNilValue(t, newTestRing())
+}
+
+func TestRingDelete(t *testing.T) {
+	testCacheDelete(t, newTestRing(WithReplicas(2)))
+}
+
+func TestRingNoNodes(t *testing.T) {
+	r := NewRing()
+
+	if _, err := r.Get("test_key"); err != ErrNoNodes {
+		t.Fatalf("error should equal to %q, got: %v", ErrNoNodes, err)
+	}
+
+	if err := r.Set("test_key", "test_data"); err != ErrNoNodes {
+		t.Fatalf("error should equal to %q, got: %v", ErrNoNodes, err)
+	}
+}
+
+func TestRingWeights(t *testing.T) {
+	r := NewRing()
+	r.AddNode("node1", NewMemory(), 1)
+	r.AddNode("node2", NewMemory(), 3)
+
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		counts[r.NodesFor("key" + strconv.Itoa(i))[0]]++
+	}
+
+	// node2 should get ~75% of the keys
+	if counts["node2"] < 7000 || counts["node2"] > 8000 {
+		t.Fatalf("keys should be spread by weight, got: %v", counts)
+	}
+}
+
+func TestRingAddRemoveNode(t *testing.T) {
+	r := newTestRing()
+
+	before := make(map[string]string)
+	for i := 0; i < 10000; i++ {
+		key := "key" + strconv.Itoa(i)
+		before[key] = r.NodesFor(key)[0]
+	}
+
+	r.AddNode("node4", NewMemory(), 1)
+
+	moved := 0
+	for key, node := range before {
+		after := r.NodesFor(key)[0]
+		if after == node {
+			continue
+		}
+
+		if after != "node4" {
+			t.Fatalf("%s should only move to the new node, moved to: %s", key, after)
+		}
+
+		moved++
+	}
+
+	// node4 should get ~25% of the keys
+	if moved < 2000 || moved > 3000 {
+		t.Fatalf("a quarter of the keys should move, got: %d", moved)
+	}
+
+	r.RemoveNode("node4")
+	for key, node := range before {
+		if after := r.NodesFor(key)[0]; after != node {
+			t.Fatalf("%s should move back to %s, got: %s", key, node, after)
+		}
+	}
+
+	if nodes := r.Nodes(); len(nodes) != 3 || nodes[0] != "node1" {
+		t.Fatalf("nodes should be node1, node2 and node3, got: %v", nodes)
+	}
+}
+
+// failingCache fails all the operations
+type failingCache struct{}
+
+var errFailingCache = errors.New("node is down")
+
+func (failingCache) Get(string) (interface{}, error) { return nil, errFailingCache }
+func (failingCache) Set(string, interface{}) error   { return errFailingCache }
+func (failingCache) Delete(string) error             { return errFailingCache }
+
+func TestRingReplicas(t *testing.T) {
+	nodes := map[string]Cache{
+		"node1": NewMemory(),
+		"node2": NewMemory(),
+		"node3": NewMemory(),
+	}
+
+	r := NewRing(WithReplicas(2))
+	for name, c := range nodes {
+		r.AddNode(name, c, 1)
+	}
+
+	if err := r.Set("test_key", "test_data"); err != nil {
+		t.Fatal(err)
+	}
+
+	replicas := r.NodesFor("test_key")
+	if len(replicas) != 2 || replicas[0] == replicas[1] {
+		t.Fatalf("key should be stored on 2 distinct nodes, got: %v", replicas)
+	}
+
+	// read falls back to the second replica
+	nodes[replicas[0]].Delete("test_key")
+	if data, err := r.Get("test_key"); err != nil || data != "test_data" {
+		t.Fatalf("data should equal to test_data, got: %v, %v", data, err)
+	}
+
+	// and to the second replica when the first one is down
+	r.AddNode(replicas[0], failingCache{}, 1)
+	if data, err := r.Get("test_key"); err != nil || data != "test_data" {
+		t.Fatalf("data should equal to test_data, got: %v, %v", data, err)
+	}
+
+	if err := r.Set("test_key", "test_data"); err != errFailingCache {
+		t.Fatalf("error should equal to %q, got: %v", errFailingCache, err)
+	}
+
+	nodes[replicas[1]].Delete("test_key")
+	if _, err := r.Get("test_key"); err != ErrNotFound {
+		t.Fatalf("error should equal to %q, got: %v", ErrNotFound, err)
+	}
+}
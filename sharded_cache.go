@@ -1,5 +1,7 @@
 package cache
 
+import "time"
+
 // ShardedCache is the contract for all of the sharded cache backends that are supported by
 // this package
 type ShardedCache interface {
@@ -16,3 +18,11 @@ type ShardedCache interface {
 	// Deletes all items in that shard
 	DeleteShard(shardID string) error
 }
+
+// ExpiringShardedCache is implemented by the sharded cache backends which can
+// set a key with its own ttl
+type ExpiringShardedCache interface {
+	// SetEx sets a single item to the shard, it expires after the given
+	// duration
+	SetEx(shardID, key string, duration time.Duration, value interface{}) error
+}
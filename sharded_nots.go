@@ -1,21 +1,79 @@
 package cache
 
+import (
+	"container/list"
+	"sort"
+)
+
 // ShardedNoTS ; the concept behind this storage is that each cache entry is
 // associated with a tenantID and this enables fast purging for just that
 // tenantID
 type ShardedNoTS struct {
 	cache       map[string]Cache
 	itemCount   map[string]int
-	constructor func() Cache
+	constructor func(tenantID string) Cache
+
+	// activity holds the tenantIDs ordered by their last access, most
+	// recently active one is at the front of the list
+	activity *list.List
+
+	// activityElems holds the list elements of the tenantIDs in activity
+	activityElems map[string]*list.Element
+
+	// totalCount holds the item count of all shards
+	totalCount int
+
+	// maxItems is the upper bound of totalCount, 0 means unlimited
+	maxItems int
+
+	// onDeleteShard is called with the tenantID of every deleted shard,
+	// including the ones deleted for keeping totalCount under maxItems and
+	// the ones emptied by Delete
+	onDeleteShard func(tenantID string)
+
+	// onEvictKey is called with the keys evicted by the shards' own policies,
+	// for the shards which implement EvictionNotifier
+	onEvictKey func(tenantID, key string)
+}
+
+// lener is implemented by the caches which can report their item count, so
+// the evictions done by the shard's own policy are reflected in the counts
+type lener interface {
+	Len() int
 }
 
-// NewShardedNoTS inits ShardedNoTS struct
+// NewShardedNoTS inits ShardedNoTS struct. Constructor is called for every new
+// shard, so a per-shard capacity and eviction policy can be set by returning
+// a fixed size cache, e.g. func() Cache { return NewLRUNoTS(100) }
 func NewShardedNoTS(c func() Cache) *ShardedNoTS {
+	return NewShardedNoTSPerTenant(func(string) Cache { return c() })
+}
+
+// NewShardedNoTSPerTenant inits ShardedNoTS struct with a constructor which is
+// called with the tenantID of every new shard, so the tenants can have
+// different capacities and eviction policies
+// usage:
+// NewShardedNoTSPerTenant(func(id string) Cache { return NewLRUNoTS(quotas[id]) })
+func NewShardedNoTSPerTenant(c func(tenantID string) Cache) *ShardedNoTS {
 	return &ShardedNoTS{
-		constructor: c,
-		cache:       make(map[string]Cache),
-		itemCount:   make(map[string]int),
+		constructor:   c,
+		cache:         make(map[string]Cache),
+		itemCount:     make(map[string]int),
+		activity:      list.New(),
+		activityElems: make(map[string]*list.Element),
+	}
+}
+
+// SetMaxItems sets the upper bound for the item count of all shards, when it
+// is exceeded the least recently active shards are deleted entirely. Zero
+// means there is no limit, which is the default
+func (l *ShardedNoTS) SetMaxItems(maxItems int) {
+	if maxItems < 0 {
+		panic("invalid max items")
 	}
+
+	l.maxItems = maxItems
+	l.evictShards("")
 }
 
 // Get returns a value of a given key if it exists
@@ -26,7 +84,15 @@ func (l *ShardedNoTS) Get(tenantID, key string) (interface{}, error) {
 		return nil, ErrNotFound
 	}
 
-	return cache.Get(key)
+	value, err := cache.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// only hits count as activity, so misses do not keep a shard alive
+	l.touch(tenantID)
+
+	return value, nil
 }
 
 // Set will persist a value to the cache or override existing one with the new
@@ -34,12 +100,25 @@ func (l *ShardedNoTS) Get(tenantID, key string) (interface{}, error) {
 func (l *ShardedNoTS) Set(tenantID, key string, val interface{}) error {
 	_, ok := l.cache[tenantID]
 	if !ok {
-		l.cache[tenantID] = l.constructor()
+		l.cache[tenantID] = l.newShard(tenantID)
 		l.itemCount[tenantID] = 0
 	}
 
-	l.itemCount[tenantID]++
-	return l.cache[tenantID].Set(key, val)
+	l.touch(tenantID)
+
+	delta := 1
+	if l.exists(tenantID, key) {
+		delta = 0
+	}
+
+	if err := l.cache[tenantID].Set(key, val); err != nil {
+		return err
+	}
+
+	l.updateCount(tenantID, delta)
+	l.evictShards(tenantID)
+
+	return nil
 }
 
 // Delete deletes a given key
@@ -49,19 +128,126 @@ func (l *ShardedNoTS) Delete(tenantID, key string) error {
 		return nil
 	}
 
-	l.itemCount[tenantID]--
+	delta := -1
+	if !l.exists(tenantID, key) {
+		delta = 0
+	}
+
+	if err := l.cache[tenantID].Delete(key); err != nil {
+		return err
+	}
+
+	l.updateCount(tenantID, delta)
 
-	if l.itemCount[tenantID] == 0 {
+	if l.itemCount[tenantID] <= 0 {
 		return l.DeleteShard(tenantID)
 	}
 
-	return l.cache[tenantID].Delete(key)
+	return nil
 }
 
 // DeleteShard deletes the keys inside from maps of cache & itemCount
 func (l *ShardedNoTS) DeleteShard(tenantID string) error {
+	l.totalCount -= l.itemCount[tenantID]
+
+	if elem, ok := l.activityElems[tenantID]; ok {
+		l.activity.Remove(elem)
+		delete(l.activityElems, tenantID)
+	}
+
 	delete(l.cache, tenantID)
 	delete(l.itemCount, tenantID)
 
+	if l.onDeleteShard != nil {
+		l.onDeleteShard(tenantID)
+	}
+
 	return nil
 }
+
+// newShard creates the cache of a shard, the keys evicted by its own policy
+// are passed to onEvictKey
+func (l *ShardedNoTS) newShard(tenantID string) Cache {
+	c := l.constructor(tenantID)
+
+	if n, ok := c.(EvictionNotifier); ok {
+		n.OnEvict(func(key string) {
+			if l.onEvictKey != nil {
+				l.onEvictKey(tenantID, key)
+			}
+		})
+	}
+
+	return c
+}
+
+// Shards returns the tenantIDs of the existing shards in sorted order
+func (l *ShardedNoTS) Shards() []string {
+	tenantIDs := make([]string, 0, len(l.cache))
+	for tenantID := range l.cache {
+		tenantIDs = append(tenantIDs, tenantID)
+	}
+
+	sort.Strings(tenantIDs)
+	return tenantIDs
+}
+
+// ShardLen returns the item count of the given shard, it returns 0 if the
+// shard does not exist
+func (l *ShardedNoTS) ShardLen(tenantID string) int {
+	return l.itemCount[tenantID]
+}
+
+// touch marks the given shard as the most recently active one
+func (l *ShardedNoTS) touch(tenantID string) {
+	if elem, ok := l.activityElems[tenantID]; ok {
+		l.activity.MoveToFront(elem)
+		return
+	}
+
+	l.activityElems[tenantID] = l.activity.PushFront(tenantID)
+}
+
+// exists reports whether the key is in the given shard. It is only needed for
+// the caches which can not report their length, so the count is not changed
+// for overwritten or non-existing keys, and the others are not queried
+func (l *ShardedNoTS) exists(tenantID, key string) bool {
+	cache := l.cache[tenantID]
+	if _, ok := cache.(lener); ok {
+		return false
+	}
+
+	_, err := cache.Get(key)
+	return err == nil
+}
+
+// updateCount updates the item count of the given shard, if the shard's cache
+// can report its length it is used, otherwise delta is applied to the count
+func (l *ShardedNoTS) updateCount(tenantID string, delta int) {
+	count := l.itemCount[tenantID] + delta
+	if c, ok := l.cache[tenantID].(lener); ok {
+		count = c.Len()
+	}
+
+	l.totalCount += count - l.itemCount[tenantID]
+	l.itemCount[tenantID] = count
+}
+
+// evictShards deletes the least recently active shards until the total item
+// count is under the limit, the given shard is never evicted
+func (l *ShardedNoTS) evictShards(keep string) {
+	if l.maxItems == 0 {
+		return
+	}
+
+	for elem := l.activity.Back(); elem != nil && l.totalCount > l.maxItems; {
+		tenantID := elem.Value.(string)
+		elem = elem.Prev()
+
+		if tenantID == keep {
+			continue
+		}
+
+		l.DeleteShard(tenantID)
+	}
+}
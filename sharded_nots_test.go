@@ -21,3 +21,114 @@ func TestShardedCacheNoTSDeleteShard(t *testing.T) {
 	cache := NewShardedNoTS(NewMemNoTSCache)
 	testDeleteShard(t, cache)
 }
+
+func TestShardedCacheNoTSShardQuota(t *testing.T) {
+	cache := NewShardedNoTS(func() Cache { return NewLRUNoTS(2) })
+	cache.Set("user1", "test_key", "test_data")
+	cache.Set("user1", "test_key2", "test_data2")
+	cache.Set("user1", "test_key3", "test_data3")
+	cache.Set("user2", "test_key", "test_data")
+
+	if _, err := cache.Get("user1", "test_key"); err != ErrNotFound {
+		t.Fatal("test_key should be evicted from user1")
+	}
+
+	if _, err := cache.Get("user2", "test_key"); err != nil {
+		t.Fatal("test_key should be in user2")
+	}
+
+	if cache.totalCount != 3 {
+		t.Fatalf("total count should be 3, got: %d", cache.totalCount)
+	}
+}
+
+func TestShardedCacheNoTSMaxItems(t *testing.T) {
+	cache := NewShardedNoTS(NewMemNoTSCache)
+	cache.SetMaxItems(3)
+	cache.Set("user1", "test_key", "test_data")
+	cache.Set("user2", "test_key", "test_data")
+	cache.Set("user3", "test_key", "test_data")
+
+	// user1 is the most recently active shard now
+	if _, err := cache.Get("user1", "test_key"); err != nil {
+		t.Fatal("test_key should be in user1")
+	}
+
+	// exceeding the limit should evict user2 entirely
+	cache.Set("user3", "test_key2", "test_data2")
+
+	if _, err := cache.Get("user2", "test_key"); err != ErrNotFound {
+		t.Fatal("user2 should be evicted")
+	}
+
+	for _, tenantID := range []string{"user1", "user3"} {
+		if _, err := cache.Get(tenantID, "test_key"); err != nil {
+			t.Fatalf("test_key should be in %s", tenantID)
+		}
+	}
+
+	// shard which is written to is never evicted
+	cache.Set("user3", "test_key3", "test_data3")
+	cache.Set("user3", "test_key4", "test_data4")
+
+	if _, err := cache.Get("user1", "test_key"); err != ErrNotFound {
+		t.Fatal("user1 should be evicted")
+	}
+
+	if cache.totalCount != 4 {
+		t.Fatalf("total count should be 4, got: %d", cache.totalCount)
+	}
+}
+
+func TestShardedCacheNoTSShardLen(t *testing.T) {
+	// Memory does not report its length, so the counts are kept by
+	// ShardedNoTS itself
+	for _, cache := range []*ShardedNoTS{
+		NewShardedNoTS(NewMemNoTSCache),
+		NewShardedNoTS(func() Cache { return NewMemory() }),
+	} {
+		cache.Set("user1", "test_key", "test_data")
+		cache.Set("user1", "test_key", "test_data")
+		cache.Set("user1", "test_key2", "test_data2")
+		cache.Set("user2", "test_key", "test_data")
+		cache.Delete("user1", "test_key3")
+
+		if n := cache.ShardLen("user1"); n != 2 {
+			t.Fatalf("user1 should have 2 items, got: %d", n)
+		}
+
+		if n := cache.ShardLen("user3"); n != 0 {
+			t.Fatalf("user3 should have 0 items, got: %d", n)
+		}
+
+		shards := cache.Shards()
+		if len(shards) != 2 || shards[0] != "user1" || shards[1] != "user2" {
+			t.Fatalf("shards should be user1 and user2, got: %v", shards)
+		}
+
+		cache.Delete("user2", "test_key")
+		if shards := cache.Shards(); len(shards) != 1 {
+			t.Fatalf("empty shard should be deleted, got: %v", shards)
+		}
+	}
+}
+
+func TestShardedCacheNoTSPerTenantQuota(t *testing.T) {
+	quotas := map[string]int{"user1": 1, "user2": 2}
+	cache := NewShardedNoTSPerTenant(func(tenantID string) Cache {
+		return NewLRUNoTS(quotas[tenantID])
+	})
+
+	for _, tenantID := range []string{"user1", "user2"} {
+		cache.Set(tenantID, "test_key", "test_data")
+		cache.Set(tenantID, "test_key2", "test_data2")
+	}
+
+	if n := cache.ShardLen("user1"); n != 1 {
+		t.Fatalf("user1 should have 1 item, got: %d", n)
+	}
+
+	if n := cache.ShardLen("user2"); n != 2 {
+		t.Fatalf("user2 should have 2 items, got: %d", n)
+	}
+}
@@ -3,6 +3,8 @@ package cache
 import (
 	"sync"
 	"time"
+
+	"github.com/koding/cache/clock"
 )
 
 // ShardedTTL holds the required variables to compose an in memory sharded cache system
@@ -13,7 +15,7 @@ type ShardedTTL struct {
 	sync.Mutex
 
 	// cache holds the cache data
-	cache ShardedCache
+	cache *ShardedNoTS
 
 	// setAts holds the time that related item's set at, indexed by tenantID
 	setAts map[string]map[string]time.Time
@@ -21,52 +23,214 @@ type ShardedTTL struct {
 	// ttl is a duration for a cache key to expire
 	ttl time.Duration
 
+	// shardTTLs holds the ttl of the shards which do not use the default one,
+	// indexed by tenantID. They are kept when the shards are deleted, so the
+	// next keys of the tenants get them too
+	shardTTLs map[string]time.Duration
+
+	// activeAts holds the time that related shard is accessed at, indexed by
+	// tenantID
+	activeAts map[string]time.Time
+
+	// idleTimeout is a duration for a shard to be deleted after its last
+	// access, 0 means shards are never deleted for inactivity
+	idleTimeout time.Duration
+
 	// gcInterval is a duration for garbage collection
 	gcInterval time.Duration
+
+	// clock is used for expiration of keys and gc intervals
+	clock clock.Clock
+
+	// gcTicker controls gc intervals
+	gcTicker clock.Ticker
+
+	// done controls sweeping goroutine lifetime
+	done chan struct{}
 }
 
 // NewShardedCacheWithTTL creates a sharded cache system with TTL based on specified Cache constructor
 // Which everytime will return the true values about a cache hit
 // and never will leak memory
 // ttl is used for expiration of a key from cache
-func NewShardedCacheWithTTL(ttl time.Duration, f func() Cache) *ShardedTTL {
-	return &ShardedTTL{
-		cache:  NewShardedNoTS(f),
-		setAts: map[string]map[string]time.Time{},
-		ttl:    ttl,
+func NewShardedCacheWithTTL(ttl time.Duration, f func() Cache, opts ...TTLOption) *ShardedTTL {
+	return NewShardedCacheWithTTLPerTenant(ttl, func(string) Cache { return f() }, opts...)
+}
+
+// NewShardedCacheWithTTLPerTenant creates a sharded cache system with TTL based
+// on a Cache constructor which is called with the tenantID of every new shard,
+// so the tenants can have different capacities and eviction policies
+// ttl is used for expiration of a key from cache
+func NewShardedCacheWithTTLPerTenant(ttl time.Duration, f func(tenantID string) Cache, opts ...TTLOption) *ShardedTTL {
+	o := newTTLOptions(opts)
+
+	r := &ShardedTTL{
+		cache:     NewShardedNoTSPerTenant(f),
+		setAts:    map[string]map[string]time.Time{},
+		ttl:       ttl,
+		shardTTLs: map[string]time.Duration{},
+		activeAts: map[string]time.Time{},
+		clock:     o.clock,
+	}
+
+	// deleted shards and the keys evicted by the shards should not leave
+	// their set times behind, they are called with the lock held
+	r.cache.onDeleteShard = func(tenantID string) {
+		delete(r.setAts, tenantID)
+		delete(r.activeAts, tenantID)
+	}
+
+	r.cache.onEvictKey = func(tenantID, key string) {
+		delete(r.setAts[tenantID], key)
 	}
+
+	return r
 }
 
 // NewShardedWithTTL creates an in-memory sharded cache system
 // ttl is used for expiration of a key from cache
-func NewShardedWithTTL(ttl time.Duration) *ShardedTTL {
-	return NewShardedCacheWithTTL(ttl, NewMemNoTSCache)
+func NewShardedWithTTL(ttl time.Duration, opts ...TTLOption) *ShardedTTL {
+	return NewShardedCacheWithTTL(ttl, NewMemNoTSCache, opts...)
+}
+
+// SetMaxItems sets the upper bound for the item count of all shards, when it
+// is exceeded the least recently active shards are deleted entirely. Zero
+// means there is no limit, which is the default
+func (r *ShardedTTL) SetMaxItems(maxItems int) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.cache.SetMaxItems(maxItems)
+}
+
+// SetShardTTL sets the ttl of the keys in the given shard, overriding the
+// default ttl of the cache. It applies to the existing keys of the shard too,
+// and it is kept when the shard is deleted or emptied until ResetShardTTL is
+// called
+func (r *ShardedTTL) SetShardTTL(tenantID string, ttl time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.shardTTLs[tenantID] = ttl
+}
+
+// ResetShardTTL restores the default ttl of the cache for the given shard
+func (r *ShardedTTL) ResetShardTTL(tenantID string) {
+	r.Lock()
+	defer r.Unlock()
+
+	delete(r.shardTTLs, tenantID)
+}
+
+// SetIdleTimeout sets the duration after which a shard is deleted entirely if
+// none of its keys are accessed. Zero means shards are never deleted for
+// inactivity, which is the default
+func (r *ShardedTTL) SetIdleTimeout(idleTimeout time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.idleTimeout = idleTimeout
+}
+
+// Shards returns the tenantIDs of the existing shards in sorted order
+func (r *ShardedTTL) Shards() []string {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.cache.Shards()
 }
 
-// StartGC starts the garbage collection process in a go routine
+// ShardLen returns the item count of the given shard, expired keys which are
+// not collected yet are counted too
+func (r *ShardedTTL) ShardLen(tenantID string) int {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.cache.ShardLen(tenantID)
+}
+
+// StartGC starts the garbage collection process in a go routine, calling
+// StartGC again restarts the process with the new interval
 func (r *ShardedTTL) StartGC(gcInterval time.Duration) {
+	if gcInterval <= 0 {
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.stopGC()
+
+	ticker := r.clock.NewTicker(gcInterval)
+	done := make(chan struct{})
+
 	r.gcInterval = gcInterval
+	r.gcTicker = ticker
+	r.done = done
+
 	go func() {
-		for _ = range time.Tick(gcInterval) {
-			r.Lock()
-			for tenantID := range r.setAts {
-				for key := range r.setAts[tenantID] {
-					if !r.isValid(tenantID, key) {
-						r.delete(tenantID, key)
+		for {
+			select {
+			case <-ticker.C():
+				now := r.clock.Now()
+
+				r.Lock()
+				for tenantID := range r.setAts {
+					if r.isIdle(tenantID, now) {
+						r.deleteShard(tenantID)
+						continue
+					}
+
+					for key := range r.setAts[tenantID] {
+						if !r.isValid(tenantID, key) {
+							r.delete(tenantID, key)
+						}
 					}
 				}
+				r.Unlock()
+			case <-done:
+				return
 			}
-			r.Unlock()
 		}
 	}()
 }
 
+// StopGC stops sweeping goroutine, it is safe to call StopGC multiple times
+func (r *ShardedTTL) StopGC() {
+	r.Lock()
+	defer r.Unlock()
+
+	r.stopGC()
+}
+
+// Close stops sweeping goroutine, it implements io.Closer
+func (r *ShardedTTL) Close() error {
+	r.StopGC()
+	return nil
+}
+
+func (r *ShardedTTL) stopGC() {
+	if r.gcTicker == nil {
+		return
+	}
+
+	r.gcTicker.Stop()
+	r.gcTicker = nil
+	close(r.done)
+	r.done = nil
+}
+
 // Get returns a value of a given key if it exists
 // and valid for the time being
 func (r *ShardedTTL) Get(tenantID, key string) (interface{}, error) {
 	r.Lock()
 	defer r.Unlock()
 
+	if r.isIdle(tenantID, r.clock.Now()) {
+		r.deleteShard(tenantID)
+		return nil, ErrNotFound
+	}
+
 	if !r.isValid(tenantID, key) {
 		r.delete(tenantID, key)
 		return nil, ErrNotFound
@@ -77,6 +241,9 @@ func (r *ShardedTTL) Get(tenantID, key string) (interface{}, error) {
 		return nil, err
 	}
 
+	// only hits count as activity, so misses do not keep a shard alive
+	r.touch(tenantID)
+
 	return value, nil
 }
 
@@ -91,7 +258,8 @@ func (r *ShardedTTL) Set(tenantID, key string, value interface{}) error {
 	if !ok {
 		r.setAts[tenantID] = make(map[string]time.Time)
 	}
-	r.setAts[tenantID][key] = time.Now()
+	r.setAts[tenantID][key] = r.clock.Now()
+	r.touch(tenantID)
 	return nil
 }
 
@@ -113,7 +281,36 @@ func (r *ShardedTTL) delete(tenantID, key string) {
 	delete(r.setAts[tenantID], key)
 	if len(r.setAts[tenantID]) == 0 {
 		delete(r.setAts, tenantID)
+		delete(r.activeAts, tenantID)
+	}
+}
+
+// deleteShard deletes the shard with all of its keys
+func (r *ShardedTTL) deleteShard(tenantID string) {
+	r.cache.DeleteShard(tenantID)
+	delete(r.setAts, tenantID)
+	delete(r.activeAts, tenantID)
+}
+
+// touch marks the given shard as accessed now, if it exists
+func (r *ShardedTTL) touch(tenantID string) {
+	if _, ok := r.setAts[tenantID]; ok {
+		r.activeAts[tenantID] = r.clock.Now()
+	}
+}
+
+// isIdle reports whether the given shard is not accessed for idleTimeout
+func (r *ShardedTTL) isIdle(tenantID string, t time.Time) bool {
+	if r.idleTimeout == zeroTTL {
+		return false
+	}
+
+	activeAt, ok := r.activeAts[tenantID]
+	if !ok {
+		return false
 	}
+
+	return !activeAt.Add(r.idleTimeout).After(t)
 }
 
 func (r *ShardedTTL) isValid(tenantID, key string) bool {
@@ -126,11 +323,16 @@ func (r *ShardedTTL) isValid(tenantID, key string) bool {
 	if !ok {
 		return false
 	}
-	if r.ttl == zeroTTL {
+	ttl, ok := r.shardTTLs[tenantID]
+	if !ok {
+		ttl = r.ttl
+	}
+
+	if ttl == zeroTTL {
 		return true
 	}
 
-	return setAt.Add(r.ttl).After(time.Now())
+	return setAt.Add(ttl).After(r.clock.Now())
 }
 
 // DeleteShard deletes with given tenantID without key
@@ -138,11 +340,6 @@ func (r *ShardedTTL) DeleteShard(tenantID string) error {
 	r.Lock()
 	defer r.Unlock()
 
-	_, ok := r.setAts[tenantID]
-	if ok {
-		for key := range r.setAts[tenantID] {
-			r.delete(tenantID, key)
-		}
-	}
+	r.deleteShard(tenantID)
 	return nil
 }
@@ -1,13 +1,20 @@
 package cache
 
 import (
+	"io"
+	"strconv"
 	"testing"
 	"time"
+
+	"github.com/koding/cache/clock/clocktest"
 )
 
+var _ io.Closer = (*ShardedTTL)(nil)
+
 func TestShardedCacheGetSet(t *testing.T) {
 	cache := NewShardedWithTTL(2 * time.Second)
 	cache.StartGC(time.Millisecond * 10)
+	defer cache.StopGC()
 	cache.Set("user1", "test_key", "test_data")
 	data, err := cache.Get("user1", "test_key")
 	if err != nil {
@@ -19,35 +26,53 @@ func TestShardedCacheGetSet(t *testing.T) {
 }
 
 func TestShardedCacheTTL(t *testing.T) {
-	cache := NewShardedWithTTL(100 * time.Millisecond)
-	cache.StartGC(time.Millisecond * 10)
+	clock := clocktest.NewFake(time.Now())
+	cache := NewShardedWithTTL(100*time.Millisecond, WithClock(clock))
 	cache.Set("user1", "test_key", "test_data")
-	time.Sleep(200 * time.Millisecond)
+	clock.Advance(200 * time.Millisecond)
 	_, err := cache.Get("user1", "test_key")
 	if err == nil {
 		t.Fatal("data found")
 	}
 }
 
+func TestShardedCacheTTLGC(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	cache := NewShardedWithTTL(100*time.Millisecond, WithClock(clock))
+	cache.StartGC(time.Millisecond * 10)
+	defer cache.StopGC()
+	cache.Set("user1", "test_key", "test_data")
+
+	// second tick can only be received after the first sweep is done
+	clock.Advance(200 * time.Millisecond)
+	clock.Advance(10 * time.Millisecond)
+
+	cache.Lock()
+	_, ok := cache.setAts["user1"]
+	cache.Unlock()
+	if ok {
+		t.Fatal("user1 should be swept")
+	}
+}
+
 func TestShardedCacheTTLGetExpired(t *testing.T) {
 	// Needs go test -race to catch problems
-	cache := NewShardedWithTTL(1 * time.Millisecond)
+	clock := clocktest.NewFake(time.Now())
+	cache := NewShardedWithTTL(1*time.Millisecond, WithClock(clock))
 	cache.Set("user1", "test_key", "test_data")
 	sig := make(chan struct{})
 	go func() {
 		for {
 			_, _ = cache.Get("user1", "test_key")
 			select {
-			case _, ok := <-sig:
-				if !ok {
-					break
-				}
+			case <-sig:
+				return
 			default:
 			}
 		}
 
 	}()
-	time.Sleep(20 * time.Millisecond)
+	clock.Advance(20 * time.Millisecond)
 	_, err := cache.Get("user1", "test_key")
 	if err == nil {
 		t.Fatal("data found")
@@ -58,6 +83,7 @@ func TestShardedCacheTTLGetExpired(t *testing.T) {
 func TestShardedCacheTTLNilValue(t *testing.T) {
 	cache := NewShardedWithTTL(100 * time.Millisecond)
 	cache.StartGC(time.Millisecond * 10)
+	defer cache.StopGC()
 	cache.Set("user1", "test_key", nil)
 	data, err := cache.Get("user1", "test_key")
 	if err != nil {
@@ -71,6 +97,7 @@ func TestShardedCacheTTLNilValue(t *testing.T) {
 func TestShardedCacheTTLDeleteShard(t *testing.T) {
 	cache := NewShardedWithTTL(100 * time.Millisecond)
 	cache.StartGC(time.Millisecond * 10)
+	defer cache.StopGC()
 	cache.Set("user1", "test_key", nil)
 	cache.DeleteShard("user1")
 	_, err := cache.Get("user1", "test_key")
@@ -78,3 +105,172 @@ func TestShardedCacheTTLDeleteShard(t *testing.T) {
 		t.Fatal("data found")
 	}
 }
+
+func TestShardedCacheTTLRestartGC(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	cache := NewShardedWithTTL(100*time.Millisecond, WithClock(clock))
+	cache.StartGC(time.Millisecond * 10)
+	cache.StartGC(time.Millisecond * 20)
+	cache.Set("user1", "test_key", "test_data")
+
+	clock.Advance(200 * time.Millisecond)
+	clock.Advance(20 * time.Millisecond)
+
+	cache.Lock()
+	_, ok := cache.setAts["user1"]
+	cache.Unlock()
+	if ok {
+		t.Fatal("user1 should be swept")
+	}
+
+	cache.StopGC()
+	cache.StopGC()
+	if err := cache.Close(); err != nil {
+		t.Fatal("close should not give error")
+	}
+}
+
+func TestShardedCacheTTLMaxItems(t *testing.T) {
+	cache := NewShardedCacheWithTTL(time.Minute, func() Cache { return NewLFUNoTS(2) })
+	cache.SetMaxItems(2)
+	cache.Set("user1", "test_key", "test_data")
+	cache.Set("user2", "test_key", "test_data")
+	cache.Set("user2", "test_key2", "test_data2")
+
+	if _, err := cache.Get("user1", "test_key"); err != ErrNotFound {
+		t.Fatal("user1 should be evicted")
+	}
+
+	if _, ok := cache.setAts["user1"]; ok {
+		t.Fatal("user1 keys should be dropped")
+	}
+
+	if _, err := cache.Get("user2", "test_key2"); err != nil {
+		t.Fatal("test_key2 should be in user2")
+	}
+}
+
+func TestShardedCacheTTLShardEviction(t *testing.T) {
+	cache := NewShardedCacheWithTTL(time.Minute, func() Cache { return NewLRUNoTS(4) })
+
+	for i := 0; i < 10000; i++ {
+		cache.Set("user1", strconv.Itoa(i), "test_data")
+	}
+
+	if n := cache.ShardLen("user1"); n != 4 {
+		t.Fatalf("user1 should have 4 items, got: %d", n)
+	}
+
+	if n := len(cache.setAts["user1"]); n != 4 {
+		t.Fatalf("set times of the evicted keys should be dropped, got %d", n)
+	}
+
+	// shard deleted by the underlying cache drops its set times too
+	for i := 9996; i < 10000; i++ {
+		cache.cache.Delete("user1", strconv.Itoa(i))
+	}
+
+	if _, ok := cache.setAts["user1"]; ok {
+		t.Fatal("set times of the deleted shard should be dropped")
+	}
+}
+
+func TestShardedCacheTTLShardTTL(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	cache := NewShardedWithTTL(time.Minute, WithClock(clock))
+	cache.SetShardTTL("user1", time.Second)
+	cache.Set("user1", "test_key", "test_data")
+	cache.Set("user2", "test_key", "test_data")
+
+	if n := cache.ShardLen("user1"); n != 1 {
+		t.Fatalf("user1 should have 1 item, got: %d", n)
+	}
+
+	clock.Advance(2 * time.Second)
+
+	if _, err := cache.Get("user1", "test_key"); err != ErrNotFound {
+		t.Fatal("test_key should be expired in user1")
+	}
+
+	if _, err := cache.Get("user2", "test_key"); err != nil {
+		t.Fatal("test_key should be in user2")
+	}
+
+	if shards := cache.Shards(); len(shards) != 1 || shards[0] != "user2" {
+		t.Fatalf("shards should be user2, got: %v", shards)
+	}
+}
+
+func TestShardedCacheTTLIdleTimeout(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	cache := NewShardedWithTTL(0, WithClock(clock))
+	cache.SetIdleTimeout(time.Minute)
+	cache.StartGC(time.Second)
+	defer cache.StopGC()
+
+	cache.Set("user1", "test_key", "test_data")
+	cache.Set("user2", "test_key", "test_data")
+
+	clock.Advance(50 * time.Second)
+	if _, err := cache.Get("user2", "test_key"); err != nil {
+		t.Fatal("test_key should be in user2")
+	}
+
+	// second tick can only be received after the first sweep is done
+	clock.Advance(20 * time.Second)
+	clock.Advance(time.Second)
+
+	if shards := cache.Shards(); len(shards) != 1 || shards[0] != "user2" {
+		t.Fatalf("idle user1 should be deleted, got: %v", shards)
+	}
+
+	clock.Advance(time.Minute)
+	if _, err := cache.Get("user2", "test_key"); err != ErrNotFound {
+		t.Fatal("idle user2 should be deleted")
+	}
+}
+
+func TestShardedCacheTTLDeleteShardTTL(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	cache := NewShardedWithTTL(time.Minute, WithClock(clock))
+	cache.SetShardTTL("user1", time.Second)
+	cache.Set("user1", "test_key", "test_data")
+	cache.DeleteShard("user1")
+
+	// ttl of user1 is kept for its next keys, also when its shard empties
+	cache.Set("user1", "test_key", "test_data")
+	cache.Delete("user1", "test_key")
+	cache.Set("user1", "test_key", "test_data")
+
+	clock.Advance(2 * time.Second)
+
+	if _, err := cache.Get("user1", "test_key"); err != ErrNotFound {
+		t.Fatal("test_key should be expired with the ttl of user1")
+	}
+
+	cache.ResetShardTTL("user1")
+	cache.Set("user1", "test_key", "test_data")
+
+	clock.Advance(2 * time.Second)
+
+	if _, err := cache.Get("user1", "test_key"); err != nil {
+		t.Fatal("test_key should have the default ttl after the reset")
+	}
+}
+
+func TestShardedCacheTTLIdleMiss(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	cache := NewShardedWithTTL(0, WithClock(clock))
+	cache.SetIdleTimeout(time.Minute)
+
+	cache.Set("user1", "test_key", "test_data")
+
+	// misses do not count as activity
+	clock.Advance(50 * time.Second)
+	cache.Get("user1", "missing")
+	clock.Advance(20 * time.Second)
+
+	if _, err := cache.Get("user1", "test_key"); err != ErrNotFound {
+		t.Fatal("user1 should be idle after the miss")
+	}
+}
@@ -0,0 +1,395 @@
+package cache
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/koding/cache/clock"
+)
+
+const defaultSQLTable = "cache"
+
+// validSQLTable matches the table names which can be used without quoting
+var validSQLTable = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Dialect holds the differences of the databases supported by SQLCache
+type Dialect struct {
+	// name is the name of the dialect
+	name string
+
+	// blobType is the column type of the values
+	blobType string
+
+	// placeholder returns the nth parameter placeholder, n starts from 1
+	placeholder func(n int) string
+}
+
+var (
+	// SQLiteDialect is the dialect of SQLite 3.24 and later
+	SQLiteDialect = &Dialect{
+		name:        "sqlite",
+		blobType:    "BLOB",
+		placeholder: func(int) string { return "?" },
+	}
+
+	// PostgresDialect is the dialect of PostgreSQL 9.5 and later
+	PostgresDialect = &Dialect{
+		name:        "postgres",
+		blobType:    "BYTEA",
+		placeholder: func(n int) string { return "$" + strconv.Itoa(n) },
+	}
+)
+
+// String returns the name of the dialect
+func (d *Dialect) String() string {
+	return d.name
+}
+
+// query replaces the ? placeholders of the query with the ones of the dialect
+func (d *Dialect) query(q string) string {
+	var b strings.Builder
+
+	n := 0
+	for _, r := range q {
+		if r != '?' {
+			b.WriteRune(r)
+			continue
+		}
+
+		n++
+		b.WriteString(d.placeholder(n))
+	}
+
+	return b.String()
+}
+
+// SQLCache is a Cache backed by a relational database through database/sql.
+// Every key is stored as a row of a table, which is created if it does not
+// exist. []byte and string values are stored as they are, other values are
+// encoded with the codec. Expired keys are never returned, they are deleted by
+// the garbage collector.
+//
+// Keys of SQLCache are stored in the empty shard, Sharded returns a
+// ShardedCache over the same table
+type SQLCache struct {
+	// db holds the database connection pool
+	db *sql.DB
+
+	// dialect is the dialect of the database
+	dialect *Dialect
+
+	// table is the name of the table of the keys
+	table string
+
+	// ttl is the duration for a key set with Set to expire, keys do not
+	// expire if it is 0
+	ttl time.Duration
+
+	// codec encodes the values which are not []byte or string
+	codec Codec
+
+	// clock is used for expiration of keys and gc intervals
+	clock clock.Clock
+
+	// queries of the dialect for the table
+	getQuery, upsertQuery, deleteQuery, deleteShardQuery, deleteExpiredQuery string
+
+	// Mutex guards gcTicker and done
+	sync.Mutex
+
+	// gcTicker controls gc intervals
+	gcTicker clock.Ticker
+
+	// done controls sweeping goroutine lifetime
+	done chan struct{}
+}
+
+// SQLOption sets the options specified for SQLCache.
+type SQLOption func(*SQLCache)
+
+// WithSQLTable sets the name of the table of the keys, default is "cache"
+func WithSQLTable(table string) SQLOption {
+	if !validSQLTable.MatchString(table) {
+		panic(fmt.Sprintf("invalid table name %q", table))
+	}
+
+	return func(s *SQLCache) {
+		s.table = table
+	}
+}
+
+// WithSQLTTL sets the duration for the keys set with Set to expire, keys do
+// not expire by default
+func WithSQLTTL(ttl time.Duration) SQLOption {
+	return func(s *SQLCache) {
+		s.ttl = ttl
+	}
+}
+
+// WithSQLCodec sets the codec for encoding the values which are not []byte
+// or string, default is JSONCodec
+func WithSQLCodec(c Codec) SQLOption {
+	return func(s *SQLCache) {
+		s.codec = c
+	}
+}
+
+// WithSQLClock sets the clock which is used for expiring keys and ticking
+// the garbage collector
+func WithSQLClock(c clock.Clock) SQLOption {
+	return func(s *SQLCache) {
+		s.clock = c
+	}
+}
+
+// NewSQLCache creates a cache backed by the given database, its table and the
+// expiry index are created if they do not exist. Database is not closed by
+// Close
+// usage:
+// NewSQLCache(db, PostgresDialect, WithSQLTTL(time.Hour))
+func NewSQLCache(db *sql.DB, dialect *Dialect, opts ...SQLOption) (*SQLCache, error) {
+	s := &SQLCache{
+		db:      db,
+		dialect: dialect,
+		table:   defaultSQLTable,
+		codec:   JSONCodec,
+		clock:   clock.New(),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.getQuery = dialect.query(`SELECT kind, value FROM ` + s.table +
+		` WHERE shard = ? AND cache_key = ? AND (expire_at = 0 OR expire_at > ?)`)
+
+	s.upsertQuery = dialect.query(`INSERT INTO ` + s.table +
+		` (shard, cache_key, kind, value, expire_at) VALUES (?, ?, ?, ?, ?)` +
+		` ON CONFLICT (shard, cache_key) DO UPDATE SET` +
+		` kind = excluded.kind, value = excluded.value, expire_at = excluded.expire_at`)
+
+	s.deleteQuery = dialect.query(`DELETE FROM ` + s.table + ` WHERE shard = ? AND cache_key = ?`)
+	s.deleteShardQuery = dialect.query(`DELETE FROM ` + s.table + ` WHERE shard = ?`)
+	s.deleteExpiredQuery = dialect.query(`DELETE FROM ` + s.table + ` WHERE expire_at > 0 AND expire_at <= ?`)
+
+	if err := s.EnsureSchema(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// EnsureSchema creates the table and the expiry index if they do not exist
+func (s *SQLCache) EnsureSchema() error {
+	schema := []string{
+		`CREATE TABLE IF NOT EXISTS ` + s.table + ` (
+			shard TEXT NOT NULL,
+			cache_key TEXT NOT NULL,
+			kind SMALLINT NOT NULL,
+			value ` + s.dialect.blobType + `,
+			expire_at BIGINT NOT NULL,
+			PRIMARY KEY (shard, cache_key)
+		)`,
+		`CREATE INDEX IF NOT EXISTS ` + s.table + `_expire_at ON ` + s.table + ` (expire_at)`,
+	}
+
+	for _, q := range schema {
+		if _, err := s.db.Exec(q); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Get returns a value of a given key if it exists and is not expired
+func (s *SQLCache) Get(key string) (interface{}, error) {
+	return s.get("", key)
+}
+
+// GetInto decodes the value of a given key into dst, which must be a pointer
+func (s *SQLCache) GetInto(key string, dst interface{}) error {
+	data, kind, err := s.getRaw("", key)
+	if err != nil {
+		return err
+	}
+
+	return decodeValueInto(s.codec, data, kind, dst)
+}
+
+// Set will persist a value to the cache or override existing one with the new
+// one, it expires with the ttl of the cache
+func (s *SQLCache) Set(key string, value interface{}) error {
+	return s.set("", key, s.ttl, value)
+}
+
+// SetEx will persist a value to the cache or override existing one with the
+// new one with ttl duration, zero duration never expires
+func (s *SQLCache) SetEx(key string, duration time.Duration, value interface{}) error {
+	return s.set("", key, duration, value)
+}
+
+// Delete deletes a given key if exists
+func (s *SQLCache) Delete(key string) error {
+	return s.delete("", key)
+}
+
+// Sharded returns a ShardedCache over the same table, keys of SQLCache are
+// the keys of its empty shard
+func (s *SQLCache) Sharded() *ShardedSQLCache {
+	return &ShardedSQLCache{cache: s}
+}
+
+// StartGC starts the garbage collection process in a go routine, calling
+// StartGC again restarts the process with the new interval
+func (s *SQLCache) StartGC(gcInterval time.Duration) {
+	if gcInterval <= 0 {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.stopGC()
+
+	ticker := s.clock.NewTicker(gcInterval)
+	done := make(chan struct{})
+
+	s.gcTicker = ticker
+	s.done = done
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C():
+				s.deleteExpiredKeys()
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// StopGC stops sweeping goroutine, it is safe to call StopGC multiple times
+func (s *SQLCache) StopGC() {
+	s.Lock()
+	defer s.Unlock()
+
+	s.stopGC()
+}
+
+// Close stops sweeping goroutine, it implements io.Closer
+func (s *SQLCache) Close() error {
+	s.StopGC()
+	return nil
+}
+
+func (s *SQLCache) stopGC() {
+	if s.gcTicker == nil {
+		return
+	}
+
+	s.gcTicker.Stop()
+	s.gcTicker = nil
+	close(s.done)
+	s.done = nil
+}
+
+func (s *SQLCache) get(shardID, key string) (interface{}, error) {
+	data, kind, err := s.getRaw(shardID, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeValue(s.codec, data, kind)
+}
+
+// getRaw fetches the value bytes of the key with its kind
+func (s *SQLCache) getRaw(shardID, key string) ([]byte, uint8, error) {
+	var (
+		kind uint8
+		data []byte
+	)
+
+	err := s.db.QueryRow(s.getQuery, shardID, key, s.clock.Now().UnixNano()).Scan(&kind, &data)
+	if err == sql.ErrNoRows {
+		return nil, 0, ErrNotFound
+	}
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return data, kind, nil
+}
+
+func (s *SQLCache) set(shardID, key string, duration time.Duration, value interface{}) error {
+	data, kind, err := encodeValue(s.codec, value)
+	if err != nil {
+		return err
+	}
+
+	var expireAt int64
+	if duration > 0 {
+		expireAt = s.clock.Now().Add(duration).UnixNano()
+	}
+
+	_, err = s.db.Exec(s.upsertQuery, shardID, key, kind, data, expireAt)
+	return err
+}
+
+func (s *SQLCache) delete(shardID, key string) error {
+	_, err := s.db.Exec(s.deleteQuery, shardID, key)
+	return err
+}
+
+func (s *SQLCache) deleteShard(shardID string) error {
+	_, err := s.db.Exec(s.deleteShardQuery, shardID)
+	return err
+}
+
+// deleteExpiredKeys deletes the expired keys of all shards, it uses the
+// expiry index
+func (s *SQLCache) deleteExpiredKeys() error {
+	_, err := s.db.Exec(s.deleteExpiredQuery, s.clock.Now().UnixNano())
+	return err
+}
+
+// ShardedSQLCache is a ShardedCache backed by the table of a SQLCache, it is
+// created with SQLCache.Sharded
+type ShardedSQLCache struct {
+	cache *SQLCache
+}
+
+// Get returns a value of a given key in the shard if it exists and is not
+// expired
+func (s *ShardedSQLCache) Get(shardID, key string) (interface{}, error) {
+	return s.cache.get(shardID, key)
+}
+
+// Set will persist a value to the shard or override existing one with the new
+// one, it expires with the ttl of the cache
+func (s *ShardedSQLCache) Set(shardID, key string, value interface{}) error {
+	return s.cache.set(shardID, key, s.cache.ttl, value)
+}
+
+// SetEx will persist a value to the shard or override existing one with the
+// new one with ttl duration, zero duration never expires
+func (s *ShardedSQLCache) SetEx(shardID, key string, duration time.Duration, value interface{}) error {
+	return s.cache.set(shardID, key, duration, value)
+}
+
+// Delete deletes a given key in the shard if exists
+func (s *ShardedSQLCache) Delete(shardID, key string) error {
+	return s.cache.delete(shardID, key)
+}
+
+// DeleteShard deletes all the keys of the shard
+func (s *ShardedSQLCache) DeleteShard(shardID string) error {
+	return s.cache.deleteShard(shardID)
+}
@@ -0,0 +1,208 @@
+package cache
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/koding/cache/clock/clocktest"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// openTestSQLite opens a SQLite database in a temporary directory, it is closed
+// when the test ends
+func openTestSQLite(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+// sqlCount returns the row count of the cache table
+func sqlCount(t *testing.T, cache *SQLCache) int {
+	var n int
+	if err := cache.db.QueryRow("SELECT COUNT(*) FROM " + cache.table).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+
+	return n
+}
+
+func TestSQLCacheGetSet(t *testing.T) {
+	cache, err := NewSQLCache(openTestSQLite(t), SQLiteDialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	testCacheGetSet(t, cache)
+}
+
+func TestSQLCacheNilValue(t *testing.T) {
+	cache, err := NewSQLCache(openTestSQLite(t), SQLiteDialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	testCacheNilValue(t, cache)
+}
+
+func TestSQLCacheDelete(t *testing.T) {
+	cache, err := NewSQLCache(openTestSQLite(t), SQLiteDialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	testCacheDelete(t, cache)
+}
+
+func TestShardedSQLCacheGetSet(t *testing.T) {
+	cache, err := NewSQLCache(openTestSQLite(t), SQLiteDialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	testShardedCacheGetSet(t, cache.Sharded())
+}
+
+func TestShardedSQLCacheNilValue(t *testing.T) {
+	cache, err := NewSQLCache(openTestSQLite(t), SQLiteDialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	testShardedCacheNilValue(t, cache.Sharded())
+}
+
+func TestShardedSQLCacheDelete(t *testing.T) {
+	cache, err := NewSQLCache(openTestSQLite(t), SQLiteDialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	testShardedCacheDelete(t, cache.Sharded())
+}
+
+func TestSQLCacheTTL(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	cache, err := NewSQLCache(openTestSQLite(t), SQLiteDialect, WithSQLTTL(time.Minute), WithSQLClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	cache.Set("test_key", "test_data")
+	cache.SetEx("test_key2", time.Hour, "test_data2")
+	cache.Sharded().SetEx("shard", "test_key3", 0, "test_data3")
+
+	clock.Advance(time.Minute)
+
+	if _, err := cache.Get("test_key"); err != ErrNotFound {
+		t.Fatalf("error should equal to %q, got: %v", ErrNotFound, err)
+	}
+
+	if _, err := cache.Get("test_key2"); err != nil {
+		t.Fatalf("test_key2 should be in the cache, got: %v", err)
+	}
+
+	if _, err := cache.Sharded().Get("shard", "test_key3"); err != nil {
+		t.Fatalf("test_key3 should be in the cache, got: %v", err)
+	}
+}
+
+func TestSQLCacheGC(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	cache, err := NewSQLCache(openTestSQLite(t), SQLiteDialect, WithSQLTTL(time.Minute), WithSQLClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	cache.StartGC(time.Minute)
+
+	cache.Set("test_key", "test_data")
+	cache.Sharded().Set("shard", "test_key", "test_data")
+	cache.SetEx("test_key2", time.Hour, "test_data2")
+	cache.SetEx("test_key3", 0, "test_data3")
+
+	// second tick is received after the first sweep is done
+	clock.Advance(time.Minute)
+	clock.Advance(time.Minute)
+	cache.StopGC()
+
+	if n := sqlCount(t, cache); n != 2 {
+		t.Fatalf("row count should be 2, got: %d", n)
+	}
+}
+
+func TestShardedSQLCacheDeleteShard(t *testing.T) {
+	cache, err := NewSQLCache(openTestSQLite(t), SQLiteDialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	sharded := cache.Sharded()
+
+	cache.Set("test_key", "test_data")
+	sharded.Set("shard1", "test_key", "test_data")
+	sharded.Set("shard1", "test_key2", "test_data")
+	sharded.Set("shard2", "test_key", "test_data")
+
+	if err := sharded.DeleteShard("shard1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := sharded.Get("shard1", "test_key"); err != ErrNotFound {
+		t.Fatalf("error should equal to %q, got: %v", ErrNotFound, err)
+	}
+
+	if n := sqlCount(t, cache); n != 2 {
+		t.Fatalf("row count should be 2, got: %d", n)
+	}
+}
+
+func TestSQLCacheCodec(t *testing.T) {
+	type user struct {
+		Name string
+	}
+
+	cache, err := NewSQLCache(openTestSQLite(t), SQLiteDialect, WithSQLCodec(GobCodec), WithSQLTable("users"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	if err := cache.Set("test_key", user{Name: "koding"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var u user
+	if err := cache.GetInto("test_key", &u); err != nil || u.Name != "koding" {
+		t.Fatalf("user should be decoded, got: %+v, %v", u, err)
+	}
+
+	cache.Set("bytes", []byte("test_data"))
+	if data, err := cache.Get("bytes"); err != nil || string(data.([]byte)) != "test_data" {
+		t.Fatalf("data should equal to test_data, got: %v, %v", data, err)
+	}
+}
+
+func TestSQLDialectQuery(t *testing.T) {
+	q := "DELETE FROM cache WHERE shard = ? AND cache_key = ?"
+
+	if got := SQLiteDialect.query(q); got != q {
+		t.Fatalf("sqlite query should not be changed, got: %s", got)
+	}
+
+	want := "DELETE FROM cache WHERE shard = $1 AND cache_key = $2"
+	if got := PostgresDialect.query(q); got != want {
+		t.Fatalf("postgres query should equal to %q, got: %q", want, got)
+	}
+}
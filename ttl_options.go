@@ -0,0 +1,90 @@
+package cache
+
+import "github.com/koding/cache/clock"
+
+// defaultMaxNotFounds is the default limit of the keys cached as not found
+const defaultMaxNotFounds = 10000
+
+// TTLOption sets the options specified for the in-memory expiring caches,
+// MemoryTTL and ShardedTTL.
+type TTLOption func(*ttlOptions)
+
+// ttlOptions holds the configurable fields of the in-memory expiring caches
+type ttlOptions struct {
+	// clock is used for expiration of keys and garbage collection intervals
+	clock clock.Clock
+
+	// beta is the weight of the recompute cost for early expiration, keys
+	// are not expired early if it is 0
+	beta float64
+
+	// jitter is the maximum fraction of the ttl which is randomly cut from
+	// the ttl of every key
+	jitter float64
+
+	// maxNotFounds is the maximum number of keys cached as not found
+	maxNotFounds int
+}
+
+// WithClock sets the clock which is used for expiring keys and ticking the
+// garbage collector
+// usage:
+// NewMemoryWithTTL(time.Minute, WithClock(clocktest.NewFake(time.Now())))
+func WithClock(c clock.Clock) TTLOption {
+	return func(o *ttlOptions) {
+		o.clock = c
+	}
+}
+
+// WithEarlyRefresh enables the probabilistic early expiration of MemoryTTL,
+// Get reports a miss before a key expires with a probability which rises as
+// the expiry approaches. Probability is weighted by the recompute cost of the
+// key, which is set with SetWithCost, and beta; 1 is a good default and larger
+// values favour earlier recomputation. It is ignored by ShardedTTL
+// usage:
+// NewMemoryWithTTL(time.Minute, WithEarlyRefresh(1))
+func WithEarlyRefresh(beta float64) TTLOption {
+	return func(o *ttlOptions) {
+		o.beta = beta
+	}
+}
+
+// WithTTLJitter shortens the ttl of every key set to MemoryTTL by a random
+// duration up to the given fraction of it, e.g. 0.1 expires the keys in
+// 54-60 seconds for a minute of ttl. It is ignored by ShardedTTL
+// usage:
+// NewMemoryWithTTL(time.Minute, WithTTLJitter(0.1))
+func WithTTLJitter(fraction float64) TTLOption {
+	return func(o *ttlOptions) {
+		o.jitter = fraction
+	}
+}
+
+// WithMaxNotFounds sets the maximum number of keys MemoryTTL caches as not
+// found with SetNotFound, an arbitrary one of them is dropped for a new one when
+// the limit is reached. Default is 10000
+// usage:
+// NewMemoryWithTTL(time.Minute, WithMaxNotFounds(1000))
+func WithMaxNotFounds(n int) TTLOption {
+	if n <= 0 {
+		panic("invalid not found count")
+	}
+
+	return func(o *ttlOptions) {
+		o.maxNotFounds = n
+	}
+}
+
+// newTTLOptions applies the given options over the defaults
+func newTTLOptions(opts []TTLOption) *ttlOptions {
+	o := &ttlOptions{
+		clock:        clock.New(),
+		maxNotFounds: defaultMaxNotFounds,
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
@@ -0,0 +1,310 @@
+package cache
+
+import (
+	"sync"
+	"time"
+
+	"github.com/koding/cache/clock"
+)
+
+const (
+	defaultBatchSize     = 100
+	defaultFlushInterval = time.Second
+)
+
+// Write is a pending write of a key to the backing store
+type Write struct {
+	// Key is the key to be written
+	Key string
+
+	// Value is the last value set to the key
+	Value interface{}
+
+	// Deleted is true if the key is deleted, Value is nil then
+	Deleted bool
+}
+
+// Writer writes the batches of the dirty keys of a WriteBehindCache to the
+// backing store
+type Writer interface {
+	// WriteBatch writes the given batch, every key appears only once in it
+	WriteBatch(batch []Write) error
+}
+
+// WriteBehindCache is a write-back cache which buffers the writes in front of
+// a slower backing store. Set and Delete update the underlying cache
+// immediately and mark the key dirty, dirty keys are flushed to the Writer in
+// batches when the batch size is reached or at every flush interval. Writes
+// to the same key are coalesced, only the last one is flushed.
+//
+// Failed batches are kept dirty and retried with the next flush, unless the
+// key is written again meanwhile. Close flushes all the dirty keys and must be
+// called before exiting for not losing the writes, writes after Close return
+// ErrClosed
+type WriteBehindCache struct {
+	// Mutex guards dirty, err and closed. It is held while the underlying
+	// cache is written too, so the dirty write of a key is always its value
+	// in the underlying cache
+	sync.Mutex
+
+	// cache holds the underlying cache
+	cache Cache
+
+	// w writes the flushed batches
+	w Writer
+
+	// batchSize is the maximum number of keys in a batch, a flush is
+	// triggered when this many keys are dirty
+	batchSize int
+
+	// interval is the duration between the periodic flushes
+	interval time.Duration
+
+	// dirty holds the pending writes, indexed by key
+	dirty map[string]Write
+
+	// err holds the error of the last background flush
+	err error
+
+	// closed is set by Close
+	closed bool
+
+	// flushMu serializes the flushes, so an older write never overrides a
+	// newer one in the backing store
+	flushMu sync.Mutex
+
+	// clock is used for ticking the periodic flushes
+	clock clock.Clock
+
+	// trigger signals the flusher when the batch size is reached
+	trigger chan struct{}
+
+	// done stops the flusher
+	done chan struct{}
+
+	// wg waits for the flusher
+	wg sync.WaitGroup
+
+	// closeOnce guards Close
+	closeOnce sync.Once
+}
+
+// WriteBehindOption sets the options specified for WriteBehindCache.
+type WriteBehindOption func(*WriteBehindCache)
+
+// WithBatchSize sets the maximum number of keys in a batch, a flush is
+// triggered as soon as this many keys are dirty. Default is 100
+func WithBatchSize(n int) WriteBehindOption {
+	if n <= 0 {
+		panic("invalid batch size")
+	}
+
+	return func(c *WriteBehindCache) {
+		c.batchSize = n
+	}
+}
+
+// WithFlushInterval sets the duration between the periodic flushes, default
+// is a second
+func WithFlushInterval(d time.Duration) WriteBehindOption {
+	if d <= 0 {
+		panic("invalid flush interval")
+	}
+
+	return func(c *WriteBehindCache) {
+		c.interval = d
+	}
+}
+
+// WithFlushClock sets the clock which is used for ticking the periodic
+// flushes
+func WithFlushClock(clk clock.Clock) WriteBehindOption {
+	return func(c *WriteBehindCache) {
+		c.clock = clk
+	}
+}
+
+// NewWriteBehindCache creates a write-behind cache over the given cache and
+// starts its flusher, which must be stopped with Close
+// usage:
+// NewWriteBehindCache(NewMemory(), mongoCache, WithBatchSize(500))
+func NewWriteBehindCache(c Cache, w Writer, opts ...WriteBehindOption) *WriteBehindCache {
+	wb := &WriteBehindCache{
+		cache:     c,
+		w:         w,
+		batchSize: defaultBatchSize,
+		interval:  defaultFlushInterval,
+		dirty:     make(map[string]Write),
+		clock:     clock.New(),
+		trigger:   make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(wb)
+	}
+
+	wb.wg.Add(1)
+	go wb.flusher(wb.clock.NewTicker(wb.interval))
+
+	return wb
+}
+
+// Get returns the value of a given key from the underlying cache
+func (c *WriteBehindCache) Get(key string) (interface{}, error) {
+	return c.cache.Get(key)
+}
+
+// Set sets the value to the underlying cache and marks the key dirty
+func (c *WriteBehindCache) Set(key string, value interface{}) error {
+	return c.write(Write{Key: key, Value: value}, func() error {
+		return c.cache.Set(key, value)
+	})
+}
+
+// Delete deletes the key from the underlying cache and marks the key dirty
+func (c *WriteBehindCache) Delete(key string) error {
+	return c.write(Write{Key: key, Deleted: true}, func() error {
+		return c.cache.Delete(key)
+	})
+}
+
+// Dirty returns the number of the keys waiting to be flushed
+func (c *WriteBehindCache) Dirty() int {
+	c.Lock()
+	defer c.Unlock()
+
+	return len(c.dirty)
+}
+
+// Flush writes all the dirty keys to the Writer in batches, it returns the
+// first error. Keys of the failed batches are kept dirty
+func (c *WriteBehindCache) Flush() error {
+	c.flushMu.Lock()
+	defer c.flushMu.Unlock()
+
+	c.Lock()
+	dirty := c.dirty
+	c.dirty = make(map[string]Write)
+	c.Unlock()
+
+	if len(dirty) == 0 {
+		return nil
+	}
+
+	batch := make([]Write, 0, c.batchSize)
+	for _, w := range dirty {
+		batch = append(batch, w)
+		if len(batch) < c.batchSize {
+			continue
+		}
+
+		if err := c.w.WriteBatch(batch); err != nil {
+			return c.requeue(dirty, err)
+		}
+
+		for _, w := range batch {
+			delete(dirty, w.Key)
+		}
+
+		batch = batch[:0]
+	}
+
+	if len(batch) > 0 {
+		if err := c.w.WriteBatch(batch); err != nil {
+			return c.requeue(dirty, err)
+		}
+	}
+
+	return nil
+}
+
+// Err returns the error of the last background flush, it is nil if the last
+// background flush succeeded
+func (c *WriteBehindCache) Err() error {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.err
+}
+
+// Close stops the flusher and flushes all the dirty keys, it returns the
+// error of the final flush. It implements io.Closer
+func (c *WriteBehindCache) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		c.Lock()
+		c.closed = true
+		c.Unlock()
+
+		close(c.done)
+		c.wg.Wait()
+		err = c.Flush()
+	})
+
+	return err
+}
+
+// write calls f for writing the underlying cache and records the pending
+// write under the same lock, so concurrent writes of a key are recorded in the
+// order they are applied. A flush is triggered if the batch size is reached
+func (c *WriteBehindCache) write(w Write, f func() error) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+
+	if err := f(); err != nil {
+		return err
+	}
+
+	c.dirty[w.Key] = w
+	if len(c.dirty) < c.batchSize {
+		return nil
+	}
+
+	select {
+	case c.trigger <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// requeue marks the not flushed writes dirty again, unless the keys are
+// written again meanwhile
+func (c *WriteBehindCache) requeue(writes map[string]Write, err error) error {
+	c.Lock()
+	defer c.Unlock()
+
+	for key, w := range writes {
+		if _, ok := c.dirty[key]; !ok {
+			c.dirty[key] = w
+		}
+	}
+
+	return err
+}
+
+// flusher flushes the dirty keys at every tick or when triggered
+func (c *WriteBehindCache) flusher(ticker clock.Ticker) {
+	defer c.wg.Done()
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+		case <-c.trigger:
+		case <-c.done:
+			return
+		}
+
+		err := c.Flush()
+
+		c.Lock()
+		c.err = err
+		c.Unlock()
+	}
+}
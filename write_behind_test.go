@@ -0,0 +1,201 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/koding/cache/clock/clocktest"
+)
+
+// testWriter records the written batches, it fails while err is set
+type testWriter struct {
+	sync.Mutex
+	batches [][]Write
+	err     error
+}
+
+func (w *testWriter) WriteBatch(batch []Write) error {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.err != nil {
+		return w.err
+	}
+
+	w.batches = append(w.batches, append([]Write(nil), batch...))
+	return nil
+}
+
+// written returns the last writes of the keys, indexed by key
+func (w *testWriter) written() map[string]Write {
+	w.Lock()
+	defer w.Unlock()
+
+	writes := make(map[string]Write)
+	for _, batch := range w.batches {
+		for _, write := range batch {
+			writes[write.Key] = write
+		}
+	}
+
+	return writes
+}
+
+func (w *testWriter) setErr(err error) {
+	w.Lock()
+	defer w.Unlock()
+
+	w.err = err
+}
+
+func TestWriteBehindCacheGetSet(t *testing.T) {
+	cache := NewWriteBehindCache(NewMemory(), &testWriter{})
+	defer cache.Close()
+
+	testCacheGetSet(t, cache)
+}
+
+func TestWriteBehindCacheDelete(t *testing.T) {
+	cache := NewWriteBehindCache(NewMemory(), &testWriter{})
+	defer cache.Close()
+
+	testCacheDelete(t, cache)
+}
+
+func TestWriteBehindCacheClose(t *testing.T) {
+	w := &testWriter{}
+	cache := NewWriteBehindCache(NewMemory(), w, WithFlushClock(clocktest.NewFake(time.Now())))
+
+	cache.Set("test_key1", 1)
+	cache.Set("test_key1", 2)
+	cache.Set("test_key2", 1)
+	cache.Delete("test_key3")
+
+	if dirty := cache.Dirty(); dirty != 3 {
+		t.Fatalf("dirty key count should be 3, got: %d", dirty)
+	}
+
+	if len(w.written()) != 0 {
+		t.Fatal("keys should not be written before flush")
+	}
+
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(w.batches) != 1 {
+		t.Fatalf("keys should be written in a single batch, got: %d", len(w.batches))
+	}
+
+	writes := w.written()
+	if writes["test_key1"].Value != 2 {
+		t.Fatalf("last value of test_key1 should be written, got: %v", writes["test_key1"].Value)
+	}
+
+	if !writes["test_key3"].Deleted {
+		t.Fatal("test_key3 should be written as deleted")
+	}
+}
+
+func TestWriteBehindCacheClosed(t *testing.T) {
+	cache := NewWriteBehindCache(NewMemory(), &testWriter{})
+	cache.Close()
+
+	if err := cache.Set("test_key", "test_data"); err != ErrClosed {
+		t.Fatalf("set after close should give ErrClosed, got: %v", err)
+	}
+
+	if err := cache.Delete("test_key"); err != ErrClosed {
+		t.Fatalf("delete after close should give ErrClosed, got: %v", err)
+	}
+}
+
+func TestWriteBehindCacheConcurrentWrites(t *testing.T) {
+	w := &testWriter{}
+	cache := NewWriteBehindCache(NewMemory(), w, WithFlushClock(clocktest.NewFake(time.Now())))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				cache.Set("test_key", i*100+j)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	value, _ := cache.Get("test_key")
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// flushed value is the one in the cache, whichever writer is the last
+	if written := w.written()["test_key"].Value; written != value {
+		t.Fatalf("flushed value should be %v, got: %v", value, written)
+	}
+}
+
+func TestWriteBehindCacheBatchSize(t *testing.T) {
+	w := &testWriter{}
+	cache := NewWriteBehindCache(NewMemory(), w, WithBatchSize(2), WithFlushClock(clocktest.NewFake(time.Now())))
+	defer cache.Close()
+
+	cache.Set("test_key1", 1)
+	cache.Set("test_key2", 2)
+
+	waitFor(t, func() bool { return len(w.written()) == 2 })
+}
+
+func TestWriteBehindCacheInterval(t *testing.T) {
+	w := &testWriter{}
+	clock := clocktest.NewFake(time.Now())
+	cache := NewWriteBehindCache(NewMemory(), w, WithFlushClock(clock))
+	defer cache.Close()
+
+	cache.Set("test_key", 1)
+
+	// second tick is received after the first flush is done
+	clock.Advance(time.Second)
+	clock.Advance(time.Second)
+
+	if len(w.written()) != 1 {
+		t.Fatal("test_key should be flushed")
+	}
+}
+
+func TestWriteBehindCacheFlushError(t *testing.T) {
+	errWrite := errors.New("write failed")
+	w := &testWriter{err: errWrite}
+	cache := NewWriteBehindCache(NewMemory(), w, WithFlushClock(clocktest.NewFake(time.Now())))
+
+	cache.Set("test_key1", 1)
+	cache.Set("test_key2", 1)
+
+	if err := cache.Flush(); err != errWrite {
+		t.Fatalf("error should equal to %q, got: %v", errWrite, err)
+	}
+
+	if dirty := cache.Dirty(); dirty != 2 {
+		t.Fatalf("failed keys should be kept dirty, got: %d", dirty)
+	}
+
+	cache.Set("test_key1", 2)
+
+	if err := cache.Close(); err != errWrite {
+		t.Fatalf("error should equal to %q, got: %v", errWrite, err)
+	}
+
+	w.setErr(nil)
+	if err := cache.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if writes := w.written(); writes["test_key1"].Value != 2 || len(writes) != 2 {
+		t.Fatalf("dirty keys should be written, got: %v", writes)
+	}
+}
@@ -0,0 +1,41 @@
+package cache
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// randFloat64 returns a pseudo-random number in [0.0,1.0), it is a variable so
+// the tests can make the early expiration deterministic
+var randFloat64 = rand.Float64
+
+// expiresEarly reports whether a key which expires at expireAt should be
+// treated as expired at now, so it is recomputed before its actual expiry.
+// It implements the probabilistic early expiration of XFetch: probability
+// rises as the expiry approaches and it is weighted by the recompute cost of
+// the key, beta greater than 1 favours earlier recomputation. Keys are never
+// expired early if beta or cost is not positive
+func expiresEarly(now, expireAt time.Time, cost time.Duration, beta float64) bool {
+	if beta <= 0 || cost <= 0 {
+		return false
+	}
+
+	// 1-randFloat64() is in (0.0,1.0], so the log is never infinite
+	gap := -float64(cost) * beta * math.Log(1-randFloat64())
+	return !now.Add(time.Duration(gap)).Before(expireAt)
+}
+
+// jitterTTL shortens the ttl by a random duration up to the given fraction of
+// it, so the keys set at the same time do not expire at the same time
+func jitterTTL(ttl time.Duration, fraction float64) time.Duration {
+	if fraction <= 0 || ttl <= 0 {
+		return ttl
+	}
+
+	if fraction > 1 {
+		fraction = 1
+	}
+
+	return ttl - time.Duration(float64(ttl)*fraction*randFloat64())
+}
@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+// withRand makes randFloat64 return the given value until the returned
+// function is called
+func withRand(f float64) func() {
+	orig := randFloat64
+	randFloat64 = func() float64 { return f }
+	return func() { randFloat64 = orig }
+}
+
+func TestExpiresEarly(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		expireAt time.Time
+		cost     time.Duration
+		beta     float64
+		rand     float64
+		early    bool
+	}{
+		{"no cost", now.Add(time.Millisecond), 0, 1, 0.99, false},
+		{"no beta", now.Add(time.Millisecond), time.Second, 0, 0.99, false},
+		{"far from expiry", now.Add(time.Hour), time.Second, 1, 0.99, false},
+		// -ln(1-0.99) is ~4.6, so the gap is ~4.6s
+		{"close to expiry", now.Add(4 * time.Second), time.Second, 1, 0.99, true},
+		{"close to expiry unlucky", now.Add(4 * time.Second), time.Second, 1, 0.5, false},
+		{"larger beta", now.Add(4 * time.Second), time.Second, 10, 0.5, true},
+		{"expensive key", now.Add(4 * time.Second), 10 * time.Second, 1, 0.5, true},
+		{"expired", now, time.Second, 1, 0, true},
+	}
+
+	for _, test := range tests {
+		restore := withRand(test.rand)
+		early := expiresEarly(now, test.expireAt, test.cost, test.beta)
+		restore()
+
+		if early != test.early {
+			t.Errorf("%s: early should be %t, got: %t", test.name, test.early, early)
+		}
+	}
+}
+
+func TestJitterTTL(t *testing.T) {
+	defer withRand(0.5)()
+
+	if ttl := jitterTTL(time.Minute, 0); ttl != time.Minute {
+		t.Fatalf("ttl should not be changed without jitter, got: %s", ttl)
+	}
+
+	if ttl := jitterTTL(time.Minute, 0.2); ttl != 54*time.Second {
+		t.Fatalf("ttl should be 54s, got: %s", ttl)
+	}
+
+	if ttl := jitterTTL(time.Minute, 2); ttl != 30*time.Second {
+		t.Fatalf("jitter should be capped with the ttl, got: %s", ttl)
+	}
+}